@@ -0,0 +1,165 @@
+package polaris
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoApprover = errors.New("no approver")
+)
+
+// ApprovalRequest is the tool call waiting for the operator
+type ApprovalRequest struct {
+	Tool   string         `json:"tool"`
+	Args   map[string]any `json:"args"`
+	Reason string         `json:"reason,omitempty"`
+}
+
+type Approval struct {
+	Approved bool   `json:"approved"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Approver asks the operator whether the tool call that RequiresApproval proceeds,
+// LiveSession asks one call at a time
+type Approver interface {
+	Approve(ctx context.Context, req ApprovalRequest) (Approval, error)
+}
+
+type ApproverFunc func(context.Context, ApprovalRequest) (Approval, error)
+
+func (f ApproverFunc) Approve(ctx context.Context, req ApprovalRequest) (Approval, error) {
+	return f(ctx, req)
+}
+
+// UseApprover sets Approver of the tools that RequiresApproval, calls of such tools are denied without Approver
+func UseApprover(approver Approver) UseOptionFunc {
+	return func(o *UseOption) {
+		o.Approver = approver
+	}
+}
+
+// NewPromptApprover asks the operator on the terminal, e.g. NewPromptApprover(os.Stdin, os.Stderr).
+// "y" or "yes" approves, other answers deny and are sent to the model as comment
+func NewPromptApprover(in io.Reader, out io.Writer) Approver {
+	mutex := new(sync.Mutex)
+	scanner := bufio.NewScanner(in)
+	return ApproverFunc(func(ctx context.Context, req ApprovalRequest) (Approval, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		args, err := json.Marshal(req.Args)
+		if err != nil {
+			return Approval{}, errors.WithStack(err)
+		}
+		fmt.Fprintf(out, "approval required: %s %s\n", req.Tool, args)
+		if req.Reason != "" {
+			fmt.Fprintf(out, "reason: %s\n", req.Reason)
+		}
+		fmt.Fprint(out, "approve? [y/N]: ")
+		if scanner.Scan() != true {
+			if err := scanner.Err(); err != nil {
+				return Approval{}, errors.WithStack(err)
+			}
+			return Approval{}, errors.WithStack(io.EOF)
+		}
+		answer := strings.TrimSpace(scanner.Text())
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return Approval{Approved: true}, nil
+		case "", "n", "no":
+			return Approval{Approved: false}, nil
+		}
+		return Approval{Approved: false, Comment: answer}, nil
+	})
+}
+
+// NewNATSApprover asks the approval service subscribing topic, e.g. by Conn.ServeApprover.
+// timeout is how long to wait for the operator
+func NewNATSApprover(conn *Conn, topic string, timeout time.Duration) Approver {
+	enc := JSONEncoder[ApprovalRequest]()
+	dec := JSONEncoder[Approval]()
+	return ApproverFunc(func(ctx context.Context, req ApprovalRequest) (Approval, error) {
+		data, err := enc.Encode(req)
+		if err != nil {
+			return Approval{}, errors.WithStack(err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		msg, err := conn.nc.RequestWithContext(ctx, topic, data)
+		if err != nil {
+			return Approval{}, errors.WithStack(err)
+		}
+		approval, err := dec.Decode(msg.Data)
+		if err != nil {
+			return Approval{}, errors.WithStack(err)
+		}
+		return approval, nil
+	})
+}
+
+// ServeApprover answers approval requests of NewNATSApprover on topic by approver
+func (c *Conn) ServeApprover(topic string, approver Approver) error {
+	return subscribeReqResp(
+		c,
+		topic,
+		JSONEncoder[ApprovalRequest](),
+		JSONEncoder[Approval](),
+		func(req ApprovalRequest) Approval {
+			approval, err := approver.Approve(c.ctx, req)
+			if err != nil {
+				c.logger.Warnf("approve %s: %+v", req.Tool, err)
+				return Approval{Approved: false, Comment: err.Error()}
+			}
+			return approval
+		},
+	)
+}
+
+// approve pauses the call until the operator decides, denied response is returned when not approved
+func (s *LiveSession) approve(name string, args map[string]any, reason string) (map[string]any, bool) {
+	return approveCall(s.ctx, s.opt.Approver, s.approvalMutex, s.logger, ApprovalRequest{name, args, reason})
+}
+
+// approveCall asks approver one request at a time by mutex, the call is denied without approver
+func approveCall(ctx context.Context, approver Approver, mutex *sync.Mutex, logger Logger, req ApprovalRequest) (map[string]any, bool) {
+	if approver == nil {
+		logger.Warnf("%s requires approval: %+v", req.Tool, ErrNoApprover)
+		return deniedByOperatorResp(req.Tool, ErrNoApprover.Error()), false
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	approval, err := approver.Approve(ctx, req)
+	if err != nil {
+		logger.Warnf("approve %s: %+v", req.Tool, err)
+		return deniedByOperatorResp(req.Tool, err.Error()), false
+	}
+	if approval.Approved != true {
+		logger.Debugf("%s is denied by operator: %s", req.Tool, approval.Comment)
+		return deniedByOperatorResp(req.Tool, approval.Comment), false
+	}
+	return nil, true
+}
+
+func deniedByOperatorResp(name, comment string) map[string]any {
+	msg := fmt.Sprintf("%s is denied by operator, do not retry without asking the user", name)
+	if comment != "" {
+		msg += ": " + comment
+	}
+	return map[string]any{
+		"_error":              msg,
+		"_denied_by_operator": true,
+	}
+}
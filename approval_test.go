@@ -0,0 +1,333 @@
+package polaris
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/pkg/errors"
+)
+
+type testRemoteCall struct {
+	mutex  *sync.Mutex
+	called []string
+}
+
+func (*testRemoteCall) setLogger(Logger) {}
+
+func (*testRemoteCall) setDefaultArgsFunc(func() map[string]any) {}
+
+func (*testRemoteCall) setSession(string, string) {}
+
+func (r *testRemoteCall) callFunction(name string, args map[string]any) (map[string]any, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.called = append(r.called, name)
+	return map[string]any{"ok": true}, nil
+}
+
+func testApprovalSession(approver Approver) (*LiveSession, *testRemoteCall) {
+	rc := &testRemoteCall{mutex: new(sync.Mutex)}
+	opt := &UseOption{}
+	if approver != nil {
+		UseApprover(approver)(opt)
+	}
+	return &LiveSession{
+		ctx:           context.Background(),
+		opt:           opt,
+		logger:        &stdLogger{log.New(io.Discard, "", 0), false},
+		rc:            rc,
+		approvals:     map[string]string{"restart_service": "restarts production"},
+		approvalMutex: new(sync.Mutex),
+	}, rc
+}
+
+func TestLiveSessionApproval(t *testing.T) {
+	tests := []struct {
+		name     string
+		approver Approver
+		tool     string
+		approved bool
+		comment  string
+	}{
+		{
+			name:     "not required",
+			approver: nil,
+			tool:     "get_status",
+			approved: true,
+		},
+		{
+			name: "approved",
+			approver: ApproverFunc(func(ctx context.Context, req ApprovalRequest) (Approval, error) {
+				return Approval{Approved: true}, nil
+			}),
+			tool:     "restart_service",
+			approved: true,
+		},
+		{
+			name: "denied",
+			approver: ApproverFunc(func(ctx context.Context, req ApprovalRequest) (Approval, error) {
+				return Approval{Approved: false, Comment: "maintenance window only"}, nil
+			}),
+			tool:     "restart_service",
+			approved: false,
+			comment:  "maintenance window only",
+		},
+		{
+			name: "approver error",
+			approver: ApproverFunc(func(ctx context.Context, req ApprovalRequest) (Approval, error) {
+				return Approval{}, errors.New("operator unreachable")
+			}),
+			tool:     "restart_service",
+			approved: false,
+			comment:  "operator unreachable",
+		},
+		{
+			name:     "no approver",
+			approver: nil,
+			tool:     "restart_service",
+			approved: false,
+			comment:  ErrNoApprover.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, rc := testApprovalSession(tt.approver)
+			resp, err := s.callFunction(tt.tool, map[string]any{"name": "api"})
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			if tt.approved {
+				if resp["ok"] != true || len(rc.called) != 1 {
+					t.Errorf("must be called: resp=%v called=%v", resp, rc.called)
+				}
+				return
+			}
+			if len(rc.called) != 0 {
+				t.Errorf("denied tool must not be called: %v", rc.called)
+			}
+			if resp["_denied_by_operator"] != true {
+				t.Errorf("must be denied: %v", resp)
+			}
+			if msg, _ := resp["_error"].(string); strings.Contains(msg, "denied by operator") != true || strings.Contains(msg, tt.comment) != true {
+				t.Errorf("_error = %v", resp["_error"])
+			}
+		})
+	}
+	t.Run("request", func(t *testing.T) {
+		reqs := make(chan ApprovalRequest, 1)
+		s, _ := testApprovalSession(ApproverFunc(func(ctx context.Context, req ApprovalRequest) (Approval, error) {
+			reqs <- req
+			return Approval{Approved: true}, nil
+		}))
+		if _, err := s.callFunction("restart_service", map[string]any{"name": "api"}); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		req := <-reqs
+		if req.Tool != "restart_service" || req.Args["name"] != "api" || req.Reason != "restarts production" {
+			t.Errorf("actual=%+v", req)
+		}
+	})
+}
+
+func TestPromptApprover(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		approved bool
+		comment  string
+	}{
+		{"yes", "y\n", true, ""},
+		{"yes upper", "YES\n", true, ""},
+		{"empty", "\n", false, ""},
+		{"no", "n\n", false, ""},
+		{"comment", "not now\n", false, "not now"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := bytes.NewBuffer(nil)
+			approver := NewPromptApprover(strings.NewReader(tt.input), out)
+			approval, err := approver.Approve(context.Background(), ApprovalRequest{"delete_files", map[string]any{"path": "/tmp"}, "deletes files"})
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			if approval.Approved != tt.approved || approval.Comment != tt.comment {
+				t.Errorf("actual=%+v", approval)
+			}
+			if strings.Contains(out.String(), `delete_files {"path":"/tmp"}`) != true || strings.Contains(out.String(), "deletes files") != true {
+				t.Errorf("prompt = %s", out.String())
+			}
+		})
+	}
+	t.Run("eof", func(t *testing.T) {
+		approver := NewPromptApprover(strings.NewReader(""), io.Discard)
+		if _, err := approver.Approve(context.Background(), ApprovalRequest{Tool: "delete_files"}); err == nil {
+			t.Errorf("must be error")
+		}
+	})
+}
+
+func TestNATSApprover(t *testing.T) {
+	r, conn := testRegistry(t)
+
+	operator, err := Connect(NatsURL(r.ns.ClientURL()), Name("operator"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(operator.Close)
+
+	err = operator.ServeApprover("approval.test", ApproverFunc(func(ctx context.Context, req ApprovalRequest) (Approval, error) {
+		if req.Args["force"] == true {
+			return Approval{Approved: false, Comment: "force is not allowed"}, nil
+		}
+		return Approval{Approved: true}, nil
+	}))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	approver := NewNATSApprover(conn, "approval.test", 5*time.Second)
+	tests := []struct {
+		name   string
+		args   map[string]any
+		expect Approval
+	}{
+		{"approved", map[string]any{"force": false}, Approval{Approved: true}},
+		{"denied", map[string]any{"force": true}, Approval{Approved: false, Comment: "force is not allowed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			approval, err := approver.Approve(context.Background(), ApprovalRequest{Tool: "delete_files", Args: tt.args})
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			if approval != tt.expect {
+				t.Errorf("actual=%+v expect=%+v", approval, tt.expect)
+			}
+		})
+	}
+	t.Run("no service", func(t *testing.T) {
+		approver := NewNATSApprover(conn, "approval.none", 100*time.Millisecond)
+		if _, err := approver.Approve(context.Background(), ApprovalRequest{Tool: "delete_files"}); err == nil {
+			t.Errorf("must be error")
+		}
+	})
+}
+
+func TestListToolsApproval(t *testing.T) {
+	_, conn := testRegistry(t)
+
+	tool := testOwnerTool("restart_service", "owner")
+	tool.RequiresApproval = true
+	tool.ApprovalReason = "restarts production"
+	if err := conn.RegisterTool(tool); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	declares, err := conn.listTools(true)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if len(declares) != 1 || declares[0].RequiresApproval != true || declares[0].ApprovalReason != "restarts production" {
+		t.Errorf("approval must be listed: %+v", declares)
+	}
+}
+
+func TestMCPServerApproval(t *testing.T) {
+	r, toolConn := testRegistry(t)
+
+	called := make(chan struct{}, 10)
+	tool := testOwnerTool("restart_service", "owner")
+	tool.RequiresApproval = true
+	tool.ApprovalReason = "restarts production"
+	tool.Handler = func(*ReqCtx) (Resp, error) {
+		called <- struct{}{}
+		return Resp{"ok": true}, nil
+	}
+	if err := toolConn.RegisterTool(tool); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("mcp-server"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	tests := []struct {
+		name     string
+		approver Approver
+		approved bool
+		comment  string
+	}{
+		{
+			name: "approved",
+			approver: ApproverFunc(func(ctx context.Context, req ApprovalRequest) (Approval, error) {
+				if req.Tool != "restart_service" || req.Reason != "restarts production" || req.Args["name"] != "api" {
+					return Approval{}, errors.Errorf("unexpected request: %+v", req)
+				}
+				return Approval{Approved: true}, nil
+			}),
+			approved: true,
+		},
+		{
+			name: "denied",
+			approver: ApproverFunc(func(ctx context.Context, req ApprovalRequest) (Approval, error) {
+				return Approval{Approved: false, Comment: "maintenance window only"}, nil
+			}),
+			approved: false,
+			comment:  "maintenance window only",
+		},
+		{
+			name:     "no approver",
+			approver: nil,
+			approved: false,
+			comment:  ErrNoApprover.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := []MCPServerOptionFunc{}
+			if tt.approver != nil {
+				options = append(options, MCPServerApprover(tt.approver))
+			}
+			s, err := conn.NewMCPServer(options...)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+
+			req := mcp.CallToolRequest{}
+			req.Params.Name = "restart_service"
+			req.Params.Arguments = map[string]any{"name": "api"}
+			res, err := s.handleCallTool(context.Background(), req)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			if tt.approved {
+				if res.IsError {
+					t.Errorf("must be called: %v", res.Content)
+				}
+				select {
+				case <-called:
+				case <-time.After(time.Second):
+					t.Errorf("must be called")
+				}
+				return
+			}
+			text := res.Content[0].(mcp.TextContent).Text
+			if res.IsError != true || strings.Contains(text, "denied by operator") != true || strings.Contains(text, tt.comment) != true {
+				t.Errorf("must be denied: %v", res.Content)
+			}
+			select {
+			case <-called:
+				t.Errorf("denied tool must not be called")
+			default:
+			}
+		})
+	}
+}
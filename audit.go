@@ -0,0 +1,333 @@
+package polaris
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SessionHeader and ModelHeader carry the LiveSession that called the tool
+	SessionHeader = "Polaris-Session"
+	ModelHeader   = "Polaris-Model"
+	// CallerHeader carries name of the Conn that called the tool
+	CallerHeader = "Polaris-Caller"
+	// TimeoutHeader carries ReqTimeout of the Conn that called the tool, relay of shared tool waits for it
+	TimeoutHeader = "Polaris-Timeout"
+
+	RedactedValue = "[REDACTED]"
+
+	// auditQueueSize is the number of records waiting for sinks, records are dropped when the queue is full
+	auditQueueSize = 1024
+)
+
+type AuditSide string
+
+const (
+	// AuditCaller is recorded by the Conn that called the tool
+	AuditCaller AuditSide = "caller"
+	// AuditAgent is recorded by the Conn that registered the tool
+	AuditAgent AuditSide = "agent"
+)
+
+// AuditRecord is a tool call, Args are redacted by AuditRedactor and the result is recorded only by its size.
+// Caller is the name the calling Conn declared and is not verified. Identity is verified by Authorizer on AuditAgent,
+// and is the identity the Conn presents on AuditCaller
+type AuditRecord struct {
+	Time       time.Time      `json:"time"`
+	Side       AuditSide      `json:"side"`
+	Conn       string         `json:"conn"`
+	SessionID  string         `json:"session_id,omitempty"`
+	Model      string         `json:"model,omitempty"`
+	Tool       string         `json:"tool"`
+	Args       map[string]any `json:"args,omitempty"`
+	ResultSize int            `json:"result_size"`
+	Error      string         `json:"error,omitempty"`
+	Latency    time.Duration  `json:"latency_ns"`
+	Caller     string         `json:"caller,omitempty"`
+	Identity   string         `json:"identity,omitempty"`
+}
+
+// AuditSink stores AuditRecord in background of the tool call, error of the sink is logged and does not fail the tool call
+type AuditSink interface {
+	WriteAudit(AuditRecord) error
+}
+
+type AuditSinkFunc func(AuditRecord) error
+
+func (f AuditSinkFunc) WriteAudit(r AuditRecord) error {
+	return f(r)
+}
+
+// AuditRedactor returns args to record, args must not be modified
+type AuditRedactor func(tool string, args map[string]any) map[string]any
+
+// RedactKeys replaces values of the keys(case insensitive) in args and nested objects with RedactedValue
+func RedactKeys(keys ...string) AuditRedactor {
+	return func(tool string, args map[string]any) map[string]any {
+		redacted, _ := redactValue(args, keys).(map[string]any)
+		return redacted
+	}
+}
+
+func redactValue(v any, keys []string) any {
+	switch vv := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(vv))
+		for k, value := range vv {
+			if containsFold(keys, k) {
+				m[k] = RedactedValue
+				continue
+			}
+			m[k] = redactValue(value, keys)
+		}
+		return m
+	case []any:
+		list := make([]any, len(vv))
+		for i, value := range vv {
+			list[i] = redactValue(value, keys)
+		}
+		return list
+	}
+	return v
+}
+
+// ConnectAudit records tool calls of the Conn, both as caller and as agent, to the sinks
+func ConnectAudit(sinks ...AuditSink) ConnectOptionFunc {
+	return func(o *ConnectOption) {
+		o.AuditSinks = append(o.AuditSinks, sinks...)
+	}
+}
+
+func ConnectAuditRedactor(redactor AuditRedactor) ConnectOptionFunc {
+	return func(o *ConnectOption) {
+		o.AuditRedactor = redactor
+	}
+}
+
+// UseSessionID sets ID of the session recorded in AuditRecord, defaults to generated ID
+func UseSessionID(id string) UseOptionFunc {
+	return func(o *UseOption) {
+		o.SessionID = id
+	}
+}
+
+// JSONLAuditSink writes a record per line
+type JSONLAuditSink struct {
+	mutex *sync.Mutex
+	w     io.Writer
+}
+
+func NewJSONLAuditSink(w io.Writer) *JSONLAuditSink {
+	return &JSONLAuditSink{new(sync.Mutex), w}
+}
+
+// OpenJSONLAuditFile appends records to the file
+func OpenJSONLAuditFile(path string) (*JSONLAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return NewJSONLAuditSink(f), nil
+}
+
+func (s *JSONLAuditSink) WriteAudit(r AuditRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (s *JSONLAuditSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return errors.WithStack(c.Close())
+	}
+	return nil
+}
+
+// NewNATSAuditSink publishes records to subject, records are lost without subscribers
+func NewNATSAuditSink(conn *Conn, subject string) AuditSink {
+	return AuditSinkFunc(func(r AuditRecord) error {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(conn.nc.Publish(subject, data))
+	})
+}
+
+// NewJetStreamAuditSink persists records in the stream, the stream is created for subject if it does not exist
+func NewJetStreamAuditSink(ctx context.Context, conn *Conn, stream, subject string) (AuditSink, error) {
+	js, err := jetstream.New(conn.nc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := js.Stream(ctx, stream); err != nil {
+		if errors.Is(err, jetstream.ErrStreamNotFound) != true {
+			return nil, errors.Wrapf(err, "stream %s", stream)
+		}
+		if _, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: stream, Subjects: []string{subject}}); err != nil {
+			return nil, errors.Wrapf(err, "create stream %s", stream)
+		}
+	}
+	return AuditSinkFunc(func(r AuditRecord) error {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), conn.opt.ReqTimeout)
+		defer cancel()
+
+		if _, err := js.Publish(ctx, subject, data); err != nil {
+			return errors.WithStack(err)
+		}
+		return nil
+	}), nil
+}
+
+// callMsg is the message of tool call from this Conn, it is signed by the key of identity
+func (c *Conn) callMsg(name string, args map[string]any, sessionID, model string, identity callerIdentity) (*nats.Msg, error) {
+	data, err := JSONEncoder[map[string]any]().Encode(args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	msg := &nats.Msg{Subject: tooltopic(name), Header: nats.Header{}, Data: data}
+	msg.Header.Set(CallerHeader, c.natsOpt.Name)
+	msg.Header.Set(TimeoutHeader, c.opt.ReqTimeout.String())
+	if sessionID != "" {
+		msg.Header.Set(SessionHeader, sessionID)
+	}
+	if model != "" {
+		msg.Header.Set(ModelHeader, model)
+	}
+	if identity.key != nil {
+		msg.Header.Set(IdentityHeader, identity.token)
+		if err := signIdentityProof(msg, identity.key); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return msg, nil
+}
+
+// toolCallHandler authorizes and audits calls of the tool registered by this Conn
+func (c *Conn) toolCallHandler(t Tool, handler reqrespHandler[map[string]any, map[string]any]) reqrespMsgHandler[map[string]any, map[string]any] {
+	return func(msg *nats.Msg, req map[string]any) map[string]any {
+		start := time.Now()
+		id, resp, ok := c.authorize(t, msg)
+		if ok {
+			resp = handler(req)
+		}
+		c.audit(AuditAgent, msg.Header, id.Subject, t.Name, req, resp, nil, time.Since(start))
+		return resp
+	}
+}
+
+// audit queues the record for sinks, the tool call does not wait for sinks
+func (c *Conn) audit(side AuditSide, header nats.Header, identity string, tool string, args, resp map[string]any, callErr error, latency time.Duration) {
+	if len(c.opt.AuditSinks) < 1 {
+		return
+	}
+
+	r := AuditRecord{
+		Time:      time.Now(),
+		Side:      side,
+		Conn:      c.natsOpt.Name,
+		SessionID: header.Get(SessionHeader),
+		Model:     header.Get(ModelHeader),
+		Tool:      tool,
+		Args:      args,
+		Latency:   latency,
+		Caller:    header.Get(CallerHeader),
+		Identity:  identity,
+	}
+	if c.opt.AuditRedactor != nil {
+		r.Args = c.opt.AuditRedactor(tool, args)
+	}
+	if resp != nil {
+		if data, err := json.Marshal(resp); err == nil {
+			r.ResultSize = len(data)
+		}
+		if errValue, ok := resp["_error"]; ok {
+			r.Error = fmt.Sprintf("%v", errValue)
+		}
+	}
+	if callErr != nil {
+		r.Error = callErr.Error()
+	}
+
+	select {
+	case c.auditQueue <- r:
+	default:
+		c.logger.Warnf("audit %s: queue is full, record is dropped", tool)
+	}
+}
+
+// auditLoop writes queued records to sinks, records queued before ctx is done are written
+func (c *Conn) auditLoop(ctx context.Context) {
+	defer close(c.auditDone)
+
+	for {
+		select {
+		case r := <-c.auditQueue:
+			c.writeAudit(r)
+		case <-ctx.Done():
+			for {
+				select {
+				case r := <-c.auditQueue:
+					c.writeAudit(r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Conn) writeAudit(r AuditRecord) {
+	for _, sink := range c.opt.AuditSinks {
+		if err := sink.WriteAudit(r); err != nil {
+			c.logger.Warnf("audit %s: %+v", r.Tool, err)
+		}
+	}
+}
+
+// presentedSubject is subject of the identity token the Conn presents
+func presentedSubject(token string) string {
+	if token == "" {
+		return ""
+	}
+	if claims, err := jwt.DecodeUserClaims(token); err == nil {
+		if claims.Name != "" {
+			return claims.Name
+		}
+		return claims.Subject
+	}
+	payload, _, _ := strings.Cut(token, ".")
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ""
+	}
+	id := Identity{}
+	if err := json.Unmarshal(data, &id); err != nil {
+		return ""
+	}
+	return id.Subject
+}
@@ -0,0 +1,381 @@
+package polaris
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
+)
+
+type testAuditSink struct {
+	mutex   *sync.Mutex
+	records []AuditRecord
+}
+
+func newTestAuditSink() *testAuditSink {
+	return &testAuditSink{mutex: new(sync.Mutex)}
+}
+
+func (s *testAuditSink) WriteAudit(r AuditRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *testAuditSink) Records() []AuditRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]AuditRecord(nil), s.records...)
+}
+
+// WaitRecords waits n records written in background
+func (s *testAuditSink) WaitRecords(t *testing.T, n int) []AuditRecord {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if records := s.Records(); n <= len(records) {
+			return records
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("records = %+v", s.Records())
+	return nil
+}
+
+func TestRedactKeys(t *testing.T) {
+	redact := RedactKeys("password", "token")
+	tests := []struct {
+		name   string
+		args   map[string]any
+		expect map[string]any
+	}{
+		{
+			name:   "no secret",
+			args:   map[string]any{"user": "alice"},
+			expect: map[string]any{"user": "alice"},
+		},
+		{
+			name:   "top level",
+			args:   map[string]any{"user": "alice", "password": "p@ss"},
+			expect: map[string]any{"user": "alice", "password": RedactedValue},
+		},
+		{
+			name:   "case insensitive",
+			args:   map[string]any{"Token": "xxx"},
+			expect: map[string]any{"Token": RedactedValue},
+		},
+		{
+			name: "nested",
+			args: map[string]any{
+				"auth":  map[string]any{"token": "xxx", "kind": "bearer"},
+				"users": []any{map[string]any{"name": "bob", "password": "p@ss"}},
+			},
+			expect: map[string]any{
+				"auth":  map[string]any{"token": RedactedValue, "kind": "bearer"},
+				"users": []any{map[string]any{"name": "bob", "password": RedactedValue}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact("login", tt.args); reflect.DeepEqual(got, tt.expect) != true {
+				t.Errorf("actual=%v expect=%v", got, tt.expect)
+			}
+		})
+	}
+	t.Run("not modified", func(t *testing.T) {
+		args := map[string]any{"password": "p@ss"}
+		redact("login", args)
+		if args["password"] != "p@ss" {
+			t.Errorf("args must not be modified: %v", args)
+		}
+	})
+}
+
+func TestAudit(t *testing.T) {
+	r, _ := testRegistry(t)
+	issuer, issuerPub := testNKey(t, nkeys.CreateAccount)
+	token, seed := testIdentity(t, issuer, Identity{Subject: "alice"})
+
+	agentSink := newTestAuditSink()
+	agent, err := Connect(NatsURL(r.ns.ClientURL()), Name("agent"), ConnectAudit(agentSink), ConnectAuthorizer(NewTokenAuthorizer(issuerPub)))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(agent.Close)
+	if err := agent.RegisterTool(testOwnerTool("login", "agent")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	failTool := testOwnerTool("fail", "agent")
+	failTool.Handler = func(r *ReqCtx) (Resp, error) {
+		return nil, os.ErrPermission
+	}
+	if err := agent.RegisterTool(failTool); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	callerSink := newTestAuditSink()
+	caller, err := Connect(
+		NatsURL(r.ns.ClientURL()),
+		Name("caller"),
+		ConnectIdentity(token, seed),
+		ConnectAudit(callerSink),
+		ConnectAuditRedactor(RedactKeys("password")),
+	)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(caller.Close)
+
+	rc := &defaultRemoteCall{caller, nil, nil, "", "", caller.identity}
+	rc.setSession("session-1", "gemini-test")
+	if _, err := rc.callFunction("login", map[string]any{"password": "p@ss"}); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if _, err := rc.callFunction("fail", map[string]any{}); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	tests := []struct {
+		name     string
+		sink     *testAuditSink
+		side     AuditSide
+		conn     string
+		password any
+	}{
+		{"caller", callerSink, AuditCaller, "caller", RedactedValue},
+		{"agent", agentSink, AuditAgent, "agent", "p@ss"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := tt.sink.WaitRecords(t, 2)
+			if len(records) != 2 {
+				t.Fatalf("records = %+v", records)
+			}
+			login, fail := records[0], records[1]
+			if login.Side != tt.side || login.Conn != tt.conn || login.Tool != "login" {
+				t.Errorf("actual=%+v", login)
+			}
+			if login.SessionID != "session-1" || login.Model != "gemini-test" {
+				t.Errorf("session must be recorded: %+v", login)
+			}
+			if login.Caller != "caller" || login.Identity != "alice" {
+				t.Errorf("caller must be recorded: %+v", login)
+			}
+			if login.Args["password"] != tt.password {
+				t.Errorf("args = %v", login.Args)
+			}
+			if login.ResultSize != len(`{"owner":"agent"}`) || login.Error != "" || login.Latency <= 0 {
+				t.Errorf("result must be recorded: %+v", login)
+			}
+			if fail.Tool != "fail" || fail.Error != os.ErrPermission.Error() {
+				t.Errorf("error must be recorded: %+v", fail)
+			}
+		})
+	}
+}
+
+func TestAuditUnverifiedIdentity(t *testing.T) {
+	r, _ := testRegistry(t)
+	issuer, _ := testNKey(t, nkeys.CreateAccount)
+	token, seed := testIdentity(t, issuer, Identity{Subject: "alice"})
+
+	// agent without Authorizer does not verify the identity
+	agentSink := newTestAuditSink()
+	agent, err := Connect(NatsURL(r.ns.ClientURL()), Name("agent"), ConnectAudit(agentSink))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(agent.Close)
+	if err := agent.RegisterTool(testOwnerTool("login", "agent")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	caller, err := Connect(NatsURL(r.ns.ClientURL()), Name("caller"), ConnectIdentity(token, seed))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(caller.Close)
+	if _, err := caller.Call(context.Background(), "login", Req{}); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	records := agentSink.WaitRecords(t, 1)
+	if records[0].Identity != "" {
+		t.Errorf("unverified identity must not be recorded: %+v", records[0])
+	}
+}
+
+func TestAuditSinkInBackground(t *testing.T) {
+	r, _ := testRegistry(t)
+
+	release := make(chan struct{})
+	written := make(chan AuditRecord, 1)
+	blocking := AuditSinkFunc(func(r AuditRecord) error {
+		<-release
+		written <- r
+		return nil
+	})
+	agent, err := Connect(NatsURL(r.ns.ClientURL()), Name("agent"), ConnectAudit(blocking))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(agent.Close)
+	if err := agent.RegisterTool(testOwnerTool("login", "agent")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	caller, err := Connect(NatsURL(r.ns.ClientURL()), Name("caller"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(caller.Close)
+	resp, err := caller.Call(context.Background(), "login", Req{})
+	if err != nil {
+		t.Fatalf("call must not wait for sink: %+v", err)
+	}
+	if resp["owner"] != "agent" {
+		t.Errorf("resp = %v", resp)
+	}
+
+	close(release)
+	select {
+	case rec := <-written:
+		if rec.Tool != "login" {
+			t.Errorf("actual=%+v", rec)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("record must be written")
+	}
+}
+
+func TestJSONLAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < 2; i += 1 {
+		sink, err := OpenJSONLAuditFile(path)
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if err := sink.WriteAudit(AuditRecord{Tool: "tool", Args: map[string]any{"i": i}}); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r := AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if r.Tool != "tool" || r.Args["i"] != float64(lines) {
+			t.Errorf("actual=%+v", r)
+		}
+		lines += 1
+	}
+	if lines != 2 {
+		t.Errorf("file must be appended: lines=%d", lines)
+	}
+}
+
+func TestNATSAuditSink(t *testing.T) {
+	r, conn := testRegistry(t)
+
+	collector, err := Connect(NatsURL(r.ns.ClientURL()), Name("collector"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(collector.Close)
+	sub, err := collector.nc.SubscribeSync("audit.test")
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	collector.nc.Flush()
+
+	sink := NewNATSAuditSink(conn, "audit.test")
+	if err := sink.WriteAudit(AuditRecord{Side: AuditAgent, Tool: "tool"}); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	rec := AuditRecord{}
+	if err := json.Unmarshal(msg.Data, &rec); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if rec.Side != AuditAgent || rec.Tool != "tool" {
+		t.Errorf("actual=%+v", rec)
+	}
+}
+
+func TestJetStreamAuditSink(t *testing.T) {
+	r, err := CreateRegistry(WithBind("127.0.0.1", -1), WithJetStream(t.TempDir()))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(r.Close)
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name(t.Name()))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i += 1 {
+		// existing stream is reused
+		sink, err := NewJetStreamAuditSink(ctx, conn, "AUDIT", "audit.tools")
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if err := sink.WriteAudit(AuditRecord{Tool: "tool"}); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+	}
+
+	js, err := jetstream.New(conn.nc)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	stream, err := js.Stream(ctx, "AUDIT")
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if info.State.Msgs != 2 {
+		t.Errorf("msgs = %d", info.State.Msgs)
+	}
+	msg, err := stream.GetMsg(ctx, 1)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	rec := AuditRecord{}
+	if err := json.Unmarshal(msg.Data, &rec); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if rec.Tool != "tool" {
+		t.Errorf("actual=%+v", rec)
+	}
+}
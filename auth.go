@@ -0,0 +1,463 @@
+package polaris
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/pkg/errors"
+)
+
+const (
+	// IdentityHeader carries identity token of the caller in tool call message
+	IdentityHeader = "Polaris-Identity"
+	// IdentityProofHeader carries signature of the call by the private key of the identity,
+	// the token alone is public and is not accepted
+	IdentityProofHeader = "Polaris-Identity-Proof"
+
+	// IdentityProofWindow is how long the signed call is accepted, the same proof is accepted only once
+	IdentityProofWindow = 30 * time.Second
+
+	jwtRoleTagPrefix  = "role:"
+	jwtScopeTagPrefix = "scope:"
+)
+
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Identity is the caller of tools, Key is public key of the caller that signs each call
+type Identity struct {
+	Subject   string   `json:"sub"`
+	Roles     []string `json:"roles,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	Key       string   `json:"key"`
+	Issuer    string   `json:"iss,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+}
+
+// Allows checks Tool.Roles(one of them) and Tool.Scopes(all of them)
+func (id Identity) Allows(t Tool) error {
+	if 0 < len(t.Roles) {
+		ok := slices.ContainsFunc(t.Roles, func(role string) bool {
+			return containsFold(id.Roles, role)
+		})
+		if ok != true {
+			return errors.Wrapf(ErrPermissionDenied, "%s requires one of roles %v for %s", t.Name, t.Roles, id.Subject)
+		}
+	}
+	for _, scope := range t.Scopes {
+		if containsFold(id.Scopes, scope) != true {
+			return errors.Wrapf(ErrPermissionDenied, "%s requires scope %s for %s", t.Name, scope, id.Subject)
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, v string) bool {
+	return slices.ContainsFunc(list, func(s string) bool {
+		return strings.EqualFold(s, v)
+	})
+}
+
+func requiresPermission(t Tool) bool {
+	return 0 < len(t.Roles) || 0 < len(t.Scopes)
+}
+
+// Authorizer decides whether the caller of the message can call the tool and returns the verified identity,
+// returned error should wrap ErrPermissionDenied. Zero Identity means anonymous caller
+type Authorizer interface {
+	Authorize(t Tool, msg *nats.Msg) (Identity, error)
+}
+
+type AuthorizerFunc func(Tool, *nats.Msg) (Identity, error)
+
+func (f AuthorizerFunc) Authorize(t Tool, msg *nats.Msg) (Identity, error) {
+	return f(t, msg)
+}
+
+// IdentityVerifier returns verified identity from the token of IdentityHeader
+type IdentityVerifier func(token string) (Identity, error)
+
+// NewIdentityAuthorizer verifies identity by verifier, proof of the call by the key of the identity, and checks Identity.Allows.
+// Tools that don't require roles or scopes are allowed without identity
+func NewIdentityAuthorizer(verifier IdentityVerifier) Authorizer {
+	proofs := newProofCache()
+	return AuthorizerFunc(func(t Tool, msg *nats.Msg) (Identity, error) {
+		token := msg.Header.Get(IdentityHeader)
+		if token == "" {
+			if requiresPermission(t) {
+				return Identity{}, errors.Wrapf(ErrPermissionDenied, "%s requires identity", t.Name)
+			}
+			return Identity{}, nil
+		}
+		id, err := verifier(token)
+		if err != nil {
+			return Identity{}, errors.Wrapf(ErrPermissionDenied, "%s: invalid identity: %s", t.Name, err.Error())
+		}
+		if err := verifyIdentityProof(id, msg, proofs); err != nil {
+			return Identity{}, errors.Wrapf(ErrPermissionDenied, "%s: invalid proof of %s: %s", t.Name, id.Subject, err.Error())
+		}
+		if err := id.Allows(t); err != nil {
+			return Identity{}, errors.WithStack(err)
+		}
+		return id, nil
+	})
+}
+
+// NewTokenAuthorizer authorizes callers by token signed by SignIdentityToken with one of trusted issuer keys,
+// agents only need public keys of the issuers
+func NewTokenAuthorizer(trustedIssuers ...string) Authorizer {
+	return NewIdentityAuthorizer(func(token string) (Identity, error) {
+		return VerifyIdentityToken(token, trustedIssuers...)
+	})
+}
+
+// NATSTrustedAccount is the account that issues user JWT by its own key or by one of SigningKeys
+type NATSTrustedAccount struct {
+	Account     string
+	SigningKeys []string
+}
+
+// NATSTrustedAccountFromJWT returns the account and its signing keys from the account JWT,
+// the account JWT must come from the trusted resolver of the operator
+func NATSTrustedAccountFromJWT(accountJWT string) (NATSTrustedAccount, error) {
+	claims, err := jwt.DecodeAccountClaims(accountJWT)
+	if err != nil {
+		return NATSTrustedAccount{}, errors.WithStack(err)
+	}
+	return NATSTrustedAccount{claims.Subject, claims.SigningKeys.Keys()}, nil
+}
+
+// NewNATSJWTAuthorizer authorizes callers by NATS user JWT issued by one of trusted accounts,
+// roles and scopes are tags of the user as "role:<name>" and "scope:<name>".
+// The caller signs each call by the seed of the user
+func NewNATSJWTAuthorizer(trustedAccounts ...NATSTrustedAccount) Authorizer {
+	return NewIdentityAuthorizer(func(token string) (Identity, error) {
+		return verifyNATSUserJWT(token, trustedAccounts)
+	})
+}
+
+// SignIdentityToken creates token of the identity signed by issuer, the token expires after ttl.
+// Identity.Key is the public key of the caller, the caller presents the token with ConnectIdentity and its seed
+func SignIdentityToken(id Identity, issuer nkeys.KeyPair, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		return "", errors.Errorf("ttl is required")
+	}
+	if _, err := nkeys.FromPublicKey(id.Key); err != nil {
+		return "", errors.Wrapf(err, "key of %s", id.Subject)
+	}
+	pub, err := issuer.PublicKey()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	id.Issuer = pub
+	id.ExpiresAt = time.Now().Add(ttl).Unix()
+
+	data, err := json.Marshal(id)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	sig, err := issuer.Sign([]byte(payload))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyIdentityToken verifies the token is signed by one of trusted issuers and is not expired
+func VerifyIdentityToken(token string, trustedIssuers ...string) (Identity, error) {
+	id, payload, sig, err := decodeIdentityToken(token)
+	if err != nil {
+		return Identity{}, errors.WithStack(err)
+	}
+	if slices.Contains(trustedIssuers, id.Issuer) != true {
+		return Identity{}, errors.Errorf("untrusted issuer %s", id.Issuer)
+	}
+	issuer, err := nkeys.FromPublicKey(id.Issuer)
+	if err != nil {
+		return Identity{}, errors.WithStack(err)
+	}
+	if err := issuer.Verify([]byte(payload), sig); err != nil {
+		return Identity{}, errors.New("signature mismatch")
+	}
+	if id.ExpiresAt < 1 || time.Now().Unix() >= id.ExpiresAt {
+		return Identity{}, errors.New("token expired")
+	}
+	if id.Key == "" {
+		return Identity{}, errors.New("token has no key")
+	}
+	return id, nil
+}
+
+// decodeIdentityToken decodes the token without verification
+func decodeIdentityToken(token string) (Identity, string, []byte, error) {
+	payload, encodedSig, ok := strings.Cut(token, ".")
+	if ok != true {
+		return Identity{}, "", nil, errors.New("malformed token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return Identity{}, "", nil, errors.WithStack(err)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Identity{}, "", nil, errors.WithStack(err)
+	}
+	id := Identity{}
+	if err := json.Unmarshal(data, &id); err != nil {
+		return Identity{}, "", nil, errors.WithStack(err)
+	}
+	return id, payload, sig, nil
+}
+
+func verifyNATSUserJWT(token string, trustedAccounts []NATSTrustedAccount) (Identity, error) {
+	claims, err := jwt.DecodeUserClaims(token)
+	if err != nil {
+		return Identity{}, errors.WithStack(err)
+	}
+	vr := jwt.CreateValidationResults()
+	claims.Validate(vr)
+	if vr.IsBlocking(true) {
+		return Identity{}, errors.Errorf("invalid jwt: %v", vr.Errors())
+	}
+	// signature is verified against Issuer, IssuerAccount is only a claim of the token
+	if trustedNATSIssuer(trustedAccounts, claims.Issuer, claims.IssuerAccount) != true {
+		return Identity{}, errors.Errorf("untrusted issuer %s of account %s", claims.Issuer, claims.IssuerAccount)
+	}
+
+	id := Identity{
+		Subject:   cmp.Or(claims.Name, claims.Subject),
+		Key:       claims.Subject,
+		Issuer:    claims.Issuer,
+		ExpiresAt: claims.Expires,
+	}
+	for _, tag := range claims.Tags {
+		switch {
+		case strings.HasPrefix(tag, jwtRoleTagPrefix):
+			id.Roles = append(id.Roles, strings.TrimPrefix(tag, jwtRoleTagPrefix))
+		case strings.HasPrefix(tag, jwtScopeTagPrefix):
+			id.Scopes = append(id.Scopes, strings.TrimPrefix(tag, jwtScopeTagPrefix))
+		}
+	}
+	return id, nil
+}
+
+func trustedNATSIssuer(trustedAccounts []NATSTrustedAccount, issuer, issuerAccount string) bool {
+	for _, acc := range trustedAccounts {
+		if issuerAccount == "" {
+			if issuer == acc.Account {
+				return true
+			}
+			continue
+		}
+		if issuerAccount == acc.Account && slices.Contains(acc.SigningKeys, issuer) {
+			return true
+		}
+	}
+	return false
+}
+
+// proofCache remembers accepted proofs within IdentityProofWindow to deny replay
+type proofCache struct {
+	mutex   *sync.Mutex
+	seen    map[string]time.Time
+	sweptAt time.Time
+}
+
+func newProofCache() *proofCache {
+	return &proofCache{new(sync.Mutex), make(map[string]time.Time), time.Now()}
+}
+
+// add returns false when the proof is already accepted
+func (p *proofCache) add(proof string, now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if IdentityProofWindow < now.Sub(p.sweptAt) {
+		for k, expire := range p.seen {
+			if expire.Before(now) {
+				delete(p.seen, k)
+			}
+		}
+		p.sweptAt = now
+	}
+	if _, ok := p.seen[proof]; ok {
+		return false
+	}
+	// proof is accepted from -window to +window
+	p.seen[proof] = now.Add(2 * IdentityProofWindow)
+	return true
+}
+
+// identityProofPayload binds the proof to subject, data and identity token of the message
+func identityProofPayload(msg *nats.Msg, timestamp, nonce string) []byte {
+	digest := sha256.Sum256(msg.Data)
+	token := sha256.Sum256([]byte(msg.Header.Get(IdentityHeader)))
+	return []byte(strings.Join([]string{
+		msg.Subject,
+		hex.EncodeToString(digest[:]),
+		hex.EncodeToString(token[:]),
+		timestamp,
+		nonce,
+	}, "\n"))
+}
+
+// signIdentityProof sets IdentityProofHeader to the message as "<unix nano>.<nonce>.<signature>"
+func signIdentityProof(msg *nats.Msg, key nkeys.KeyPair) error {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return errors.WithStack(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+	nonce := hex.EncodeToString(buf)
+	sig, err := key.Sign(identityProofPayload(msg, timestamp, nonce))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	msg.Header.Set(IdentityProofHeader, timestamp+"."+nonce+"."+base64.RawURLEncoding.EncodeToString(sig))
+	return nil
+}
+
+func verifyIdentityProof(id Identity, msg *nats.Msg, proofs *proofCache) error {
+	parts := strings.Split(msg.Header.Get(IdentityProofHeader), ".")
+	if len(parts) != 3 {
+		return errors.New("no proof")
+	}
+	timestamp, nonce, encodedSig := parts[0], parts[1], parts[2]
+
+	unixNano, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	now := time.Now()
+	signedAt := time.Unix(0, unixNano)
+	if signedAt.Before(now.Add(-IdentityProofWindow)) || signedAt.After(now.Add(IdentityProofWindow)) {
+		return errors.New("proof is out of window")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	key, err := nkeys.FromPublicKey(id.Key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := key.Verify(identityProofPayload(msg, timestamp, nonce), sig); err != nil {
+		return errors.New("signature mismatch")
+	}
+	if proofs.add(id.Key+"."+nonce, now) != true {
+		return errors.New("replayed")
+	}
+	return nil
+}
+
+// ConnectAuthorizer enforces authorizer before calling Handler of tools registered by the Conn
+func ConnectAuthorizer(authorizer Authorizer) ConnectOptionFunc {
+	return func(o *ConnectOption) {
+		o.Authorizer = authorizer
+	}
+}
+
+// ConnectIdentity presents token as identity of the caller in every tool call and signs each call by seed,
+// token is made by SignIdentityToken or is the NATS user JWT, seed is the private key of Identity.Key
+func ConnectIdentity(token string, seed []byte) ConnectOptionFunc {
+	return func(o *ConnectOption) {
+		o.IdentityToken = token
+		o.IdentitySeed = seed
+	}
+}
+
+// ConnectIdentityCreds presents NATS user JWT and signs each call by the seed in the creds file
+func ConnectIdentityCreds(path string) ConnectOptionFunc {
+	return func(o *ConnectOption) {
+		o.IdentityCreds = path
+	}
+}
+
+// callerIdentity is the token presented in tool calls and the key that signs them, zero value calls anonymously
+type callerIdentity struct {
+	token string
+	key   nkeys.KeyPair
+}
+
+// callerIdentity returns identity of the Conn, zero value without ConnectIdentity or ConnectIdentityCreds
+func (o *ConnectOption) callerIdentity() (callerIdentity, error) {
+	key, err := o.identityKey()
+	if err != nil {
+		return callerIdentity{}, errors.WithStack(err)
+	}
+	if key == nil {
+		return callerIdentity{}, nil
+	}
+	return callerIdentity{o.IdentityToken, key}, nil
+}
+
+// identityKey returns the key that signs calls, nil without identity
+func (o *ConnectOption) identityKey() (nkeys.KeyPair, error) {
+	if o.IdentityCreds != "" {
+		data, err := os.ReadFile(o.IdentityCreds)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		token, err := jwt.ParseDecoratedJWT(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		key, err := nkeys.ParseDecoratedNKey(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		o.IdentityToken = token
+		return key, nil
+	}
+	if o.IdentityToken == "" {
+		return nil, nil
+	}
+	key, err := nkeys.FromSeed(o.IdentitySeed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "seed of identity")
+	}
+	return key, nil
+}
+
+// authorize denies the call before Handler, tools that require roles or scopes are denied without Authorizer
+func (c *Conn) authorize(t Tool, msg *nats.Msg) (Identity, map[string]any, bool) {
+	authorizer := c.opt.Authorizer
+	if authorizer == nil && requiresPermission(t) != true {
+		return Identity{}, nil, true
+	}
+	id, err := Identity{}, errors.Wrapf(ErrPermissionDenied, "%s: no authorizer", t.Name)
+	if authorizer != nil {
+		id, err = authorizer.Authorize(t, msg)
+	}
+	if err == nil {
+		return id, nil, true
+	}
+	if t.ErrorHandler != nil {
+		t.ErrorHandler(err)
+	}
+	return Identity{}, permissionDeniedResp(t, err), false
+}
+
+// permissionDeniedResp tells the model not to retry the call
+func permissionDeniedResp(t Tool, err error) map[string]any {
+	return map[string]any{
+		"_error":             fmt.Sprintf("permission denied to call %s, do not retry: %s", t.Name, err.Error()),
+		"_permission_denied": true,
+	}
+}
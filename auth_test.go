@@ -0,0 +1,440 @@
+package polaris
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/pkg/errors"
+)
+
+func testNKey(t *testing.T, create func() (nkeys.KeyPair, error)) (nkeys.KeyPair, string) {
+	t.Helper()
+
+	kp, err := create()
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	pub, err := kp.PublicKey()
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	return kp, pub
+}
+
+// testIdentity issues token of id for a new user key, returns the token and seed of the user
+func testIdentity(t *testing.T, issuer nkeys.KeyPair, id Identity) (string, []byte) {
+	t.Helper()
+
+	user, pub := testNKey(t, nkeys.CreateUser)
+	seed, err := user.Seed()
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	id.Key = pub
+	token, err := SignIdentityToken(id, issuer, time.Minute)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	return token, seed
+}
+
+func TestIdentityToken(t *testing.T) {
+	issuer, issuerPub := testNKey(t, nkeys.CreateAccount)
+	other, otherPub := testNKey(t, nkeys.CreateAccount)
+	_, userPub := testNKey(t, nkeys.CreateUser)
+	id := Identity{Subject: "alice", Roles: []string{"admin"}, Scopes: []string{"repo:read"}, Key: userPub}
+
+	t.Run("verify", func(t *testing.T) {
+		token, err := SignIdentityToken(id, issuer, time.Minute)
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		got, err := VerifyIdentityToken(token, otherPub, issuerPub)
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if got.Subject != "alice" || got.Roles[0] != "admin" || got.Scopes[0] != "repo:read" || got.Key != userPub || got.Issuer != issuerPub {
+			t.Errorf("actual=%+v", got)
+		}
+	})
+	t.Run("sign", func(t *testing.T) {
+		if _, err := SignIdentityToken(id, issuer, 0); err == nil {
+			t.Errorf("ttl is required")
+		}
+		if _, err := SignIdentityToken(Identity{Subject: "alice"}, issuer, time.Minute); err == nil {
+			t.Errorf("key is required")
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		token, err := SignIdentityToken(id, issuer, time.Minute)
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		untrusted, err := SignIdentityToken(id, other, time.Minute)
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		payload, sig, _ := strings.Cut(token, ".")
+		encode := func(id Identity) string {
+			data, err := json.Marshal(id)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			return base64.RawURLEncoding.EncodeToString(data)
+		}
+		signed := func(payload string) string {
+			s, err := issuer.Sign([]byte(payload))
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			return payload + "." + base64.RawURLEncoding.EncodeToString(s)
+		}
+		escalated := id
+		escalated.Issuer = issuerPub
+		escalated.Roles = []string{"root"}
+		escalated.ExpiresAt = time.Now().Add(time.Minute).Unix()
+		expired := escalated
+		expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+		noKey := escalated
+		noKey.Key = ""
+
+		tests := []struct {
+			name  string
+			token string
+		}{
+			{"untrusted issuer", untrusted},
+			{"tampered", encode(escalated) + "." + sig},
+			{"other signature", payload + untrusted[strings.Index(untrusted, "."):]},
+			{"malformed", "token"},
+			{"expired", signed(encode(expired))},
+			{"no key", signed(encode(noKey))},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := VerifyIdentityToken(tt.token, issuerPub); err == nil {
+					t.Errorf("must be error")
+				}
+			})
+		}
+	})
+}
+
+func TestIdentityAllows(t *testing.T) {
+	id := Identity{Subject: "alice", Roles: []string{"dev"}, Scopes: []string{"repo:read", "repo:write"}}
+	tests := []struct {
+		name   string
+		tool   Tool
+		expect bool
+	}{
+		{"no requirement", Tool{Name: "a"}, true},
+		{"one of roles", Tool{Name: "a", Roles: []string{"admin", "dev"}}, true},
+		{"role case insensitive", Tool{Name: "a", Roles: []string{"DEV"}}, true},
+		{"missing role", Tool{Name: "a", Roles: []string{"admin"}}, false},
+		{"all scopes", Tool{Name: "a", Scopes: []string{"repo:read", "repo:write"}}, true},
+		{"missing scope", Tool{Name: "a", Scopes: []string{"repo:read", "repo:delete"}}, false},
+		{"role and missing scope", Tool{Name: "a", Roles: []string{"dev"}, Scopes: []string{"repo:delete"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := id.Allows(tt.tool)
+			if tt.expect && err != nil {
+				t.Errorf("no error: %+v", err)
+			}
+			if tt.expect != true && errors.Is(err, ErrPermissionDenied) != true {
+				t.Errorf("must be ErrPermissionDenied: %+v", err)
+			}
+		})
+	}
+}
+
+func testUserJWT(t *testing.T, account nkeys.KeyPair, name string, tags ...string) ConnectOptionFunc {
+	t.Helper()
+	return testUserJWTOf(t, account, "", name, tags...)
+}
+
+// testUserJWTOf issues user JWT by signer on behalf of issuerAccount, returns identity of the user
+func testUserJWTOf(t *testing.T, signer nkeys.KeyPair, issuerAccount string, name string, tags ...string) ConnectOptionFunc {
+	t.Helper()
+
+	user, pub := testNKey(t, nkeys.CreateUser)
+	seed, err := user.Seed()
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	claims := jwt.NewUserClaims(pub)
+	claims.Name = name
+	claims.IssuerAccount = issuerAccount
+	claims.Tags.Add(tags...)
+	token, err := claims.Encode(signer)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	return ConnectIdentity(token, seed)
+}
+
+func TestToolAuthorization(t *testing.T) {
+	issuer, issuerPub := testNKey(t, nkeys.CreateAccount)
+	adminTool := testOwnerTool("admin_op", "owner")
+	adminTool.Roles = []string{"admin"}
+	writeTool := testOwnerTool("write_op", "owner")
+	writeTool.Scopes = []string{"repo:write"}
+	publicTool := testOwnerTool("public_op", "owner")
+
+	call := func(t *testing.T, conn *Conn, name string) Resp {
+		resp, err := conn.Call(context.Background(), name, Req{})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		return resp
+	}
+	assertAllowed := func(t *testing.T, resp Resp) {
+		if resp["owner"] != "owner" {
+			t.Errorf("must be allowed: %v", resp)
+		}
+	}
+	assertDenied := func(t *testing.T, resp Resp) {
+		if resp["_permission_denied"] != true {
+			t.Errorf("must be denied: %v", resp)
+		}
+		if msg, _ := resp["_error"].(string); strings.Contains(msg, "permission denied") != true {
+			t.Errorf("_error must tell permission denied: %v", resp)
+		}
+	}
+
+	t.Run("token", func(t *testing.T) {
+		r, _ := testRegistry(t)
+
+		denied := make(chan error, 10)
+		deniedTool := adminTool
+		deniedTool.ErrorHandler = func(err error) {
+			denied <- err
+		}
+		owner, err := Connect(NatsURL(r.ns.ClientURL()), Name("owner"), ConnectAuthorizer(NewTokenAuthorizer(issuerPub)))
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		t.Cleanup(owner.Close)
+		for _, tool := range []Tool{deniedTool, writeTool, publicTool} {
+			if err := owner.RegisterTool(tool); err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+		}
+
+		connect := func(t *testing.T, id *Identity) *Conn {
+			options := []ConnectOptionFunc{NatsURL(r.ns.ClientURL()), Name(t.Name())}
+			if id != nil {
+				options = append(options, ConnectIdentity(testIdentity(t, issuer, *id)))
+			}
+			conn, err := Connect(options...)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			t.Cleanup(conn.Close)
+			return conn
+		}
+		admin := connect(t, &Identity{Subject: "alice", Roles: []string{"admin"}, Scopes: []string{"repo:write"}})
+		dev := connect(t, &Identity{Subject: "bob", Roles: []string{"dev"}})
+		anonymous := connect(t, nil)
+
+		assertAllowed(t, call(t, admin, "admin_op"))
+		assertAllowed(t, call(t, admin, "write_op"))
+		assertAllowed(t, call(t, admin, "public_op"))
+		assertDenied(t, call(t, dev, "admin_op"))
+		assertDenied(t, call(t, dev, "write_op"))
+		assertAllowed(t, call(t, dev, "public_op"))
+		assertDenied(t, call(t, anonymous, "admin_op"))
+		assertAllowed(t, call(t, anonymous, "public_op"))
+
+		select {
+		case err := <-denied:
+			if errors.Is(err, ErrPermissionDenied) != true {
+				t.Errorf("must be ErrPermissionDenied: %+v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("ErrorHandler must be called on denied")
+		}
+
+		request := func(t *testing.T, msg *nats.Msg) Resp {
+			reply, err := anonymous.nc.RequestMsg(msg, time.Second)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			resp := Resp{}
+			if err := json.Unmarshal(reply.Data, &resp); err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			return resp
+		}
+		t.Run("replay", func(t *testing.T) {
+			msg, err := admin.callMsg("write_op", map[string]any{}, "", "", admin.identity)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			assertAllowed(t, request(t, msg))
+			assertDenied(t, request(t, msg))
+		})
+		t.Run("proof of other call", func(t *testing.T) {
+			msg, err := admin.callMsg("public_op", map[string]any{}, "", "", admin.identity)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			msg.Subject = tooltopic("write_op")
+			assertDenied(t, request(t, msg))
+
+			msg, err = admin.callMsg("write_op", map[string]any{"a": 1}, "", "", admin.identity)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			msg.Data = []byte(`{"a":2}`)
+			assertDenied(t, request(t, msg))
+		})
+		t.Run("token without proof", func(t *testing.T) {
+			msg, err := admin.callMsg("write_op", map[string]any{}, "", "", admin.identity)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			msg.Header.Del(IdentityProofHeader)
+			assertDenied(t, request(t, msg))
+		})
+		t.Run("token with other key", func(t *testing.T) {
+			token, _ := testIdentity(t, issuer, Identity{Subject: "alice", Roles: []string{"admin"}, Scopes: []string{"repo:write"}})
+			_, seed := testIdentity(t, issuer, Identity{Subject: "bob"})
+			conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("stolen"), ConnectIdentity(token, seed))
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			t.Cleanup(conn.Close)
+			assertDenied(t, call(t, conn, "write_op"))
+		})
+	})
+	t.Run("nats jwt", func(t *testing.T) {
+		r, _ := testRegistry(t)
+
+		account, err := nkeys.CreateAccount()
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		accountPub, err := account.PublicKey()
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		untrusted, err := nkeys.CreateAccount()
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		signingKey, err := nkeys.CreateAccount()
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		signingKeyPub, err := signingKey.PublicKey()
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		operator, err := nkeys.CreateOperator()
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		accountClaims := jwt.NewAccountClaims(accountPub)
+		accountClaims.SigningKeys.Add(signingKeyPub)
+		accountJWT, err := accountClaims.Encode(operator)
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		trusted, err := NATSTrustedAccountFromJWT(accountJWT)
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+
+		owner, err := Connect(NatsURL(r.ns.ClientURL()), Name("owner"), ConnectAuthorizer(NewNATSJWTAuthorizer(trusted)))
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		t.Cleanup(owner.Close)
+		if err := owner.RegisterTool(adminTool); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+
+		tests := []struct {
+			name     string
+			identity ConnectOptionFunc
+			allowed  bool
+		}{
+			{"admin", testUserJWT(t, account, "alice", "role:admin"), true},
+			{"dev", testUserJWT(t, account, "bob", "role:dev"), false},
+			{"untrusted issuer", testUserJWT(t, untrusted, "mallory", "role:admin"), false},
+			{"signing key", testUserJWTOf(t, signingKey, accountPub, "carol", "role:admin"), true},
+			{"forged signing key", testUserJWTOf(t, untrusted, accountPub, "mallory", "role:admin"), false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				conn, err := Connect(NatsURL(r.ns.ClientURL()), Name(tt.name), tt.identity)
+				if err != nil {
+					t.Fatalf("no error: %+v", err)
+				}
+				t.Cleanup(conn.Close)
+
+				resp := call(t, conn, "admin_op")
+				if tt.allowed {
+					assertAllowed(t, resp)
+				} else {
+					assertDenied(t, resp)
+				}
+			})
+		}
+		t.Run("creds", func(t *testing.T) {
+			user, pub := testNKey(t, nkeys.CreateUser)
+			seed, err := user.Seed()
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			claims := jwt.NewUserClaims(pub)
+			claims.Tags.Add("role:admin")
+			token, err := claims.Encode(account)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			creds, err := jwt.FormatUserConfig(token, seed)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			path := filepath.Join(t.TempDir(), "user.creds")
+			if err := os.WriteFile(path, creds, 0600); err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("creds"), ConnectIdentityCreds(path))
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			t.Cleanup(conn.Close)
+			assertAllowed(t, call(t, conn, "admin_op"))
+		})
+	})
+	t.Run("no authorizer", func(t *testing.T) {
+		r, caller := testRegistry(t)
+
+		owner, err := Connect(NatsURL(r.ns.ClientURL()), Name("owner"))
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		t.Cleanup(owner.Close)
+		if err := owner.RegisterTool(adminTool); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if err := owner.RegisterTool(publicTool); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		// fail closed
+		assertDenied(t, call(t, caller, "admin_op"))
+		assertDenied(t, call(t, owner, "admin_op"))
+		assertAllowed(t, call(t, caller, "public_op"))
+	})
+}
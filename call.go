@@ -4,20 +4,29 @@ import (
 	"context"
 	"io"
 	"log"
+	"time"
 
-	"github.com/mark3labs/mcp-go/client"
-	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/pkg/errors"
 )
 
-func handleToolCall(t Tool) func(map[string]any) map[string]any {
+func handleToolCall(ctx context.Context, t Tool) func(map[string]any) map[string]any {
 	return func(req map[string]any) map[string]any {
+		if t.SkipArgsValidation != true {
+			if err := Validate(t.Parameters, req); err != nil {
+				if t.ErrorHandler != nil {
+					t.ErrorHandler(err)
+				}
+				return validationErrorResp(err)
+			}
+		}
+
 		j := make(jsonMap, len(req))
 		for k, v := range req {
 			j.Set(k, v)
 		}
+		applyDefaults(t.Parameters.Schema(), j)
 
-		resp, err := t.Handler(&ReqCtx{j, t.Parameters})
+		resp, err := t.Handler(&ReqCtx{ctx, j, t.Parameters})
 		if err != nil {
 			if t.ErrorHandler != nil {
 				t.ErrorHandler(err)
@@ -26,16 +35,72 @@ func handleToolCall(t Tool) func(map[string]any) map[string]any {
 				"_error": err.Error(),
 			}
 		}
-		return resp.ToMap()
+
+		ret := resp.ToMap()
+		if t.ResponseValidation == ValidationWarn || t.ResponseValidation == ValidationStrict {
+			if err := validateResponse(t.Response, ret); err != nil {
+				err = errors.Wrapf(err, "response of %s", t.Name)
+				if t.ErrorHandler != nil {
+					t.ErrorHandler(err)
+				}
+				if t.ResponseValidation == ValidationStrict {
+					return validationErrorResp(err)
+				}
+				log.Printf("WARN: %s", err.Error())
+			}
+		}
+		return ret
+	}
+}
+
+// validateResponse validates resp as JSON the caller receives,
+// e.g. nested map[string]string, struct and time.Time are replied as object and string
+func validateResponse(t TypeDef, resp map[string]any) error {
+	encoded, err := encodeFrom(resp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return Validate(t, encoded.ToMap())
+}
+
+// validationErrorResp lets the model self-correct the arguments
+func validationErrorResp(err error) map[string]any {
+	resp := map[string]any{
+		"_error": err.Error(),
+	}
+	verr := (*ValidationError)(nil)
+	if errors.As(err, &verr) {
+		violations := make([]any, len(verr.Violations))
+		for i, v := range verr.Violations {
+			violations[i] = map[string]any{
+				"path": v.Path,
+				"msg":  v.Msg,
+			}
+		}
+		resp["_violations"] = violations
 	}
+	return resp
 }
 
-func handleMCPToolCall(ctx context.Context, client *client.Client, t Tool) func(map[string]any) map[string]any {
+func handleMCPToolCall(session *mcpSession, t Tool) func(map[string]any) map[string]any {
+	hasOutputSchema := 0 < len(t.Response.Properties)
 	return func(req map[string]any) map[string]any {
-		r := mcp.CallToolRequest{}
-		r.Params.Name = t.Name
-		r.Params.Arguments = req
-		res, err := client.CallTool(ctx, r)
+		client, ctx := session.current()
+		if client == nil {
+			return map[string]any{
+				"_error": "mcp server is not available",
+			}
+		}
+		res, err := callMCPTool(ctx, client, t.Name, req)
+		if errors.Is(err, errMCPSessionExpired) {
+			// retry once with new session
+			if client, ctx, err = session.reconnectClient(client); err == nil {
+				res, err = callMCPTool(ctx, client, t.Name, req)
+			}
+		}
+		if err == nil && res.IsError {
+			err = errors.Errorf("tool %s: %s", t.Name, res.errorMessage())
+		}
 		if err != nil {
 			if t.ErrorHandler != nil {
 				t.ErrorHandler(err)
@@ -44,21 +109,14 @@ func handleMCPToolCall(ctx context.Context, client *client.Client, t Tool) func(
 				"_error": err.Error(),
 			}
 		}
-
-		texts := make([]string, len(res.Content))
-		for i, c := range res.Content {
-			if tc, ok := c.(mcp.TextContent); ok {
-				texts[i] = tc.Text
-			}
-		}
-		resp := Resp{"results": texts}
-		return resp.ToMap()
+		return res.resp(hasOutputSchema)
 	}
 }
 
 type remoteCall interface {
 	setLogger(Logger)
 	setDefaultArgsFunc(func() map[string]any)
+	setSession(id string, model string)
 	callFunction(string, map[string]any) (map[string]any, error)
 }
 
@@ -73,6 +131,8 @@ func (*panicRemoteCall) setLogger(Logger) {}
 
 func (*panicRemoteCall) setDefaultArgsFunc(func() map[string]any) {}
 
+func (*panicRemoteCall) setSession(string, string) {}
+
 func (*panicRemoteCall) callFunction(name string, args map[string]any) (map[string]any, error) {
 	panic(errors.Errorf("not support callFunction: called func=%s args=%v", name, args))
 }
@@ -81,6 +141,9 @@ type defaultRemoteCall struct {
 	conn            *Conn
 	logger          Logger
 	defaultArgsFunc func() map[string]any
+	sessionID       string
+	model           string
+	identity        callerIdentity
 }
 
 func (d *defaultRemoteCall) setLogger(lg Logger) {
@@ -91,6 +154,11 @@ func (d *defaultRemoteCall) setDefaultArgsFunc(fn func() map[string]any) {
 	d.defaultArgsFunc = fn
 }
 
+func (d *defaultRemoteCall) setSession(id string, model string) {
+	d.sessionID = id
+	d.model = model
+}
+
 func (d *defaultRemoteCall) callFunction(name string, args map[string]any) (map[string]any, error) {
 	if d.logger == nil {
 		d.logger = &stdLogger{log.New(io.Discard, "", 0), false}
@@ -105,13 +173,13 @@ func (d *defaultRemoteCall) callFunction(name string, args map[string]any) (map[
 	}
 
 	d.logger.Debugf("callFunction: %s args=%v", name, args)
-	resp, err := requestWithData(
-		d.conn,
-		tooltopic(name),
-		JSONEncoder[map[string]any](),
-		JSONEncoder[map[string]any](),
-		args,
-	)
+	msg, err := d.conn.callMsg(name, args, d.sessionID, d.model, d.identity)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	start := time.Now()
+	resp, err := requestMsg(d.conn, msg, JSONEncoder[map[string]any]())
+	d.conn.audit(AuditCaller, msg.Header, presentedSubject(d.identity.token), name, args, resp, err, time.Since(start))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
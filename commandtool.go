@@ -0,0 +1,372 @@
+package polaris
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type CommandOutput string
+
+const (
+	// CommandOutputText replies stdout as {"output": "..."}
+	CommandOutputText CommandOutput = "text"
+	// CommandOutputJSON replies stdout decoded as JSON, non-object value is {"result": ...}
+	CommandOutputJSON CommandOutput = "json"
+	// CommandOutputLines replies non-empty lines of stdout as {"lines": [...]}
+	CommandOutputLines CommandOutput = "lines"
+)
+
+const (
+	defaultCommandTimeout = 30 * time.Second
+	// commandWaitDelay bounds waiting for stdout/stderr after the command is killed,
+	// a child process that inherits the pipes would block the call otherwise
+	commandWaitDelay = 5 * time.Second
+)
+
+// CommandToolConfig describes a tool that runs a command and replies its stdout.
+//
+// Command is argv, not a shell command line. Each element may contain placeholders
+// of parameters as "{{name}}" and is passed to the process as a single argument,
+// so values can't inject another command. An element that starts with a placeholder,
+// e.g. "{{name}}" or "{{name}}.txt", rejects values starting with "-" to prevent option injection,
+// use "--name={{name}}" to pass such values. An element whose parameter is not supplied is omitted,
+// an array parameter of exactly "{{name}}" element is expanded to multiple arguments.
+//
+// The command does not inherit environment of the process except commandBaseEnv(e.g. PATH),
+// Env is added to it.
+//
+//	name: git_log
+//	description: show recent commits
+//	inputSchema:
+//	  type: object
+//	  properties:
+//	    limit: {type: integer, default: 10}
+//	command: ["git", "log", "--oneline", "--max-count={{limit}}"]
+//	workDir: /srv/repo
+//	timeout: 10s
+//	output: lines
+type CommandToolConfig struct {
+	ToolSchema
+
+	Command []string          `json:"command"`
+	WorkDir string            `json:"workDir,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Timeout string            `json:"timeout,omitempty"`
+	Output  CommandOutput     `json:"output,omitempty"`
+}
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_\-]+)\s*\}\}`)
+
+// LoadCommandTools loads CommandToolConfig from .json/.yaml/.yml file,
+// the file has a config, a list of config or {"tools": [...]}.
+// allowlist is the executable names or paths that are allowed to run
+func LoadCommandTools(path string, allowlist []string) ([]Tool, error) {
+	format, err := schemaFormatOf(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tools, err := ParseCommandTools(data, format, allowlist)
+	if err != nil {
+		return nil, errors.Wrapf(err, "file %s", path)
+	}
+	return tools, nil
+}
+
+func ParseCommandTools(data []byte, format SchemaFormat, allowlist []string) ([]Tool, error) {
+	configs, err := parseSchemaList[CommandToolConfig](data, format)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	tools := make([]Tool, len(configs))
+	for i, cfg := range configs {
+		t, err := NewCommandTool(cfg, allowlist)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		tools[i] = t
+	}
+	return tools, nil
+}
+
+// NewCommandTool returns Tool that runs cfg.Command, cfg.Command[0] must be in allowlist
+func NewCommandTool(cfg CommandToolConfig, allowlist []string) (Tool, error) {
+	if cfg.InputSchema == nil {
+		cfg.InputSchema = map[string]any{"type": "object"}
+	}
+	t, err := cfg.ToolSchema.Tool()
+	if err != nil {
+		return Tool{}, errors.WithStack(err)
+	}
+	if len(cfg.Command) < 1 {
+		return Tool{}, errors.Errorf("tool %s: command required", cfg.Name)
+	}
+	if placeholderPattern.MatchString(cfg.Command[0]) {
+		return Tool{}, errors.Errorf("tool %s: command must not contain placeholder: %s", cfg.Name, cfg.Command[0])
+	}
+	executable, err := allowedCommand(cfg.Command[0], allowlist)
+	if err != nil {
+		return Tool{}, errors.Wrapf(err, "tool %s", cfg.Name)
+	}
+	for _, arg := range cfg.Command[1:] {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(arg, -1) {
+			if _, ok := t.Parameters.Properties[m[1]]; ok != true {
+				return Tool{}, errors.Errorf("tool %s: undefined parameter %q in %q", cfg.Name, m[1], arg)
+			}
+		}
+	}
+
+	timeout := defaultCommandTimeout
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return Tool{}, errors.Wrapf(err, "tool %s: timeout", cfg.Name)
+		}
+		timeout = d
+	}
+
+	switch cfg.Output {
+	case "", CommandOutputText:
+		cfg.Output = CommandOutputText
+		if cfg.OutputSchema == nil {
+			t.Response = Object{
+				Properties: Properties{
+					"output": String{Description: "stdout of the command", Required: true},
+				},
+			}
+		}
+	case CommandOutputLines:
+		if cfg.OutputSchema == nil {
+			t.Response = Object{
+				Properties: Properties{
+					"lines": StringArray{Description: "lines of stdout", Required: true},
+				},
+			}
+		}
+	case CommandOutputJSON:
+	default:
+		return Tool{}, errors.Errorf("tool %s: unsupported output %q", cfg.Name, cfg.Output)
+	}
+
+	env := make([]string, 0, len(cfg.Env))
+	for k, v := range cfg.Env {
+		env = append(env, k+"="+v)
+	}
+	slices.Sort(env)
+
+	cmd := &commandTool{
+		name:       cfg.Name,
+		executable: executable,
+		args:       cfg.Command[1:],
+		workDir:    cfg.WorkDir,
+		env:        env,
+		timeout:    timeout,
+		output:     cfg.Output,
+	}
+	t.Handler = cmd.handle
+	return t, nil
+}
+
+// allowedCommand returns resolved path of command when it matches allowlist by name or path
+func allowedCommand(command string, allowlist []string) (string, error) {
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	for _, allowed := range allowlist {
+		if allowed == command {
+			return path, nil
+		}
+		if allowedPath, err := exec.LookPath(allowed); err == nil && allowedPath == path {
+			return path, nil
+		}
+	}
+	return "", errors.Errorf("command not allowed: %s", command)
+}
+
+type commandTool struct {
+	name       string
+	executable string
+	args       []string
+	workDir    string
+	env        []string
+	timeout    time.Duration
+	output     CommandOutput
+}
+
+func (c *commandTool) handle(r *ReqCtx) (Resp, error) {
+	args, err := expandCommandArgs(c.args, r.Req())
+	if err != nil {
+		return nil, errors.Wrapf(err, "tool %s", c.name)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), c.timeout)
+	defer cancel()
+
+	stdout := bytes.NewBuffer(nil)
+	stderr := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, c.executable, args...)
+	cmd.Dir = c.workDir
+	cmd.Env = append(commandEnv(), c.env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = commandWaitDelay
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.Errorf("tool %s: timeout after %s", c.name, c.timeout)
+		}
+		return nil, errors.Wrapf(err, "tool %s: %s", c.name, strings.TrimSpace(stderr.String()))
+	}
+
+	switch c.output {
+	case CommandOutputJSON:
+		var v any
+		if err := json.Unmarshal(stdout.Bytes(), &v); err != nil {
+			return nil, errors.Wrapf(err, "tool %s: stdout is not JSON", c.name)
+		}
+		if m, ok := v.(map[string]any); ok {
+			return Resp(m), nil
+		}
+		return Resp{"result": v}, nil
+	case CommandOutputLines:
+		lines := make([]string, 0)
+		for _, line := range strings.Split(stdout.String(), "\n") {
+			if line = strings.TrimRight(line, "\r"); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return Resp{"lines": lines}, nil
+	}
+	return Resp{"output": stdout.String()}, nil
+}
+
+// commandBaseEnv are environment variables the command inherits,
+// others such as API keys and credentials are not passed to the command
+var commandBaseEnv = []string{"PATH", "HOME", "LANG", "TZ"}
+
+func commandEnv() []string {
+	env := make([]string, 0, len(commandBaseEnv))
+	for _, key := range commandBaseEnv {
+		if v, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+v)
+		}
+	}
+	return env
+}
+
+// expandCommandArgs replaces placeholders with arguments, each element stays a single argument
+func expandCommandArgs(templates []string, req Req) ([]string, error) {
+	args := make([]string, 0, len(templates))
+	for _, tmpl := range templates {
+		matches := placeholderPattern.FindAllStringSubmatchIndex(tmpl, -1)
+		if len(matches) < 1 {
+			args = append(args, tmpl)
+			continue
+		}
+
+		// exactly "{{name}}": array is expanded, leading "-" is rejected
+		if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(tmpl) {
+			key := tmpl[matches[0][2]:matches[0][3]]
+			values, err := commandArgValues(key, req[key])
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			for _, v := range values {
+				if strings.HasPrefix(v, "-") {
+					return nil, errors.Errorf("argument %s must not start with '-': %q", key, v)
+				}
+			}
+			args = append(args, values...)
+			continue
+		}
+
+		omit := false
+		var expandErr error
+		arg := placeholderPattern.ReplaceAllStringFunc(tmpl, func(s string) string {
+			key := placeholderPattern.FindStringSubmatch(s)[1]
+			value, ok := req[key]
+			if ok != true || value == nil {
+				omit = true
+				return ""
+			}
+			v, err := commandArgString(key, value)
+			if err != nil {
+				expandErr = err
+			}
+			return v
+		})
+		if expandErr != nil {
+			return nil, errors.WithStack(expandErr)
+		}
+		if omit {
+			continue
+		}
+		// value at the head of the element becomes an option, e.g. "{{path}}.txt" as "-rf.txt"
+		if matches[0][0] == 0 && strings.HasPrefix(arg, "-") {
+			return nil, errors.Errorf("argument %s must not start with '-': %q", tmpl, arg)
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func commandArgValues(key string, value any) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if list, ok := value.([]any); ok {
+		values := make([]string, len(list))
+		for i, v := range list {
+			s, err := commandArgString(key, v)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			values[i] = s
+		}
+		return values, nil
+	}
+	s, err := commandArgString(key, value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return []string{s}, nil
+}
+
+func commandArgString(key string, value any) (string, error) {
+	s := ""
+	switch v := value.(type) {
+	case string:
+		s = v
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		n, ok := toFloat64(value)
+		if ok != true {
+			return "", errors.Errorf("argument %s must be scalar: %s", key, jsonTypeName(value))
+		}
+		if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+			s = strconv.FormatInt(int64(n), 10)
+		} else {
+			s = strconv.FormatFloat(n, 'f', -1, 64)
+		}
+	}
+	if strings.ContainsRune(s, 0) {
+		return "", errors.Errorf("argument %s must not contain NUL", key)
+	}
+	return s, nil
+}
@@ -0,0 +1,212 @@
+package polaris
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandCommandArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    []string
+		req     Req
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "placeholder",
+			tmpl: []string{"log", "{{ref}}", "--max-count={{limit}}"},
+			req:  Req{"ref": "main", "limit": float64(10)},
+			want: []string{"log", "main", "--max-count=10"},
+		},
+		{
+			name: "injection stays single argument",
+			tmpl: []string{"{{path}}"},
+			req:  Req{"path": "a; rm -rf / && $(reboot)"},
+			want: []string{"a; rm -rf / && $(reboot)"},
+		},
+		{
+			name: "omit missing",
+			tmpl: []string{"log", "{{ref}}", "--max-count={{limit}}"},
+			req:  Req{},
+			want: []string{"log"},
+		},
+		{
+			name: "array expansion",
+			tmpl: []string{"add", "--", "{{files}}"},
+			req:  Req{"files": []any{"a.go", "b.go"}},
+			want: []string{"add", "--", "a.go", "b.go"},
+		},
+		{
+			name: "option allowed in embedded",
+			tmpl: []string{"--pattern={{pattern}}"},
+			req:  Req{"pattern": "-v"},
+			want: []string{"--pattern=-v"},
+		},
+		{
+			name: "bool and float",
+			tmpl: []string{"{{flag}}", "{{ratio}}"},
+			req:  Req{"flag": true, "ratio": 0.5},
+			want: []string{"true", "0.5"},
+		},
+		{
+			name:    "option injection",
+			tmpl:    []string{"log", "{{ref}}"},
+			req:     Req{"ref": "--output=/etc/passwd"},
+			wantErr: true,
+		},
+		{
+			name:    "option injection in array",
+			tmpl:    []string{"{{files}}"},
+			req:     Req{"files": []any{"a", "-rf"}},
+			wantErr: true,
+		},
+		{
+			name:    "option injection with suffix",
+			tmpl:    []string{"rm", "{{path}}.txt"},
+			req:     Req{"path": "-rf"},
+			wantErr: true,
+		},
+		{
+			name:    "option injection in concatenated",
+			tmpl:    []string{"{{a}}{{b}}"},
+			req:     Req{"a": "", "b": "--exec=reboot"},
+			wantErr: true,
+		},
+		{
+			name: "suffix",
+			tmpl: []string{"{{path}}.txt"},
+			req:  Req{"path": "notes"},
+			want: []string{"notes.txt"},
+		},
+		{
+			name:    "object",
+			tmpl:    []string{"{{obj}}"},
+			req:     Req{"obj": map[string]any{"a": 1}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandCommandArgs(tt.tmpl, tt.req)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("must be error: %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			if slices.Equal(got, tt.want) != true {
+				t.Errorf("args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCommandToolError(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  CommandToolConfig
+	}{
+		{"not allowed", CommandToolConfig{ToolSchema: ToolSchema{Name: "rm"}, Command: []string{"rm", "-rf"}}},
+		{"placeholder command", CommandToolConfig{ToolSchema: ToolSchema{Name: "x"}, Command: []string{"{{cmd}}"}}},
+		{"undefined parameter", CommandToolConfig{ToolSchema: ToolSchema{Name: "x"}, Command: []string{"echo", "{{msg}}"}}},
+		{"no command", CommandToolConfig{ToolSchema: ToolSchema{Name: "x"}}},
+		{"output", CommandToolConfig{ToolSchema: ToolSchema{Name: "x"}, Command: []string{"echo"}, Output: "xml"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCommandTool(tt.cfg, []string{"echo"}); err == nil {
+				t.Errorf("must be error")
+			}
+		})
+	}
+}
+
+func TestCommandTool(t *testing.T) {
+	t.Setenv("POLARIS_TEST_SECRET", "secret")
+
+	tools, err := ParseCommandTools([]byte(`
+tools:
+  - name: echo_text
+    inputSchema:
+      type: object
+      properties:
+        msg: {type: string}
+      required: [msg]
+    command: ["echo", "{{msg}}"]
+  - name: echo_lines
+    inputSchema:
+      type: object
+      properties:
+        a: {type: string}
+        b: {type: string}
+    command: ["printf", "%s\n%s\n", "{{a}}", "{{b}}"]
+    output: lines
+  - name: echo_json
+    command: ["echo", '{"ok": true}']
+    output: json
+  - name: env
+    command: ["printenv", "POLARIS_TEST"]
+    env:
+      POLARIS_TEST: hello
+  - name: secret
+    command: ["printenv", "POLARIS_TEST_SECRET"]
+  - name: slow
+    command: ["sleep", "5"]
+    timeout: 50ms
+  - name: orphan
+    command: ["sh", "-c", "sleep 30 & wait"]
+    timeout: 50ms
+`), SchemaFormatYAML, []string{"echo", "printf", "printenv", "sleep", "sh"})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	call := func(name string, req map[string]any) map[string]any {
+		for _, tool := range tools {
+			if tool.Name == name {
+				return handleToolCall(context.Background(), tool)(req)
+			}
+		}
+		t.Fatalf("tool %s not found", name)
+		return nil
+	}
+
+	if resp := call("echo_text", map[string]any{"msg": "hello; exit 1"}); resp["output"] != "hello; exit 1\n" {
+		t.Errorf("echo_text = %v", resp)
+	}
+	if resp := call("echo_text", map[string]any{}); resp["_error"] == nil {
+		t.Errorf("missing required arg must be error: %v", resp)
+	}
+	if resp := call("echo_lines", map[string]any{"a": "x", "b": "y"}); slices.Equal(resp["lines"].([]any), []any{"x", "y"}) != true {
+		t.Errorf("echo_lines = %v", resp)
+	}
+	if resp := call("echo_json", map[string]any{}); resp["ok"] != true {
+		t.Errorf("echo_json = %v", resp)
+	}
+	if resp := call("env", map[string]any{}); resp["output"] != "hello\n" {
+		t.Errorf("env = %v", resp)
+	}
+	if resp := call("secret", map[string]any{}); resp["_error"] == nil {
+		t.Errorf("environment of the process must not be passed: %v", resp)
+	}
+	resp := call("slow", map[string]any{})
+	if msg, ok := resp["_error"].(string); ok != true || strings.Contains(msg, "timeout") != true {
+		t.Errorf("slow = %v", resp)
+	}
+
+	// the child process keeps stdout open after the command is killed
+	start := time.Now()
+	resp = call("orphan", map[string]any{})
+	if msg, ok := resp["_error"].(string); ok != true || strings.Contains(msg, "timeout") != true {
+		t.Errorf("orphan = %v", resp)
+	}
+	if elapsed := time.Since(start); commandWaitDelay+time.Second < elapsed {
+		t.Errorf("must not wait the child process: %s", elapsed)
+	}
+}
@@ -6,9 +6,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"sync"
 	"time"
 
-	"github.com/mark3labs/mcp-go/client"
 	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 	"google.golang.org/genai"
@@ -31,6 +32,18 @@ type ConnectOption struct {
 	MaxReconnects  int
 	ReconnectWait  time.Duration
 	ReqTimeout     time.Duration
+
+	ResponseValidation ValidationMode
+	MCPSyncInterval    time.Duration
+	ToolNamespace      string
+	MCPNamespace       MCPNamespaceFunc
+	CollisionPolicy    CollisionPolicy
+	Authorizer         Authorizer
+	IdentityToken      string
+	IdentitySeed       []byte
+	IdentityCreds      string
+	AuditSinks         []AuditSink
+	AuditRedactor      AuditRedactor
 }
 
 func NatsURL(url ...string) ConnectOptionFunc {
@@ -107,22 +120,43 @@ func RequestTimeout(timeout time.Duration) ConnectOptionFunc {
 	}
 }
 
+// ConnectResponseValidation sets default ResponseValidation of registered tools
+func ConnectResponseValidation(mode ValidationMode) ConnectOptionFunc {
+	return func(o *ConnectOption) {
+		o.ResponseValidation = mode
+	}
+}
+
+// ConnectMCPSyncInterval polls tools of bridged MCP servers in addition to tools/list_changed notification,
+// for servers that don't notify the changes
+func ConnectMCPSyncInterval(interval time.Duration) ConnectOptionFunc {
+	return func(o *ConnectOption) {
+		o.MCPSyncInterval = interval
+	}
+}
+
 type UseOptionFunc func(*UseOption)
 
 type UseOption struct {
-	Model              string
-	UseLocalTool       bool
-	SystemInstructions []*genai.Part
-	Temperature        float32
-	TopP               float32
-	MaxOutputTokens    int32
-	JSONOutput         bool
-	OutputSchema       TypeDef
-	ThinkingBudget     int32
-	ThinkingLevel      genai.ThinkingLevel
-	Logger             Logger
-	DebugMode          bool
-	DefaultArgsFunc    func() map[string]any
+	Model               string
+	UseLocalTool        bool
+	SystemInstructions  []*genai.Part
+	Temperature         float32
+	TopP                float32
+	MaxOutputTokens     int32
+	JSONOutput          bool
+	JSONOutputWithTools bool
+	OutputSchema        TypeDef
+	ThinkingBudget      int32
+	ThinkingLevel       genai.ThinkingLevel
+	Logger              Logger
+	DebugMode           bool
+	DefaultArgsFunc     func() map[string]any
+	ToolSelectTopK      int
+	ToolScorer          ToolScorer
+	ValidateOutput      bool
+	Approver            Approver
+	SessionID           string
 }
 
 func UseModel(name string) UseOptionFunc {
@@ -186,6 +220,21 @@ func UseJSONOutput(schema TypeDef) UseOptionFunc {
 	}
 }
 
+// UseJSONOutputWithTools enables tool calling with JSONOutput,
+// runs tool calling first, then requests the final result as JSON
+func UseJSONOutputWithTools(enable bool) UseOptionFunc {
+	return func(o *UseOption) {
+		o.JSONOutputWithTools = enable
+	}
+}
+
+// UseOutputValidation validates the output of GenerateJSON against OutputSchema
+func UseOutputValidation(enable bool) UseOptionFunc {
+	return func(o *UseOption) {
+		o.ValidateOutput = enable
+	}
+}
+
 func UseToolJSONOutput(conn *Conn, toolName string) UseOptionFunc {
 	return func(o *UseOption) {
 		if t, ok := conn.Tool(toolName); ok {
@@ -222,6 +271,19 @@ func UseDefaultArgs(fn func() map[string]any) UseOptionFunc {
 	}
 }
 
+// UseToolSelection offers only the topK tools relevant to each message,
+// other tools can be found by the model via ToolSearchFunctionName.
+// BM25Scorer is used if scorer is nil
+func UseToolSelection(topK int, scorer ToolScorer) UseOptionFunc {
+	if topK < 1 {
+		topK = defaultToolSelectTopK
+	}
+	return func(o *UseOption) {
+		o.ToolSelectTopK = topK
+		o.ToolScorer = scorer
+	}
+}
+
 func UseThinking(budget int32, level genai.ThinkingLevel) UseOptionFunc {
 	return func(o *UseOption) {
 		o.ThinkingBudget = budget
@@ -263,15 +325,27 @@ func Connect(options ...ConnectOptionFunc) (*Conn, error) {
 	natsOpt.MaxReconnect = opt.MaxReconnects
 	natsOpt.NoRandomize = opt.NoRandomize
 	natsOpt.NoEcho = opt.NoEcho
+	natsOpt.User = opt.AuthUser
+	natsOpt.Password = opt.AuthPassword
 	natsOpt.Timeout = opt.Timeout
 	natsOpt.ReconnectWait = opt.ReconnectWait
 	natsOpt.Servers = url
 
+	identity, err := opt.callerIdentity()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 	nc, err := natsOpt.Connect()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return newConn(natsOpt, opt, nc), nil
+	c := newConn(natsOpt, opt, nc)
+	c.identity = identity
+	if err := c.subscribeToolReplaced(); err != nil {
+		c.Close()
+		return nil, errors.WithStack(err)
+	}
+	return c, nil
 }
 
 func Generate(ctx context.Context, options ...UseOptionFunc) (Session, error) {
@@ -289,6 +363,21 @@ func GenerateJSON(ctx context.Context, options ...UseOptionFunc) (GenerateJSONFu
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	return generateJSONFunc(s, outputValidationSchema(options...))
+}
+
+func outputValidationSchema(options ...UseOptionFunc) TypeDef {
+	opt := &UseOption{}
+	for _, f := range options {
+		f(opt)
+	}
+	if opt.ValidateOutput {
+		return opt.OutputSchema
+	}
+	return nil
+}
+
+func generateJSONFunc(s Session, validateSchema TypeDef) (GenerateJSONFunc, error) {
 	if s.JSONOutput() != true {
 		return nil, errors.Errorf("require JSONOutput=true")
 	}
@@ -307,6 +396,11 @@ func GenerateJSON(ctx context.Context, options ...UseOptionFunc) (GenerateJSONFu
 			if err := json.Unmarshal([]byte(ret), &resp); err != nil {
 				return nil, errors.WithStack(err)
 			}
+			if validateSchema != nil {
+				if err := Validate(validateSchema, map[string]any(resp)); err != nil {
+					return nil, errors.WithStack(err)
+				}
+			}
 			return resp, nil
 		}
 		return nil, nil
@@ -314,50 +408,48 @@ func GenerateJSON(ctx context.Context, options ...UseOptionFunc) (GenerateJSONFu
 }
 
 type Conn struct {
-	ctx        context.Context
-	cancel     context.CancelFunc
-	natsOpt    nats.Options
-	opt        *ConnectOption
-	nc         *nats.Conn
-	subs       []*nats.Subscription
-	tools      []Tool
-	mcpClients []*client.Client
-	logger     Logger
+	id          string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	natsOpt     nats.Options
+	opt         *ConnectOption
+	nc          *nats.Conn
+	mutex       *sync.RWMutex
+	subs        []*nats.Subscription
+	tools       []Tool
+	toolSubs    map[string]*nats.Subscription
+	mcpSessions []*mcpSession
+	identity    callerIdentity
+	auditQueue  chan AuditRecord
+	auditDone   chan struct{}
+	logger      Logger
 }
 
 func (c *Conn) Close() {
 	c.cancel()
-	for _, sub := range c.subs {
+	c.mutex.RLock()
+	subs := c.subs
+	c.mutex.RUnlock()
+	for _, sub := range subs {
 		sub.Unsubscribe()
 	}
 	c.UnregisterTools()
-	for _, c := range c.mcpClients {
-		c.Close()
+	c.mutex.RLock()
+	sessions := c.mcpSessions
+	c.mutex.RUnlock()
+	for _, s := range sessions {
+		s.Close()
 	}
+	c.mutex.Lock()
 	c.subs = nil
+	c.mutex.Unlock()
+	<-c.auditDone
 	c.nc.Close()
 }
 
 func (c *Conn) UnregisterTools() error {
-	if len(c.tools) < 1 {
-		return nil
-	}
-	list := make([]WrapFunctionDeclaration, len(c.tools))
-	for i, t := range c.tools {
-		list[i] = t.FunctionDeclaration()
-	}
-	for _, dec := range list {
-		resp, err := requestWithData(
-			c,
-			TopicUnregisterTool,
-			JSONEncoder[WrapFunctionDeclaration](),
-			JSONEncoder[RespError](),
-			dec,
-		)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		if err := resp.Err(); err != nil {
+	for _, dec := range c.toolDeclarations() {
+		if err := c.unregisterDeclaration(dec); err != nil {
 			return errors.WithStack(err)
 		}
 	}
@@ -365,22 +457,39 @@ func (c *Conn) UnregisterTools() error {
 }
 
 func (c *Conn) RegisterTool(t Tool) error {
-	if err := subscribeReqResp(
-		c,
-		tooltopic(t.Name),
-		JSONEncoder[map[string]any](),
-		JSONEncoder[map[string]any](),
-		handleToolCall(t),
-	); err != nil {
+	if t.ResponseValidation == ValidationInherit {
+		t.ResponseValidation = c.opt.ResponseValidation
+	}
+	if _, err := c.registerTool(t, handleToolCall(c.ctx, t)); err != nil {
 		return errors.WithStack(err)
 	}
+	return nil
+}
 
+func (c *Conn) registerDeclaration(dec WrapFunctionDeclaration) error {
 	resp, err := requestWithData(
 		c,
 		TopicRegisterTool,
 		JSONEncoder[WrapFunctionDeclaration](),
 		JSONEncoder[RespError](),
-		t.FunctionDeclaration(),
+		dec,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := resp.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (c *Conn) unregisterDeclaration(dec WrapFunctionDeclaration) error {
+	resp, err := requestWithData(
+		c,
+		TopicUnregisterTool,
+		JSONEncoder[WrapFunctionDeclaration](),
+		JSONEncoder[RespError](),
+		dec,
 	)
 	if err != nil {
 		return errors.WithStack(err)
@@ -388,11 +497,58 @@ func (c *Conn) RegisterTool(t Tool) error {
 	if err := resp.Err(); err != nil {
 		return errors.WithStack(err)
 	}
+	return nil
+}
+
+func (c *Conn) appendTool(t Tool, sub *nats.Subscription) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.tools = append(c.tools, t)
+	c.toolSubs[t.Name] = sub
+}
+
+func (c *Conn) dropTool(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tools = slices.DeleteFunc(c.tools, func(t Tool) bool {
+		return t.Name == name
+	})
+	delete(c.toolSubs, name)
+}
+
+func (c *Conn) subscribeToolReplaced() error {
+	enc := JSONEncoder[toolReplace]()
+	sub, err := c.nc.Subscribe(TopicToolReplaced, func(msg *nats.Msg) {
+		req, err := enc.Decode(msg.Data)
+		if err != nil {
+			c.logger.Warnf("decode %s: %+v", TopicToolReplaced, errors.WithStack(err))
+			return
+		}
+		c.handleToolReplaced(req)
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	c.nc.Flush()
+	c.addSub(sub)
 	return nil
 }
 
+func (c *Conn) toolDeclarations() []WrapFunctionDeclaration {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	list := make([]WrapFunctionDeclaration, len(c.tools))
+	for i, t := range c.tools {
+		list[i] = t.FunctionDeclaration()
+	}
+	return list
+}
+
 func (c *Conn) Tool(name string) (Tool, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	for _, t := range c.tools {
 		if t.Name == name {
 			return t, true
@@ -401,7 +557,7 @@ func (c *Conn) Tool(name string) (Tool, bool) {
 	return Tool{}, false
 }
 
-func (c *Conn) listTools(useLocalTool bool) ([]genai.FunctionDeclaration, error) {
+func (c *Conn) listTools(useLocalTool bool) ([]WrapFunctionDeclaration, error) {
 	remoteList, err := request(
 		c,
 		TopicListTool,
@@ -411,30 +567,46 @@ func (c *Conn) listTools(useLocalTool bool) ([]genai.FunctionDeclaration, error)
 		return nil, errors.WithStack(err)
 	}
 
-	declares := make([]genai.FunctionDeclaration, 0, len(remoteList))
+	declares := make([]WrapFunctionDeclaration, 0, len(remoteList))
 	for _, d := range remoteList {
 		if _, ok := c.Tool(d.Name); ok {
 			if useLocalTool != true {
 				continue
 			}
 		}
-		declares = append(declares, d.ToGenAI())
+		declares = append(declares, d)
 	}
 	return declares, nil
 }
 
 func (c *Conn) Use(ctx context.Context, options ...UseOptionFunc) (Session, error) {
-	rc := &defaultRemoteCall{c, nil, nil}
+	rc := &defaultRemoteCall{c, nil, nil, "", "", c.identity}
 	return createSession(ctx, c, rc, options...)
 }
 
+// GenerateJSON returns GenerateJSONFunc that calls tools of the registry first and then requests the final result as JSON following UseJSONOutput schema
+func (c *Conn) GenerateJSON(ctx context.Context, options ...UseOptionFunc) (GenerateJSONFunc, error) {
+	rc := &defaultRemoteCall{c, nil, nil, "", "", c.identity}
+	opts := append([]UseOptionFunc{UseJSONOutputWithTools(true)}, options...)
+	s, err := createSession(ctx, c, rc, opts...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return generateJSONFunc(s, outputValidationSchema(opts...))
+}
+
+// Call calls the tool directly, the Approver is not asked for the tool that RequiresApproval
 func (c *Conn) Call(ctx context.Context, name string, req Req) (Resp, error) {
 	if localTool, ok := c.Tool(name); ok {
-		ret := handleToolCall(localTool)(req)
+		msg, err := c.callMsg(name, req.ToMap(), "", "", c.identity)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		ret := c.toolCallHandler(localTool, handleToolCall(ctx, localTool))(msg, req)
 		return Resp(ret), nil
 	}
 
-	rc := &defaultRemoteCall{c, nil, nil}
+	rc := &defaultRemoteCall{c, nil, nil, "", "", c.identity}
 	ret, err := rc.callFunction(name, req.ToMap())
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -443,14 +615,10 @@ func (c *Conn) Call(ctx context.Context, name string, req Req) (Resp, error) {
 }
 
 func (c *Conn) toolKeepAlive() {
-	if len(c.tools) < 1 {
+	list := c.toolDeclarations()
+	if len(list) < 1 {
 		return
 	}
-
-	list := make([]WrapFunctionDeclaration, len(c.tools))
-	for i, t := range c.tools {
-		list[i] = t.FunctionDeclaration()
-	}
 	resp, err := requestWithData(
 		c,
 		TopicToolKeepalive,
@@ -485,20 +653,26 @@ func (c *Conn) toolKeepAliveLoop(ctx context.Context) {
 func newConn(natsOpt nats.Options, opt *ConnectOption, nc *nats.Conn) *Conn {
 	ctx, cancel := context.WithCancel(context.Background())
 	c := &Conn{
-		ctx:        ctx,
-		cancel:     cancel,
-		natsOpt:    natsOpt,
-		opt:        opt,
-		nc:         nc,
-		subs:       make([]*nats.Subscription, 0),
-		tools:      make([]Tool, 0),
-		mcpClients: make([]*client.Client, 0),
+		id:          nats.NewInbox(),
+		ctx:         ctx,
+		cancel:      cancel,
+		natsOpt:     natsOpt,
+		opt:         opt,
+		nc:          nc,
+		mutex:       new(sync.RWMutex),
+		subs:        make([]*nats.Subscription, 0),
+		tools:       make([]Tool, 0),
+		toolSubs:    make(map[string]*nats.Subscription),
+		mcpSessions: make([]*mcpSession, 0),
+		auditQueue:  make(chan AuditRecord, auditQueueSize),
+		auditDone:   make(chan struct{}),
 		logger: &stdLogger{
 			log.New(os.Stdout, "polaris ", log.LstdFlags),
 			false,
 		},
 	}
 	go c.toolKeepAliveLoop(ctx)
+	go c.auditLoop(ctx)
 	return c
 }
 
@@ -539,8 +713,24 @@ func requestWithData[Req any, Resp any](c *Conn, topic string, encReq Encoder[Re
 	return rr, nil
 }
 
+// requestMsg is requestWithData for the message that already has data and header
+func requestMsg[Resp any](c *Conn, req *nats.Msg, encResp Encoder[Resp]) (Resp, error) {
+	var resp Resp
+	msg, err := c.nc.RequestMsg(req, c.opt.ReqTimeout)
+	if err != nil {
+		return resp, errors.WithStack(err)
+	}
+	c.nc.Flush()
+	rr, err := encResp.Decode(msg.Data)
+	if err != nil {
+		return resp, errors.WithStack(err)
+	}
+	return rr, nil
+}
+
 type respHandler[Resp any] func() Resp
 type reqrespHandler[Req any, Resp any] func(Req) Resp
+type reqrespMsgHandler[Req any, Resp any] func(*nats.Msg, Req) Resp
 
 func subscribeResp[Resp any](c *Conn, topic string, encResp Encoder[Resp], handler respHandler[Resp]) error {
 	sub, err := c.nc.Subscribe(topic, func(msg *nats.Msg) {
@@ -557,17 +747,32 @@ func subscribeResp[Resp any](c *Conn, topic string, encResp Encoder[Resp], handl
 		return errors.WithStack(err)
 	}
 	c.nc.Flush()
-	c.subs = append(c.subs, sub)
+	c.addSub(sub)
 	return nil
 }
 
 func subscribeReqResp[Req any, Resp any](c *Conn, topic string, encReq Encoder[Req], encResp Encoder[Resp], handler reqrespHandler[Req, Resp]) error {
+	if _, err := subscribeReqRespSub(c, topic, encReq, encResp, handler); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// subscribeReqRespSub is subscribeReqResp that returns subscription to unsubscribe it individually
+func subscribeReqRespSub[Req any, Resp any](c *Conn, topic string, encReq Encoder[Req], encResp Encoder[Resp], handler reqrespHandler[Req, Resp]) (*nats.Subscription, error) {
+	return subscribeReqRespMsg(c, topic, encReq, encResp, func(_ *nats.Msg, req Req) Resp {
+		return handler(req)
+	})
+}
+
+// subscribeReqRespMsg is subscribeReqRespSub that passes the request message to handler
+func subscribeReqRespMsg[Req any, Resp any](c *Conn, topic string, encReq Encoder[Req], encResp Encoder[Resp], handler reqrespMsgHandler[Req, Resp]) (*nats.Subscription, error) {
 	sub, err := c.nc.Subscribe(topic, func(msg *nats.Msg) {
 		req, err := encReq.Decode(msg.Data)
 		if err != nil {
 			log.Printf("WARN: req %+v", errors.WithStack(err))
 		}
-		resp := handler(req)
+		resp := handler(msg, req)
 		data, err := encResp.Encode(resp)
 		if err != nil {
 			log.Printf("WARN: resp: %+v", errors.WithStack(err))
@@ -577,11 +782,26 @@ func subscribeReqResp[Req any, Resp any](c *Conn, topic string, encReq Encoder[R
 		}
 	})
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 	c.nc.Flush()
+	c.addSub(sub)
+	return sub, nil
+}
+
+func (c *Conn) addSub(sub *nats.Subscription) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.subs = append(c.subs, sub)
-	return nil
+}
+
+func (c *Conn) unsubscribe(sub *nats.Subscription) error {
+	c.mutex.Lock()
+	c.subs = slices.DeleteFunc(c.subs, func(s *nats.Subscription) bool {
+		return s == sub
+	})
+	c.mutex.Unlock()
+	return errors.WithStack(sub.Unsubscribe())
 }
 
 func geminiClient(ctx context.Context) (*genai.Client, error) {
@@ -1,6 +1,9 @@
 package polaris
 
 import (
+	"context"
+	"maps"
+
 	"google.golang.org/genai"
 )
 
@@ -173,24 +176,66 @@ func (m jsonMap) ToMap() map[string]any {
 	return map[string]any(ret)
 }
 
+// applyDefaults fills missing or null values with advertised Default
+func applyDefaults(schema *WrapSchema, m map[string]any) {
+	if schema == nil {
+		return
+	}
+	for key, prop := range schema.Properties {
+		v, ok := m[key]
+		if ok != true || v == nil {
+			if prop.Default != nil {
+				m[key] = prop.Default
+			}
+			continue
+		}
+
+		switch genai.Type(prop.Type) {
+		case genai.TypeObject:
+			if sub, isMap := toStringMap(v); isMap {
+				applyDefaults(prop, sub)
+			}
+		case genai.TypeArray:
+			if prop.Items == nil || genai.Type(prop.Items.Type) != genai.TypeObject {
+				continue
+			}
+			if items, isArray := v.([]any); isArray {
+				for _, item := range items {
+					if sub, isMap := toStringMap(item); isMap {
+						applyDefaults(prop.Items, sub)
+					}
+				}
+			}
+		}
+	}
+}
+
 type ReqCtx struct {
+	ctx         context.Context
 	req         jsonMap
 	paramSchema Object
 }
 
+func (c *ReqCtx) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 func (c *ReqCtx) Int(key string) int {
-	t := c.paramSchema.Properties[key]
+	t := c.property(key)
 	if tt, ok := t.(Int); ok {
 		return c.req.Int(key, tt.Default)
 	}
-	if _, ok := t.(IntEnum); ok {
-		return c.req.Int(key, 0)
+	if tt, ok := t.(IntEnum); ok {
+		return c.req.Int(key, tt.Default)
 	}
 	return c.req.Int(key, 0)
 }
 
 func (c *ReqCtx) Float64(key string) float64 {
-	t := c.paramSchema.Properties[key]
+	t := c.property(key)
 	if tt, ok := t.(Float); ok {
 		return c.req.Float64(key, tt.Default)
 	}
@@ -198,18 +243,18 @@ func (c *ReqCtx) Float64(key string) float64 {
 }
 
 func (c *ReqCtx) String(key string) string {
-	t := c.paramSchema.Properties[key]
+	t := c.property(key)
 	if tt, ok := t.(String); ok {
 		return c.req.String(key, tt.Default)
 	}
-	if _, ok := t.(StringEnum); ok {
-		return c.req.String(key, "")
+	if tt, ok := t.(StringEnum); ok {
+		return c.req.String(key, tt.Default)
 	}
 	return c.req.String(key, "")
 }
 
 func (c *ReqCtx) Bool(key string) bool {
-	t := c.paramSchema.Properties[key]
+	t := c.property(key)
 	if tt, ok := t.(Bool); ok {
 		return c.req.Bool(key, tt.Default)
 	}
@@ -217,7 +262,7 @@ func (c *ReqCtx) Bool(key string) bool {
 }
 
 func (c *ReqCtx) IntArray(key string) []int {
-	t := c.paramSchema.Properties[key]
+	t := c.property(key)
 	if _, ok := t.(IntArray); ok {
 		return c.req.IntArray(key, []int{})
 	}
@@ -230,7 +275,7 @@ func (c *ReqCtx) IntArray(key string) []int {
 }
 
 func (c *ReqCtx) FloatArray(key string) []float64 {
-	t := c.paramSchema.Properties[key]
+	t := c.property(key)
 	if _, ok := t.(FloatArray); ok {
 		return c.req.Float64Array(key, []float64{})
 	}
@@ -243,7 +288,7 @@ func (c *ReqCtx) FloatArray(key string) []float64 {
 }
 
 func (c *ReqCtx) StringArray(key string) []string {
-	t := c.paramSchema.Properties[key]
+	t := c.property(key)
 	if _, ok := t.(StringArray); ok {
 		return c.req.StringArray(key, []string{})
 	}
@@ -255,16 +300,83 @@ func (c *ReqCtx) StringArray(key string) []string {
 	return c.req.StringArray(key, []string{})
 }
 
+// property returns TypeDef of key, Ref is resolved by Defs
+func (c *ReqCtx) property(key string) TypeDef {
+	return c.deref(c.paramSchema.Properties[key])
+}
+
+func (c *ReqCtx) deref(t TypeDef) TypeDef {
+	for i := 0; i < maxRefHops; i += 1 {
+		r, ok := t.(Ref)
+		if ok != true {
+			return t
+		}
+		t = c.paramSchema.Defs[r.Name]
+	}
+	return nil
+}
+
+// Variant returns index of the supplied variant of AnyOf/OneOf, or -1
+func (c *ReqCtx) Variant(key string) int {
+	variants := unionVariants(c.property(key))
+	if len(variants) < 1 {
+		return -1
+	}
+	v, ok := c.req[key]
+	if ok != true {
+		return -1
+	}
+	defs := Object{Defs: c.paramSchema.Defs}.Schema().Defs
+	for i, t := range variants {
+		schema := t.Schema()
+		schema.Defs = defs
+		if ValidateSchema(schema, v) == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolve returns the supplied variant for AnyOf/OneOf
+func (c *ReqCtx) resolve(key string) TypeDef {
+	t := c.property(key)
+	if i := c.Variant(key); 0 <= i {
+		return c.deref(unionVariants(t)[i])
+	}
+	return t
+}
+
+// nested returns Object that inherits Defs, nested object may refer to the root $defs
+func (c *ReqCtx) nested(obj Object) Object {
+	if len(c.paramSchema.Defs) < 1 {
+		return obj
+	}
+	defs := maps.Clone(c.paramSchema.Defs)
+	maps.Copy(defs, obj.Defs)
+	obj.Defs = defs
+	return obj
+}
+
+func unionVariants(t TypeDef) []TypeDef {
+	switch tt := t.(type) {
+	case AnyOf:
+		return tt.Variants
+	case OneOf:
+		return tt.Variants
+	}
+	return nil
+}
+
 func (c *ReqCtx) Object(key string) *ReqCtx {
-	t := c.paramSchema.Properties[key]
+	t := c.resolve(key)
 	if obj, ok := t.(Object); ok {
-		return &ReqCtx{c.req.Object(key, jsonMap{}), obj}
+		return &ReqCtx{c.ctx, c.req.Object(key, jsonMap{}), c.nested(obj)}
 	}
 	return nil
 }
 
 func (c *ReqCtx) ObjectArray(key string) []*ReqCtx {
-	t := c.paramSchema.Properties[key]
+	t := c.resolve(key)
 	if oa, ok := t.(ObjectArray); ok {
 		data := c.req.ObjectArray(key, []jsonMap{})
 		if len(data) < 1 {
@@ -273,7 +385,7 @@ func (c *ReqCtx) ObjectArray(key string) []*ReqCtx {
 
 		ret := make([]*ReqCtx, len(data))
 		for i, jsonMap := range data {
-			ret[i] = &ReqCtx{jsonMap, Object{Properties: oa.Items}}
+			ret[i] = &ReqCtx{c.ctx, jsonMap, c.nested(Object{Properties: oa.Items})}
 		}
 		return ret
 	}
@@ -1,6 +1,7 @@
 package polaris
 
 import (
+	"context"
 	"testing"
 )
 
@@ -280,3 +281,135 @@ func TestJSONMapObjectArray(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyDefaults(t *testing.T) {
+	var got Req
+	tool := Tool{
+		Name: "test_tool",
+		Parameters: Object{
+			Properties: Properties{
+				"lines":     Int{Default: 10},
+				"direction": StringEnum{Values: []string{"asc", "desc"}, Default: "desc"},
+				"name":      String{},
+				"filter": Object{
+					Properties: Properties{
+						"level": String{Default: "info"},
+					},
+				},
+				"targets": ObjectArray{
+					Items: Properties{
+						"port": Int{Default: 80},
+					},
+				},
+			},
+		},
+		Handler: func(r *ReqCtx) (Resp, error) {
+			got = r.Req()
+			if r.Int("lines") != 10 {
+				t.Errorf("Int(lines) = %d, want 10", r.Int("lines"))
+			}
+			if r.String("direction") != "desc" {
+				t.Errorf("String(direction) = %s, want desc", r.String("direction"))
+			}
+			return Resp{}, nil
+		},
+	}
+
+	handleToolCall(context.TODO(), tool)(map[string]any{
+		"filter":  map[string]any{},
+		"targets": []any{map[string]any{}, map[string]any{"port": float64(443)}},
+	})
+	if got["lines"] != 10 || got["direction"] != "desc" {
+		t.Errorf("defaults = %v", got)
+	}
+	if _, ok := got["name"]; ok {
+		t.Errorf("zero default must not be set: %v", got)
+	}
+	if level := got.Object("filter", nil).String("level", ""); level != "info" {
+		t.Errorf("filter.level = %s, want info", level)
+	}
+	targets := got.ObjectArray("targets", nil)
+	if targets[0].Int("port", 0) != 80 || targets[1].Int("port", 0) != 443 {
+		t.Errorf("targets = %v", targets)
+	}
+}
+
+func TestCtxVariant(t *testing.T) {
+	schema := Object{
+		Properties: Properties{
+			"hosts": AnyOf{
+				Variants: []TypeDef{
+					String{},
+					StringArray{},
+					Object{
+						Properties: Properties{
+							"name": String{Required: true},
+						},
+					},
+				},
+			},
+			"name": String{},
+		},
+	}
+	tests := []struct {
+		name string
+		req  jsonMap
+		want int
+	}{
+		{"string", jsonMap{"hosts": "host1"}, 0},
+		{"array", jsonMap{"hosts": []any{"host1"}}, 1},
+		{"object", jsonMap{"hosts": map[string]any{"name": "host1"}}, 2},
+		{"mismatch", jsonMap{"hosts": true}, -1},
+		{"missing", jsonMap{}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ReqCtx{req: tt.req, paramSchema: schema}
+			if got := r.Variant("hosts"); got != tt.want {
+				t.Errorf("Variant() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("not union", func(tt *testing.T) {
+		r := &ReqCtx{req: jsonMap{"name": "foo"}, paramSchema: schema}
+		if got := r.Variant("name"); got != -1 {
+			tt.Errorf("Variant() = %d, want -1", got)
+		}
+	})
+	t.Run("Object variant", func(tt *testing.T) {
+		r := &ReqCtx{req: jsonMap{"hosts": map[string]any{"name": "host1"}}, paramSchema: schema}
+		obj := r.Object("hosts")
+		if obj == nil || obj.String("name") != "host1" {
+			tt.Errorf("Object() = %v", obj)
+		}
+	})
+}
+
+func TestCtxRef(t *testing.T) {
+	r := &ReqCtx{
+		req: jsonMap{
+			"filter": map[string]any{
+				"field": "name",
+				"and": []any{
+					map[string]any{"field": "age"},
+				},
+			},
+		},
+		paramSchema: testFilterSchema(),
+	}
+	filter := r.Object("filter")
+	if filter == nil {
+		t.Fatalf("Object(filter) must be resolved")
+	}
+	if v := filter.String("field"); v != "name" {
+		t.Errorf("field = %s", v)
+	}
+	and := filter.Object("and")
+	if and != nil {
+		t.Errorf("and is array")
+	}
+	if len(filter.paramSchema.Defs) != 1 {
+		t.Errorf("Defs must be inherited: %v", filter.paramSchema.Defs)
+	}
+}
@@ -1,6 +1,10 @@
 package polaris
 
 import (
+	"maps"
+	"math"
+	"strings"
+
 	"google.golang.org/genai"
 )
 
@@ -14,6 +18,10 @@ type WrapFunctionDeclaration struct {
 	Description string      `json:"description,omitempty"`
 	Parameters  *WrapSchema `json:"parameters,omitempty"`
 	Response    *WrapSchema `json:"response,omitempty"`
+
+	// RequiresApproval and ApprovalReason are not sent to the model
+	RequiresApproval bool   `json:"requires_approval,omitempty"`
+	ApprovalReason   string `json:"approval_reason,omitempty"`
 }
 
 func (w WrapFunctionDeclaration) ToGenAI() genai.FunctionDeclaration {
@@ -27,36 +35,214 @@ func (w WrapFunctionDeclaration) ToGenAI() genai.FunctionDeclaration {
 
 type WrapSchema struct {
 	Type        string                 `json:"type,omitempty"`
+	Title       string                 `json:"title,omitempty"`
 	Description string                 `json:"description,omitempty"`
 	Default     any                    `json:"default,omitempty"`
+	Example     any                    `json:"example,omitempty"`
 	Format      string                 `json:"format,omitempty"`
 	Enum        []string               `json:"enum,omitempty"`
 	Items       *WrapSchema            `json:"items,omitempty"`
+	MinItems    *int64                 `json:"minItems,omitempty"`
+	MaxItems    *int64                 `json:"maxItems,omitempty"`
+	Minimum     *float64               `json:"minimum,omitempty"`
+	Maximum     *float64               `json:"maximum,omitempty"`
+	MinLength   *int64                 `json:"minLength,omitempty"`
+	MaxLength   *int64                 `json:"maxLength,omitempty"`
+	Pattern     string                 `json:"pattern,omitempty"`
 	Nullable    *bool                  `json:"nullable,omitempty"`
 	Properties  map[string]*WrapSchema `json:"properties,omitempty"`
 	Required    []string               `json:"required,omitempty"`
+	AnyOf       []*WrapSchema          `json:"anyOf,omitempty"`
+	OneOf       []*WrapSchema          `json:"oneOf,omitempty"`
+	Ref         string                 `json:"$ref,omitempty"`
+	Defs        map[string]*WrapSchema `json:"$defs,omitempty"`
 }
 
 func (w WrapSchema) ToGenAI() *genai.Schema {
+	if w.hasRef() {
+		return w.ExpandRefs(DefaultRefExpandDepth).toGenAI()
+	}
+	return w.toGenAI()
+}
+
+func (w WrapSchema) toGenAI() *genai.Schema {
 	properties := make(map[string]*genai.Schema, len(w.Properties))
 	for k, v := range w.Properties {
-		properties[k] = v.ToGenAI()
+		properties[k] = v.toGenAI()
 	}
 	items := (*genai.Schema)(nil)
 	if w.Items != nil {
-		items = w.Items.ToGenAI()
+		items = w.Items.toGenAI()
+	}
+	// genai does not support oneOf
+	anyOf := []*genai.Schema(nil)
+	for _, v := range append(w.AnyOf, w.OneOf...) {
+		anyOf = append(anyOf, v.toGenAI())
 	}
 	return &genai.Schema{
 		Type:        ToGenAIType(w.Type),
+		Title:       w.Title,
 		Description: w.Description,
-		Default:     w.Default,
+		Default:     w.typedValue(w.Default),
+		Example:     w.typedValue(w.Example),
 		Format:      w.Format,
 		Enum:        w.Enum,
 		Items:       items,
+		MinItems:    w.MinItems,
+		MaxItems:    w.MaxItems,
+		Minimum:     w.Minimum,
+		Maximum:     w.Maximum,
+		MinLength:   w.MinLength,
+		MaxLength:   w.MaxLength,
+		Pattern:     w.Pattern,
 		Nullable:    w.Nullable,
 		Properties:  properties,
 		Required:    w.Required,
+		AnyOf:       anyOf,
+	}
+}
+
+const (
+	refPrefix       = "#/$defs/"
+	refLegacyPrefix = "#/definitions/"
+)
+
+// DefaultRefExpandDepth is the number of times the same $ref is expanded in ToGenAI
+const DefaultRefExpandDepth = 3
+
+// refName returns name of local reference, "#/$defs/Name" or "#/definitions/Name"
+func refName(ref string) (string, bool) {
+	if name, ok := strings.CutPrefix(ref, refPrefix); ok {
+		return name, true
+	}
+	if name, ok := strings.CutPrefix(ref, refLegacyPrefix); ok {
+		return name, true
+	}
+	return "", false
+}
+
+func (w *WrapSchema) hasRef() bool {
+	if w == nil {
+		return false
+	}
+	if w.Ref != "" || 0 < len(w.Defs) {
+		return true
+	}
+	for _, v := range w.Properties {
+		if v.hasRef() {
+			return true
+		}
+	}
+	for _, v := range append(w.AnyOf, w.OneOf...) {
+		if v.hasRef() {
+			return true
+		}
+	}
+	return w.Items.hasRef()
+}
+
+// ExpandRefs returns a copy of schema without $ref/$defs for providers that don't support refs.
+// Each reference is inlined at most maxDepth times in a path, deeper one is truncated
+// to type and description of the referred schema(no properties, no items)
+func (w WrapSchema) ExpandRefs(maxDepth int) *WrapSchema {
+	e := &refExpander{
+		maxDepth: maxDepth,
+		defs:     map[string]*WrapSchema{},
+		depth:    map[string]int{},
+	}
+	return e.expand(&w)
+}
+
+type refExpander struct {
+	maxDepth int
+	defs     map[string]*WrapSchema
+	depth    map[string]int
+}
+
+func (e *refExpander) expand(w *WrapSchema) *WrapSchema {
+	if w == nil {
+		return nil
+	}
+	if 0 < len(w.Defs) {
+		parent := e.defs
+		e.defs = maps.Clone(parent)
+		maps.Copy(e.defs, w.Defs)
+		defer func() { e.defs = parent }()
+	}
+
+	if w.Ref != "" {
+		return e.expandRef(w)
+	}
+
+	ret := *w
+	ret.Defs = nil
+	if w.Items != nil {
+		ret.Items = e.expand(w.Items)
+	}
+	if w.Properties != nil {
+		ret.Properties = make(map[string]*WrapSchema, len(w.Properties))
+		for k, v := range w.Properties {
+			ret.Properties[k] = e.expand(v)
+		}
+	}
+	ret.AnyOf = e.expandList(w.AnyOf)
+	ret.OneOf = e.expandList(w.OneOf)
+	return &ret
+}
+
+func (e *refExpander) expandList(list []*WrapSchema) []*WrapSchema {
+	if list == nil {
+		return nil
+	}
+	ret := make([]*WrapSchema, len(list))
+	for i, v := range list {
+		ret[i] = e.expand(v)
+	}
+	return ret
+}
+
+func (e *refExpander) expandRef(w *WrapSchema) *WrapSchema {
+	name, _ := refName(w.Ref)
+	def, ok := e.defs[name]
+	if ok != true || def == nil {
+		// unresolvable(remote or missing) reference, accepts anything
+		ret := *w
+		ret.Ref = ""
+		ret.Defs = nil
+		return &ret
+	}
+
+	ret := (*WrapSchema)(nil)
+	if e.maxDepth <= e.depth[name] {
+		ret = &WrapSchema{
+			Type:        def.Type,
+			Title:       def.Title,
+			Description: def.Description,
+			Nullable:    genai.Ptr(true),
+		}
+	} else {
+		e.depth[name] += 1
+		ret = e.expand(def)
+		e.depth[name] -= 1
+	}
+	if w.Description != "" {
+		ret.Description = w.Description
+	}
+	if w.Nullable != nil {
+		ret.Nullable = w.Nullable
+	}
+	return ret
+}
+
+// typedValue restores integer of Default/Example,
+// numbers are decoded as float64 after JSON round trip(e.g. registry)
+func (w WrapSchema) typedValue(v any) any {
+	if f, ok := v.(float64); ok && genai.Type(w.Type) == genai.TypeInteger {
+		if f == math.Trunc(f) {
+			return int64(f)
+		}
 	}
+	return v
 }
 
 func ToGenAIType(t string) genai.Type {
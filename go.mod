@@ -7,6 +7,7 @@ require (
 	github.com/nats-io/nats.go v1.39.1
 	github.com/pkg/errors v0.9.1
 	google.golang.org/genai v1.54.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -40,10 +41,10 @@ require (
 	github.com/google/go-tpm v0.9.3 // indirect
 	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/minio/highwayhash v1.0.3 // indirect
-	github.com/nats-io/jwt/v2 v2.7.3 // indirect
+	github.com/nats-io/jwt/v2 v2.7.3
 	github.com/nats-io/nats-server/v2 v2.11.0
-	github.com/nats-io/nkeys v0.4.10 // indirect
-	github.com/nats-io/nuid v1.0.1 // indirect
+	github.com/nats-io/nkeys v0.4.10
+	github.com/nats-io/nuid v1.0.1
 	golang.org/x/crypto v0.50.0 // indirect
 	golang.org/x/sys v0.43.0 // indirect
 	golang.org/x/time v0.15.0 // indirect
@@ -0,0 +1,799 @@
+package polaris
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/genai"
+	"gopkg.in/yaml.v3"
+)
+
+// JSONSchemaDialect is the $schema of exported JSON Schema,
+// TypeDef covers a subset of draft 2020-12
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+type SchemaFormat string
+
+const (
+	SchemaFormatJSON SchemaFormat = "json"
+	SchemaFormatYAML SchemaFormat = "yaml"
+)
+
+// ToolSchema is the file representation of Tool, same keys as MCP tool definition.
+// Handler is not included, set it after loading
+type ToolSchema struct {
+	Name         string         `json:"name"                   yaml:"name"`
+	Description  string         `json:"description,omitempty"  yaml:"description,omitempty"`
+	InputSchema  map[string]any `json:"inputSchema"            yaml:"inputSchema"`
+	OutputSchema map[string]any `json:"outputSchema,omitempty" yaml:"outputSchema,omitempty"`
+}
+
+func (t Tool) ToolSchema() ToolSchema {
+	ts := ToolSchema{
+		Name:        t.Name,
+		Description: t.Description,
+		InputSchema: JSONSchema(t.Parameters),
+	}
+	if 0 < len(t.Response.Properties) {
+		ts.OutputSchema = JSONSchema(t.Response)
+	}
+	return ts
+}
+
+func (ts ToolSchema) Tool() (Tool, error) {
+	if ts.Name == "" {
+		return Tool{}, errors.Errorf("tool name required")
+	}
+	params, err := objectFromJSONSchema(ts.InputSchema)
+	if err != nil {
+		return Tool{}, errors.Wrapf(err, "tool %s inputSchema", ts.Name)
+	}
+	resp := Object{}
+	if ts.OutputSchema != nil {
+		r, err := objectFromJSONSchema(ts.OutputSchema)
+		if err != nil {
+			return Tool{}, errors.Wrapf(err, "tool %s outputSchema", ts.Name)
+		}
+		resp = r
+	}
+	return Tool{
+		Name:        ts.Name,
+		Description: ts.Description,
+		Parameters:  params,
+		Response:    resp,
+	}, nil
+}
+
+func objectFromJSONSchema(schema map[string]any) (Object, error) {
+	if schema == nil {
+		return Object{}, nil
+	}
+	def, err := TypeDefFromJSONSchema(schema)
+	if err != nil {
+		return Object{}, errors.WithStack(err)
+	}
+	obj, ok := def.(Object)
+	if ok != true {
+		return Object{}, errors.Errorf("object schema required: %T", def)
+	}
+	obj.Required = true
+	return obj, nil
+}
+
+// LoadTools loads Tool definitions from .json/.yaml/.yml file,
+// the file has a ToolSchema, a list of ToolSchema or {"tools": [...]}
+func LoadTools(path string) ([]Tool, error) {
+	format, err := schemaFormatOf(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tools, err := ParseTools(data, format)
+	if err != nil {
+		return nil, errors.Wrapf(err, "file %s", path)
+	}
+	return tools, nil
+}
+
+func schemaFormatOf(path string) (SchemaFormat, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return SchemaFormatJSON, nil
+	case ".yaml", ".yml":
+		return SchemaFormatYAML, nil
+	}
+	return "", errors.Errorf("unsupported schema file: %s", path)
+}
+
+func ParseTools(data []byte, format SchemaFormat) ([]Tool, error) {
+	list, err := parseSchemaList[ToolSchema](data, format)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	tools := make([]Tool, len(list))
+	for i, ts := range list {
+		t, err := ts.Tool()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		tools[i] = t
+	}
+	return tools, nil
+}
+
+// parseSchemaList decodes data that has a T, a list of T or {"tools": [...]}
+func parseSchemaList[T any](data []byte, format SchemaFormat) ([]T, error) {
+	if format == SchemaFormatYAML {
+		jsonData, err := yamlToJSON(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		data = jsonData
+	}
+
+	list := []T{}
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		if err := json.Unmarshal(trimmed, &list); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return list, nil
+	}
+
+	wrapped := struct {
+		Tools []T `json:"tools"`
+	}{}
+	if err := json.Unmarshal(trimmed, &wrapped); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if wrapped.Tools != nil {
+		return wrapped.Tools, nil
+	}
+	var v T
+	if err := json.Unmarshal(trimmed, &v); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return append(list, v), nil
+}
+
+// ExportTools writes Tool definitions as a list of ToolSchema, e.g. for documentation
+func ExportTools(w io.Writer, format SchemaFormat, tools ...Tool) error {
+	list := make([]ToolSchema, len(tools))
+	for i, t := range tools {
+		list[i] = t.ToolSchema()
+	}
+	switch format {
+	case SchemaFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.WithStack(enc.Encode(list))
+	case SchemaFormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(list); err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(enc.Close())
+	}
+	return errors.Errorf("unsupported format: %s", format)
+}
+
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return jsonData, nil
+}
+
+// JSONSchema exports TypeDef as JSON Schema
+func JSONSchema(t TypeDef) map[string]any {
+	m := wrapToJSONSchema(t.Schema())
+	m["$schema"] = JSONSchemaDialect
+	return m
+}
+
+func wrapToJSONSchema(w *WrapSchema) map[string]any {
+	m := make(map[string]any)
+	if w == nil {
+		return m
+	}
+	nullable := w.Nullable != nil && *w.Nullable
+
+	if w.Ref != "" {
+		if nullable {
+			m["anyOf"] = []any{map[string]any{"$ref": w.Ref}, map[string]any{"type": "null"}}
+		} else {
+			m["$ref"] = w.Ref
+		}
+	}
+	if w.Type != "" {
+		jsonType := strings.ToLower(w.Type)
+		if nullable {
+			m["type"] = []any{jsonType, "null"}
+		} else {
+			m["type"] = jsonType
+		}
+	}
+	if w.Title != "" {
+		m["title"] = w.Title
+	}
+	if w.Description != "" {
+		m["description"] = w.Description
+	}
+	if w.Default != nil {
+		m["default"] = w.Default
+	}
+	if w.Example != nil {
+		m["examples"] = []any{w.Example}
+	}
+	if w.Format != "" && w.Format != "enum" {
+		m["format"] = w.Format
+	}
+	if 0 < len(w.Enum) {
+		m["enum"] = enumValues(w)
+	}
+	if w.Items != nil {
+		m["items"] = wrapToJSONSchema(w.Items)
+	}
+	setIfNotNil(m, "minItems", w.MinItems)
+	setIfNotNil(m, "maxItems", w.MaxItems)
+	setIfNotNil(m, "minimum", w.Minimum)
+	setIfNotNil(m, "maximum", w.Maximum)
+	setIfNotNil(m, "minLength", w.MinLength)
+	setIfNotNil(m, "maxLength", w.MaxLength)
+	if w.Pattern != "" {
+		m["pattern"] = w.Pattern
+	}
+	if 0 < len(w.Properties) {
+		properties := make(map[string]any, len(w.Properties))
+		for k, v := range w.Properties {
+			properties[k] = wrapToJSONSchema(v)
+		}
+		m["properties"] = properties
+	}
+	if 0 < len(w.Required) {
+		required := slices.Clone(w.Required)
+		slices.Sort(required)
+		m["required"] = required
+	}
+	if 0 < len(w.Defs) {
+		defs := make(map[string]any, len(w.Defs))
+		for k, v := range w.Defs {
+			defs[k] = wrapToJSONSchema(v)
+		}
+		m["$defs"] = defs
+	}
+	if 0 < len(w.AnyOf) {
+		m["anyOf"] = variantJSONSchemas(w.AnyOf, w.Type == "" && nullable)
+	}
+	if 0 < len(w.OneOf) {
+		m["oneOf"] = variantJSONSchemas(w.OneOf, w.Type == "" && nullable)
+	}
+	return m
+}
+
+func setIfNotNil[T any](m map[string]any, key string, v *T) {
+	if v != nil {
+		m[key] = *v
+	}
+}
+
+// enumValues returns integer values for IntEnum
+func enumValues(w *WrapSchema) []any {
+	values := make([]any, len(w.Enum))
+	for i, v := range w.Enum {
+		values[i] = v
+		if genai.Type(w.Type) == genai.TypeInteger {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				values[i] = n
+			}
+		}
+	}
+	return values
+}
+
+// variantJSONSchemas appends {"type": "null"} for nullable union
+func variantJSONSchemas(variants []*WrapSchema, nullable bool) []any {
+	list := make([]any, 0, len(variants)+1)
+	for _, v := range variants {
+		list = append(list, wrapToJSONSchema(v))
+	}
+	if nullable {
+		list = append(list, map[string]any{"type": "null"})
+	}
+	return list
+}
+
+// ParseJSONSchema parses JSON Schema document to TypeDef
+func ParseJSONSchema(data []byte) (TypeDef, error) {
+	schema := map[string]any{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return TypeDefFromJSONSchema(schema)
+}
+
+// TypeDefFromJSONSchema converts decoded JSON Schema to TypeDef
+func TypeDefFromJSONSchema(schema map[string]any) (TypeDef, error) {
+	def, err := typeDefFromJSONSchema(schema, "$", false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return def, nil
+}
+
+func typeDefFromJSONSchema(schema map[string]any, path string, isRequired bool) (TypeDef, error) {
+	desc := stringKeyword(schema, "description")
+	if ref, ok := schema["$ref"].(string); ok {
+		name, ok := refName(ref)
+		if ok != true {
+			return nil, errors.Errorf("%s: unsupported $ref %q", path, ref)
+		}
+		return Ref{Name: name, Description: desc, Required: isRequired}, nil
+	}
+	if _, ok := schema["anyOf"]; ok {
+		return unionFromJSONSchema(schema, "anyOf", path, isRequired)
+	}
+	if _, ok := schema["oneOf"]; ok {
+		return unionFromJSONSchema(schema, "oneOf", path, isRequired)
+	}
+
+	typ, nullable, err := jsonSchemaType(schema, path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	title := stringKeyword(schema, "title")
+	format := stringKeyword(schema, "format")
+	example := exampleKeyword(schema)
+
+	switch typ {
+	case "string":
+		if enum, ok := anyList(schema["enum"]); ok {
+			values, err := enumFromJSONSchema(enum, path)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			defaultValue, _ := schema["default"].(string)
+			return StringEnum{
+				Description: desc,
+				Values:      values,
+				Default:     defaultValue,
+				Example:     example,
+				Required:    isRequired,
+				Nullable:    nullable,
+			}, nil
+		}
+		defaultValue, _ := schema["default"].(string)
+		return String{
+			Title:       title,
+			Description: desc,
+			Default:     defaultValue,
+			Example:     example,
+			MinLength:   intKeyword(schema, "minLength"),
+			MaxLength:   intKeyword(schema, "maxLength"),
+			Pattern:     stringKeyword(schema, "pattern"),
+			Format:      format,
+			Required:    isRequired,
+			Nullable:    nullable,
+		}, nil
+	case "integer":
+		example = integerExample(example)
+		defaultValue := 0
+		if n, ok := toFloat64(schema["default"]); ok {
+			defaultValue = int(n)
+		}
+		if enum, ok := anyList(schema["enum"]); ok {
+			values, err := enumFromJSONSchema(enum, path)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return IntEnum{
+				Description: desc,
+				Values:      values,
+				Default:     defaultValue,
+				Example:     example,
+				Required:    isRequired,
+				Nullable:    nullable,
+			}, nil
+		}
+		return Int{
+			Title:       title,
+			Description: desc,
+			Default:     defaultValue,
+			Example:     example,
+			Minimum:     intKeyword(schema, "minimum"),
+			Maximum:     intKeyword(schema, "maximum"),
+			Format:      format,
+			Required:    isRequired,
+			Nullable:    nullable,
+		}, nil
+	case "number":
+		defaultValue, _ := toFloat64(schema["default"])
+		return Float{
+			Title:       title,
+			Description: desc,
+			Default:     defaultValue,
+			Example:     example,
+			Minimum:     floatKeyword(schema, "minimum"),
+			Maximum:     floatKeyword(schema, "maximum"),
+			Format:      format,
+			Required:    isRequired,
+			Nullable:    nullable,
+		}, nil
+	case "boolean":
+		defaultValue, _ := schema["default"].(bool)
+		return Bool{
+			Title:       title,
+			Description: desc,
+			Default:     defaultValue,
+			Example:     example,
+			Required:    isRequired,
+			Nullable:    nullable,
+		}, nil
+	case "array":
+		return arrayFromJSONSchema(schema, path, isRequired, nullable)
+	case "object":
+		props, err := propertiesFromJSONSchema(schema, path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defs, err := defsFromJSONSchema(schema, path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return Object{
+			Title:       title,
+			Description: desc,
+			Properties:  props,
+			Defs:        defs,
+			Example:     example,
+			Required:    isRequired,
+			Nullable:    nullable,
+		}, nil
+	case "":
+		// untyped schema accepts any value instead of rejecting the whole tool
+		return Any{
+			Title:       title,
+			Description: desc,
+			Example:     example,
+			Required:    isRequired,
+		}, nil
+	}
+	return nil, errors.Errorf("%s: unsupported type %q", path, typ)
+}
+
+// jsonSchemaType returns type and nullable of "type": "string", "type": ["string", "null"]
+// or OpenAPI style "nullable": true, type is "" for untyped schema
+func jsonSchemaType(schema map[string]any, path string) (string, NullableType, error) {
+	nullable := NullableNo
+	if v, ok := schema["nullable"].(bool); ok && v {
+		nullable = NullableYes
+	}
+	switch v := schema["type"].(type) {
+	case string:
+		return v, nullable, nil
+	case []any, []string:
+		list, _ := anyList(v)
+		typ := ""
+		for _, t := range list {
+			s, ok := t.(string)
+			if ok != true {
+				return "", "", errors.Errorf("%s: invalid type %v", path, v)
+			}
+			if s == "null" {
+				nullable = NullableYes
+				continue
+			}
+			if typ != "" {
+				return "", "", errors.Errorf("%s: multiple types %v, use anyOf", path, v)
+			}
+			typ = s
+		}
+		return typ, nullable, nil
+	case nil:
+		if _, ok := schema["properties"]; ok {
+			return "object", nullable, nil
+		}
+		if _, ok := schema["items"]; ok {
+			return "array", nullable, nil
+		}
+		return "", nullable, nil
+	}
+	return "", "", errors.Errorf("%s: invalid type %v", path, schema["type"])
+}
+
+func unionFromJSONSchema(schema map[string]any, key string, path string, isRequired bool) (TypeDef, error) {
+	list, ok := schema[key].([]any)
+	if ok != true {
+		return nil, errors.Errorf("%s: %s must be array", path, key)
+	}
+	desc := stringKeyword(schema, "description")
+	nullable := NullableNo
+	variants := make([]TypeDef, 0, len(list))
+	for i, v := range list {
+		variantSchema, ok := v.(map[string]any)
+		if ok != true {
+			return nil, errors.Errorf("%s.%s[%d]: schema must be object", path, key, i)
+		}
+		if variantSchema["type"] == "null" {
+			nullable = NullableYes
+			continue
+		}
+		def, err := typeDefFromJSONSchema(variantSchema, path+"."+key+"["+strconv.Itoa(i)+"]", false)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		variants = append(variants, def)
+	}
+
+	// nullable reference: {"anyOf": [{"$ref": "..."}, {"type": "null"}]}
+	if len(variants) == 1 && nullable == NullableYes {
+		if ref, ok := variants[0].(Ref); ok {
+			if desc != "" {
+				ref.Description = desc
+			}
+			ref.Required = isRequired
+			ref.Nullable = NullableYes
+			return ref, nil
+		}
+	}
+
+	if key == "oneOf" {
+		return OneOf{
+			Description: desc,
+			Variants:    variants,
+			Required:    isRequired,
+			Nullable:    nullable,
+		}, nil
+	}
+	return AnyOf{
+		Description: desc,
+		Variants:    variants,
+		Required:    isRequired,
+		Nullable:    nullable,
+	}, nil
+}
+
+// arrayFromJSONSchema uses IntArray/FloatArray/StringArray/BoolArray/ObjectArray
+// for plain items that have only type and description(and properties),
+// otherwise Array, array without items is Array of Any
+func arrayFromJSONSchema(schema map[string]any, path string, isRequired bool, nullable NullableType) (TypeDef, error) {
+	items := map[string]any{}
+	if v, ok := schema["items"]; ok {
+		m, ok := v.(map[string]any)
+		if ok != true {
+			return nil, errors.Errorf("%s: items must be object", path)
+		}
+		items = m
+	}
+	title := stringKeyword(schema, "title")
+	desc := stringKeyword(schema, "description")
+	minItems := intKeyword(schema, "minItems")
+	maxItems := intKeyword(schema, "maxItems")
+
+	itemType, plain := items["type"].(string)
+	for key := range items {
+		switch key {
+		case "type", "description":
+		case "properties", "required":
+			plain = plain && itemType == "object"
+		default:
+			plain = false
+		}
+	}
+	if plain {
+		itemDesc := stringKeyword(items, "description")
+		switch itemType {
+		case "integer":
+			return IntArray{
+				Title:           title,
+				Description:     desc,
+				ItemDescription: itemDesc,
+				MinItems:        minItems,
+				MaxItems:        maxItems,
+				Required:        isRequired,
+				Nullable:        nullable,
+			}, nil
+		case "number":
+			return FloatArray{
+				Title:           title,
+				Description:     desc,
+				ItemDescription: itemDesc,
+				MinItems:        minItems,
+				MaxItems:        maxItems,
+				Required:        isRequired,
+				Nullable:        nullable,
+			}, nil
+		case "string":
+			return StringArray{
+				Title:           title,
+				Description:     desc,
+				ItemDescription: itemDesc,
+				MinItems:        minItems,
+				MaxItems:        maxItems,
+				Required:        isRequired,
+				Nullable:        nullable,
+			}, nil
+		case "boolean":
+			return BoolArray{
+				Title:           title,
+				Description:     desc,
+				ItemDescription: itemDesc,
+				MinItems:        minItems,
+				MaxItems:        maxItems,
+				Required:        isRequired,
+				Nullable:        nullable,
+			}, nil
+		case "object":
+			props, err := propertiesFromJSONSchema(items, path+"[]")
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return ObjectArray{
+				Title:           title,
+				Description:     desc,
+				ItemDescription: itemDesc,
+				MinItems:        minItems,
+				MaxItems:        maxItems,
+				Required:        isRequired,
+				Nullable:        nullable,
+				Items:           props,
+			}, nil
+		}
+	}
+
+	itemDef, err := typeDefFromJSONSchema(items, path+"[]", false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return Array{
+		Title:       title,
+		Description: desc,
+		Items:       itemDef,
+		MinItems:    minItems,
+		MaxItems:    maxItems,
+		Required:    isRequired,
+		Nullable:    nullable,
+	}, nil
+}
+
+func propertiesFromJSONSchema(schema map[string]any, path string) (Properties, error) {
+	requiredMap := make(map[string]struct{})
+	if list, ok := anyList(schema["required"]); ok {
+		for _, v := range list {
+			if key, ok := v.(string); ok {
+				requiredMap[key] = struct{}{}
+			}
+		}
+	}
+
+	props := Properties{}
+	properties, ok := schema["properties"].(map[string]any)
+	if ok != true {
+		return props, nil
+	}
+	for key, v := range properties {
+		propSchema, ok := v.(map[string]any)
+		if ok != true {
+			return nil, errors.Errorf("%s.%s: schema must be object", path, key)
+		}
+		_, isRequired := requiredMap[key]
+		def, err := typeDefFromJSONSchema(propSchema, path+"."+key, isRequired)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		props[key] = def
+	}
+	return props, nil
+}
+
+func defsFromJSONSchema(schema map[string]any, path string) (Definitions, error) {
+	defs := Definitions{}
+	for _, key := range []string{"definitions", "$defs"} {
+		m, ok := schema[key].(map[string]any)
+		if ok != true {
+			continue
+		}
+		for name, v := range m {
+			defSchema, ok := v.(map[string]any)
+			if ok != true {
+				return nil, errors.Errorf("%s.%s.%s: schema must be object", path, key, name)
+			}
+			def, err := typeDefFromJSONSchema(defSchema, path+"."+key+"."+name, false)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			defs[name] = def
+		}
+	}
+	if len(defs) < 1 {
+		return nil, nil
+	}
+	return defs, nil
+}
+
+func enumFromJSONSchema(enum []any, path string) ([]string, error) {
+	values := make([]string, len(enum))
+	for i, v := range enum {
+		switch vv := v.(type) {
+		case string:
+			values[i] = vv
+		default:
+			n, ok := toFloat64(v)
+			if ok != true || n != math.Trunc(n) {
+				return nil, errors.Errorf("%s: unsupported enum value %v", path, v)
+			}
+			values[i] = strconv.FormatInt(int64(n), 10)
+		}
+	}
+	return values, nil
+}
+
+// anyList accepts []string too, for schema constructed in Go rather than decoded JSON
+func anyList(v any) ([]any, bool) {
+	switch list := v.(type) {
+	case []any:
+		return list, true
+	case []string:
+		ret := make([]any, len(list))
+		for i, s := range list {
+			ret[i] = s
+		}
+		return ret, true
+	}
+	return nil, false
+}
+
+func stringKeyword(schema map[string]any, key string) string {
+	if v, ok := schema[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func intKeyword(schema map[string]any, key string) *int {
+	if n, ok := toFloat64(schema[key]); ok {
+		return genai.Ptr(int(n))
+	}
+	return nil
+}
+
+func floatKeyword(schema map[string]any, key string) *float64 {
+	if n, ok := toFloat64(schema[key]); ok {
+		return genai.Ptr(n)
+	}
+	return nil
+}
+
+// integerExample restores int of integer example, numbers are decoded as float64
+func integerExample(v any) any {
+	if f, ok := v.(float64); ok && f == math.Trunc(f) {
+		return int(f)
+	}
+	return v
+}
+
+// exampleKeyword returns the first of "examples" or OpenAPI style "example"
+func exampleKeyword(schema map[string]any) any {
+	if list, ok := schema["examples"].([]any); ok && 0 < len(list) {
+		return list[0]
+	}
+	return schema["example"]
+}
@@ -0,0 +1,287 @@
+package polaris
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func jsonSchemaRoundTrip(t *testing.T, def TypeDef) (TypeDef, []byte) {
+	t.Helper()
+
+	data, err := json.Marshal(JSONSchema(def))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	parsed, err := ParseJSONSchema(data)
+	if err != nil {
+		t.Fatalf("no error: %+v\n%s", err, data)
+	}
+	return parsed, data
+}
+
+func TestJSONSchemaRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		def  TypeDef
+	}{
+		{"Int", Int{Title: "t", Description: "d", Default: 10, Example: 3, Minimum: genai.Ptr(1), Maximum: genai.Ptr(100), Format: "int32", Nullable: NullableNo}},
+		{"Float", Float{Title: "t", Description: "d", Default: 1.5, Example: 2.5, Minimum: genai.Ptr(0.5), Maximum: genai.Ptr(9.5), Nullable: NullableYes}},
+		{"String", String{Title: "t", Description: "d", Default: "x", Example: "y", MinLength: genai.Ptr(1), MaxLength: genai.Ptr(8), Pattern: "^[a-z]+$", Format: "email", Nullable: NullableNo}},
+		{"Bool", Bool{Title: "t", Description: "d", Default: true, Example: false, Nullable: NullableYes}},
+		{"IntEnum", IntEnum{Description: "d", Values: []string{"100", "200"}, Default: 200, Example: 100, Nullable: NullableNo}},
+		{"StringEnum", StringEnum{Description: "d", Values: []string{"north", "south"}, Default: "north", Example: "south", Nullable: NullableYes}},
+		{"IntArray", IntArray{Title: "t", Description: "d", ItemDescription: "i", MinItems: genai.Ptr(1), MaxItems: genai.Ptr(3), Nullable: NullableNo}},
+		{"FloatArray", FloatArray{Description: "d", ItemDescription: "i", Nullable: NullableYes}},
+		{"StringArray", StringArray{Description: "d", ItemDescription: "i", Nullable: NullableNo}},
+		{"BoolArray", BoolArray{Description: "d", ItemDescription: "i", Nullable: NullableNo}},
+		{"ObjectArray", ObjectArray{
+			Description:     "d",
+			ItemDescription: "i",
+			Items: Properties{
+				"name": String{Required: true, Nullable: NullableNo},
+				"age":  Int{Nullable: NullableYes},
+			},
+			Nullable: NullableNo,
+		}},
+		{"Array", Array{
+			Description: "d",
+			Items: IntArray{
+				ItemDescription: "i",
+				Nullable:        NullableNo,
+			},
+			MinItems: genai.Ptr(2),
+			Nullable: NullableNo,
+		}},
+		{"Object", Object{
+			Title:       "t",
+			Description: "d",
+			Properties: Properties{
+				"name": String{Required: true, Nullable: NullableNo},
+				"address": Object{
+					Properties: Properties{
+						"city": String{Nullable: NullableYes},
+					},
+					Required: true,
+					Nullable: NullableNo,
+				},
+			},
+			Example:  map[string]any{"name": "foo"},
+			Nullable: NullableNo,
+		}},
+		{"AnyOf", AnyOf{
+			Description: "d",
+			Variants:    []TypeDef{String{Nullable: NullableNo}, StringArray{Nullable: NullableNo}},
+			Nullable:    NullableYes,
+		}},
+		{"OneOf", OneOf{
+			Variants: []TypeDef{Int{Nullable: NullableNo}, String{Nullable: NullableNo}},
+			Nullable: NullableNo,
+		}},
+		{"Ref", Object{
+			Properties: Properties{
+				"filter":   Ref{Name: "Filter", Required: true},
+				"optional": Ref{Name: "Filter", Nullable: NullableYes},
+			},
+			Defs: Definitions{
+				"Filter": Object{
+					Properties: Properties{
+						"field": String{Nullable: NullableNo},
+						"and":   Array{Items: Ref{Name: "Filter"}, Nullable: NullableNo},
+					},
+					Nullable: NullableNo,
+				},
+			},
+			Nullable: NullableNo,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, data := jsonSchemaRoundTrip(t, tt.def)
+			if reflect.DeepEqual(parsed, tt.def) != true {
+				t.Errorf("round trip\n got: %#v\nwant: %#v\n%s", parsed, tt.def, data)
+			}
+			_, data2 := jsonSchemaRoundTrip(t, parsed)
+			if bytes.Equal(data, data2) != true {
+				t.Errorf("export must be stable\n%s\n%s", data, data2)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaExport(t *testing.T) {
+	schema := JSONSchema(Object{
+		Properties: Properties{
+			"b":    String{Required: true},
+			"a":    IntEnum{Values: []string{"1", "2"}, Required: true, Nullable: NullableNo},
+			"tags": StringArray{Nullable: NullableNo},
+		},
+		Nullable: NullableNo,
+	})
+	data, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	want := `{"$schema":"https://json-schema.org/draft/2020-12/schema",` +
+		`"properties":{` +
+		`"a":{"enum":[1,2],"type":"integer"},` +
+		`"b":{"type":["string","null"]},` +
+		`"tags":{"items":{"type":"string"},"type":"array"}},` +
+		`"required":["a","b"],"type":"object"}`
+	if string(data) != want {
+		t.Errorf("JSONSchema\n got: %s\nwant: %s", data, want)
+	}
+}
+
+func TestParseJSONSchema(t *testing.T) {
+	t.Run("draft style", func(tt *testing.T) {
+		def, err := ParseJSONSchema([]byte(`{
+			"type": "object",
+			"properties": {
+				"id": {"type": "integer", "nullable": true, "example": 3},
+				"node": {"$ref": "#/definitions/Node"}
+			},
+			"definitions": {
+				"Node": {"properties": {"children": {"items": {"$ref": "#/definitions/Node"}}}}
+			}
+		}`))
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		obj := def.(Object)
+		if id := obj.Properties["id"].(Int); id.Nullable != NullableYes || id.Example != 3 {
+			tt.Errorf("id = %#v", id)
+		}
+		node := obj.Defs["Node"].(Object)
+		if children, ok := node.Properties["children"].(Array); ok != true || children.Items.(Ref).Name != "Node" {
+			tt.Errorf("Node = %#v", node)
+		}
+	})
+	t.Run("untyped", func(tt *testing.T) {
+		def, err := ParseJSONSchema([]byte(`{"description": "no type"}`))
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		if a, ok := def.(Any); ok != true || a.Description != "no type" {
+			tt.Errorf("def = %#v", def)
+		}
+	})
+	t.Run("error", func(tt *testing.T) {
+		for _, src := range []string{
+			`{"type": "object", "properties": {"x": {"type": "foo"}}}`,
+			`{"type": ["string", "integer"]}`,
+			`{"$ref": "https://example.com/schema.json"}`,
+			`{"type": "array", "items": true}`,
+		} {
+			if _, err := ParseJSONSchema([]byte(src)); err == nil {
+				tt.Errorf("must be error: %s", src)
+			}
+		}
+	})
+}
+
+func TestLoadTools(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"tools.json": `[{
+			"name": "get_weather",
+			"description": "weather of city",
+			"inputSchema": {
+				"type": "object",
+				"properties": {"city": {"type": "string", "description": "city name"}},
+				"required": ["city"]
+			},
+			"outputSchema": {
+				"type": "object",
+				"properties": {"temperature": {"type": "number"}}
+			}
+		}]`,
+		"tools.yaml": `
+tools:
+  - name: get_weather
+    description: weather of city
+    inputSchema:
+      type: object
+      properties:
+        city:
+          type: string
+          description: city name
+      required: [city]
+    outputSchema:
+      type: object
+      properties:
+        temperature:
+          type: number
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(tt *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				tt.Fatalf("no error: %+v", err)
+			}
+			tools, err := LoadTools(path)
+			if err != nil {
+				tt.Fatalf("no error: %+v", err)
+			}
+			if len(tools) != 1 {
+				tt.Fatalf("len(tools) = %d", len(tools))
+			}
+			tool := tools[0]
+			if tool.Name != "get_weather" || tool.Description != "weather of city" {
+				tt.Errorf("tool = %+v", tool)
+			}
+			city, ok := tool.Parameters.Properties["city"].(String)
+			if ok != true || city.Required != true || city.Description != "city name" {
+				tt.Errorf("city = %#v", tool.Parameters.Properties["city"])
+			}
+			if _, ok := tool.Response.Properties["temperature"].(Float); ok != true {
+				tt.Errorf("temperature = %#v", tool.Response.Properties["temperature"])
+			}
+		})
+	}
+
+	t.Run("unsupported", func(tt *testing.T) {
+		if _, err := LoadTools(filepath.Join(dir, "tools.txt")); err == nil {
+			tt.Errorf("must be error")
+		}
+	})
+}
+
+func TestExportTools(t *testing.T) {
+	tool := Tool{
+		Name:        "search",
+		Description: "search documents",
+		Parameters: Object{
+			Properties: Properties{
+				"query": String{Required: true, Nullable: NullableNo},
+				"limit": Int{Default: 10, Nullable: NullableNo},
+			},
+			Required: true,
+			Nullable: NullableNo,
+		},
+	}
+	for _, format := range []SchemaFormat{SchemaFormatJSON, SchemaFormatYAML} {
+		t.Run(string(format), func(tt *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			if err := ExportTools(buf, format, tool); err != nil {
+				tt.Fatalf("no error: %+v", err)
+			}
+			tools, err := ParseTools(buf.Bytes(), format)
+			if err != nil {
+				tt.Fatalf("no error: %+v\n%s", err, buf.String())
+			}
+			if len(tools) != 1 {
+				tt.Fatalf("len(tools) = %d", len(tools))
+			}
+			if reflect.DeepEqual(tools[0].Parameters, tool.Parameters) != true {
+				tt.Errorf("Parameters\n got: %#v\nwant: %#v", tools[0].Parameters, tool.Parameters)
+			}
+		})
+	}
+}
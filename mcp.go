@@ -1,6 +1,15 @@
 package polaris
 
 import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -12,210 +21,475 @@ func (c *Conn) RegisterSSEMCPTools(baseURL string, initReq mcp.InitializeRequest
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	initResp, err := c.startMCPClient(mcpClient, initReq)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return c.bridgeMCPSession(newMCPSession(c.ctx, mcpClient, initResp))
+}
 
+// startMCPClient starts transport and negotiates with the server
+func (c *Conn) startMCPClient(mcpClient *client.Client, initReq mcp.InitializeRequest) (*mcp.InitializeResult, error) {
 	if err := mcpClient.Start(c.ctx); err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
-	initResp, err := mcpClient.Initialize(c.ctx, initReq)
+	ctx, cancel := context.WithTimeout(c.ctx, c.opt.ReqTimeout)
+	defer cancel()
+
+	initResp, err := mcpClient.Initialize(ctx, initReq)
 	if err != nil {
+		mcpClient.Close()
+		return nil, errors.WithStack(err)
+	}
+	c.logger.Debugf("mcpClient init=%v", initResp.ServerInfo)
+	return initResp, nil
+}
+
+// bridgeMCPSession registers tools of the session and keeps them in sync, all transports share this
+func (c *Conn) bridgeMCPSession(session *mcpSession) error {
+	session.namespace = c.mcpNamespace(session.server)
+	if err := c.syncMCPTools(session); err != nil {
+		c.unbridgeMCPTools(session)
+		session.Close()
 		return errors.WithStack(err)
 	}
-	c.logger.Debugf("sseMCPClient init=%v", initResp.ServerInfo)
+	c.mutex.Lock()
+	c.mcpSessions = append(c.mcpSessions, session)
+	c.mutex.Unlock()
+
+	go c.watchMCPSession(session)
+	return nil
+}
 
-	r, err := mcpClient.ListTools(c.ctx, mcp.ListToolsRequest{})
+// watchMCPSession syncs tools on tools/list_changed, reconnect of the client or MCPSyncInterval
+func (c *Conn) watchMCPSession(session *mcpSession) {
+	var tick <-chan time.Time
+	if 0 < c.opt.MCPSyncInterval {
+		ticker := time.NewTicker(c.opt.MCPSyncInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-session.done:
+			return
+		case <-session.syncRequest:
+		case <-tick:
+		}
+		if err := c.syncMCPTools(session); err != nil {
+			c.logger.Warnf("sync mcp tools: %+v", err)
+		}
+	}
+}
+
+// mcpSession holds the client of a MCP server,
+// the client is replaced when the server is restarted or reconnected
+type mcpSession struct {
+	// server is the result of the first initialize, restarted server is assumed to be the same
+	server *mcp.InitializeResult
+	// namespace of bridged tools by ConnectMCPNamespace
+	namespace string
+
+	mutex  *sync.RWMutex
+	parent context.Context
+	client *client.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	closed bool
+	done   chan struct{}
+
+	// reconnect creates new client when the session is expired, nil if not supported
+	reconnect      func() (*client.Client, error)
+	reconnectMutex *sync.Mutex
+
+	// bridged tools are guarded by syncMutex
+	syncMutex   *sync.Mutex
+	syncRequest chan struct{}
+	bridged     map[string]*mcpBridgedTool
+}
+
+type mcpBridgedTool struct {
+	// tool is registered one, the name may have namespace
+	tool   Tool
+	digest string
+}
+
+func newMCPSession(ctx context.Context, mcpClient *client.Client, server *mcp.InitializeResult) *mcpSession {
+	s := &mcpSession{
+		server:         server,
+		mutex:          new(sync.RWMutex),
+		parent:         ctx,
+		done:           make(chan struct{}),
+		reconnectMutex: new(sync.Mutex),
+		syncMutex:      new(sync.Mutex),
+		syncRequest:    make(chan struct{}, 1),
+		bridged:        make(map[string]*mcpBridgedTool),
+	}
+	s.replace(mcpClient)
+	return s
+}
+
+// current returns client and context that is canceled when the client is replaced
+func (s *mcpSession) current() (*client.Client, context.Context) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.client, s.ctx
+}
+
+// replace closes previous client, returns false if session is already closed.
+// tools are synced with the new client because the server may have changed while disconnected
+func (s *mcpSession) replace(mcpClient *client.Client) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.closeClient()
+	ctx, cancel := context.WithCancel(s.parent)
+	s.client = mcpClient
+	s.ctx = ctx
+	s.cancel = cancel
+	if mcpClient != nil {
+		mcpClient.OnNotification(s.handleNotification)
+		s.requestSync()
+	}
+	return true
+}
+
+func (s *mcpSession) handleNotification(notification mcp.JSONRPCNotification) {
+	if notification.Method == mcpToolsListChanged {
+		s.requestSync()
+	}
+}
+
+func (s *mcpSession) requestSync() {
+	select {
+	case s.syncRequest <- struct{}{}:
+	default:
+		// already requested
+	}
+}
+
+// reconnectClient replaces expired client by reconnect,
+// concurrent callers of the same expired client share a reconnect
+func (s *mcpSession) reconnectClient(expired *client.Client) (*client.Client, context.Context, error) {
+	s.reconnectMutex.Lock()
+	defer s.reconnectMutex.Unlock()
+
+	if mcpClient, ctx := s.current(); mcpClient != expired && mcpClient != nil {
+		return mcpClient, ctx, nil
+	}
+	if s.reconnect == nil {
+		return nil, nil, errors.New("mcp session is not reconnectable")
+	}
+	mcpClient, err := s.reconnect()
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
+	}
+	if s.replace(mcpClient) != true {
+		mcpClient.Close()
+		return nil, nil, errors.New("mcp session is closed")
 	}
+	mcpClient, ctx := s.current()
+	return mcpClient, ctx, nil
+}
 
-	tools := make([]Tool, 0)
-	for _, t := range r.Tools {
-		tools = append(tools, Tool{
-			Name:        t.Name,
-			Description: t.Description,
-			Parameters:  convertInputSchema(t.InputSchema),
-			Response:    Object{},
-		})
+func (s *mcpSession) closeClient() {
+	if s.cancel != nil {
+		s.cancel()
 	}
+	if s.client != nil {
+		s.client.Close()
+	}
+	s.client = nil
+}
 
-	for _, t := range tools {
-		if err := subscribeReqResp(
-			c,
-			tooltopic(t.Name),
-			JSONEncoder[map[string]any](),
-			JSONEncoder[map[string]any](),
-			handleMCPToolCall(c.ctx, mcpClient, t),
-		); err != nil {
-			return errors.WithStack(err)
-		}
+func (s *mcpSession) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
 	}
+	s.closed = true
+	close(s.done)
+	s.closeClient()
+}
 
-	for _, t := range tools {
-		resp, err := requestWithData(
-			c,
-			TopicRegisterTool,
-			JSONEncoder[WrapFunctionDeclaration](),
-			JSONEncoder[RespError](),
-			t.FunctionDeclaration(),
-		)
-		if err != nil {
-			return errors.WithStack(err)
+const mcpToolsListChanged = "notifications/tools/list_changed"
+
+// syncMCPTools bridges tools of MCP server into the registry,
+// tools removed or changed on the server are unregistered(and registered again if changed),
+// a tool that fails to bridge is skipped and others are bridged
+func (c *Conn) syncMCPTools(session *mcpSession) error {
+	session.syncMutex.Lock()
+	defer session.syncMutex.Unlock()
+
+	mcpClient, ctx := session.current()
+	if mcpClient == nil {
+		// server is restarting, synced after restart
+		return nil
+	}
+	tools, err := c.listMCPTools(ctx, mcpClient)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if hasMCPResources(session.server) {
+		for _, t := range mcpResourceTools(session) {
+			if _, ok := tools[t.Name]; ok {
+				c.logger.Warnf("mcp tool %s conflicts with resource tool", t.Name)
+				continue
+			}
+			tools[t.Name] = t
 		}
-		if err := resp.Err(); err != nil {
-			return errors.WithStack(err)
+	}
+
+	for name, b := range session.bridged {
+		if t, ok := tools[name]; ok && b.digest == mcpToolDigest(t) {
+			continue
 		}
+		c.unbridgeMCPTool(b)
+		delete(session.bridged, name)
 	}
 
-	c.tools = append(c.tools, tools...)
-	c.mcpClients = append(c.mcpClients, mcpClient)
+	names := slices.Sorted(maps.Keys(tools))
+	for _, name := range names {
+		if _, ok := session.bridged[name]; ok {
+			continue
+		}
+		b, err := c.bridgeMCPTool(session, tools[name])
+		if err != nil {
+			// not bridged tool is tried again on next sync
+			c.logger.Warnf("skip mcp tool %s: %+v", name, err)
+			continue
+		}
+		session.bridged[name] = b
+	}
 	return nil
 }
 
-func primitiveToTypeDef(schema map[string]any, isRequired bool) TypeDef {
-	propertyDesc, ok := schema["description"].(string)
-	if ok != true {
-		propertyDesc = ""
+// bridgeMCPTool forwards calls to the MCP server, a tool that has Handler(e.g. resource tools) is handled locally
+func (c *Conn) bridgeMCPTool(session *mcpSession, t Tool) (*mcpBridgedTool, error) {
+	handler := handleMCPToolCall(session, t)
+	if t.Handler != nil {
+		handler = handleToolCall(c.ctx, t)
 	}
+	registered, err := c.registerTool(t, handler, session.namespace)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c.logger.Debugf("mcp tool %s bridged as %s", t.Name, registered.Name)
+	return &mcpBridgedTool{registered, mcpToolDigest(t)}, nil
+}
 
-	switch schema["type"] {
-	case "number":
-		f := Float{
-			Description: propertyDesc,
-			Required:    isRequired,
-		}
-		if defaultValue, hasDefault := schema["default"].(float64); hasDefault {
-			f.Default = defaultValue
-		}
-		return f
-	case "string":
-		s := String{
-			Description: propertyDesc,
-			Required:    isRequired,
-		}
-		if defaultValue, hasDefault := schema["default"].(string); hasDefault {
-			s.Default = defaultValue
+func (c *Conn) unbridgeMCPTool(b *mcpBridgedTool) {
+	if err := c.unregisterTool(b.tool.Name); err != nil {
+		c.logger.Warnf("unregister %s: %+v", b.tool.Name, err)
+	}
+	c.logger.Debugf("mcp tool %s unbridged", b.tool.Name)
+}
+
+func (c *Conn) unbridgeMCPTools(session *mcpSession) {
+	session.syncMutex.Lock()
+	defer session.syncMutex.Unlock()
+
+	for name, b := range session.bridged {
+		c.unbridgeMCPTool(b)
+		delete(session.bridged, name)
+	}
+}
+
+func mcpToolDigest(t Tool) string {
+	data, err := json.Marshal(t.FunctionDeclaration())
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// listMCPTools returns tools of MCP server by name, tools that have unsupported inputSchema are skipped
+func (c *Conn) listMCPTools(ctx context.Context, mcpClient *client.Client) (map[string]Tool, error) {
+	raws, err := rawMCPTools(ctx, mcpClient)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	tools := make(map[string]Tool, len(raws))
+	for _, t := range raws {
+		defs := make(map[string]any, len(t.InputSchema.Defs)+len(t.InputSchema.Definitions))
+		maps.Copy(defs, t.InputSchema.Definitions)
+		maps.Copy(defs, t.InputSchema.Defs)
+		params, err := convertInputSchema(t.InputSchema.ToolInputSchema, defs)
+		if err != nil {
+			c.logger.Warnf("skip mcp tool %s: %+v", t.Name, err)
+			continue
 		}
-		return s
-	case "boolean":
-		b := Bool{
-			Description: propertyDesc,
-			Required:    isRequired,
+		resp, err := objectFromJSONSchema(t.OutputSchema)
+		if err != nil {
+			// the tool is still callable, the response is not described
+			c.logger.Warnf("mcp tool %s outputSchema: %+v", t.Name, err)
+			resp = Object{}
 		}
-		if defaultValue, hasDefault := schema["default"].(bool); hasDefault {
-			b.Default = defaultValue
+		tools[t.Name] = Tool{
+			Name:        t.Name,
+			Description: t.Description,
+			Parameters:  params,
+			Response:    resp,
 		}
-		return b
 	}
-	return nil
+	return tools, nil
 }
 
-func arrayToTypedef(schema map[string]any, isRequired bool) TypeDef {
-	propertyDesc, ok := schema["description"].(string)
-	if ok != true {
-		propertyDesc = ""
-	}
+// mcpRawTool is a tool of tools/list, mcp.Tool drops $defs of inputSchema and outputSchema
+type mcpRawTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	InputSchema struct {
+		mcp.ToolInputSchema
+		Defs        map[string]any `json:"$defs"`
+		Definitions map[string]any `json:"definitions"`
+	} `json:"inputSchema"`
+	OutputSchema map[string]any `json:"outputSchema"`
+}
 
-	items := schema["items"].(map[string]any)
-	switch items["type"] {
-	case "number":
-		return FloatArray{
-			Description: propertyDesc,
-			Required:    isRequired,
+// rawMCPTools requests all pages of tools/list as raw JSON
+func rawMCPTools(ctx context.Context, mcpClient *client.Client) ([]mcpRawTool, error) {
+	tools := make([]mcpRawTool, 0)
+	cursor := mcp.Cursor("")
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
 		}
-	case "string":
-		return StringArray{
-			Description: propertyDesc,
-			Required:    isRequired,
+		resp, err := mcpClient.GetTransport().SendRequest(ctx, transport.JSONRPCRequest{
+			JSONRPC: mcp.JSONRPC_VERSION,
+			ID:      rawRequestID.Add(-1),
+			Method:  "tools/list",
+			Params:  params,
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
 		}
-	case "boolean":
-		return BoolArray{
-			Description: propertyDesc,
-			Required:    isRequired,
+		if resp.Error != nil {
+			return nil, errors.New(resp.Error.Message)
+		}
+
+		result := struct {
+			Tools      []mcpRawTool `json:"tools"`
+			NextCursor mcp.Cursor   `json:"nextCursor"`
+		}{}
+		if err := json.Unmarshal(resp.Result, &result); err != nil {
+			return nil, errors.WithStack(err)
 		}
-	case "object":
-		itemProperties := items["properties"].(map[string]any)
-		return ObjectArray{
-			Description: propertyDesc,
-			Items:       objectToProperties(itemProperties, isRequired),
-			Required:    isRequired,
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" {
+			return tools, nil
 		}
+		cursor = result.NextCursor
 	}
-	return nil
 }
 
-func objectToProperties(schema map[string]any, isRequired bool) Properties {
-	propertyDesc, ok := schema["description"].(string)
-	if ok != true {
-		propertyDesc = ""
-	}
-	requires, ok := schema["required"].([]string)
-	if ok != true {
-		requires = []string{}
+// mcpToolResult is result of tools/call,
+// mcp.CallToolResult drops structuredContent so tools/call is requested as raw JSON
+type mcpToolResult struct {
+	Content           []map[string]any `json:"content"`
+	StructuredContent map[string]any   `json:"structuredContent"`
+	IsError           bool             `json:"isError"`
+}
+
+func callMCPTool(ctx context.Context, mcpClient *client.Client, name string, args map[string]any) (*mcpToolResult, error) {
+	resp, err := mcpClient.GetTransport().SendRequest(ctx, transport.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      rawRequestID.Add(-1),
+		Method:  string(mcp.MethodToolsCall),
+		Params: map[string]any{
+			"name":      name,
+			"arguments": args,
+		},
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
-	requiredMap := make(map[string]struct{})
-	for _, key := range requires {
-		requiredMap[key] = struct{}{}
+	if resp.Error != nil {
+		return nil, errors.New(resp.Error.Message)
 	}
 
-	objectProp := Properties{}
-	for itemPropKey, itemPropSchema := range schema {
-		_, subRequired := requiredMap[itemPropKey]
+	result := &mcpToolResult{}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
 
-		subSchema, subSchemaOK := itemPropSchema.(map[string]any)
-		if subSchemaOK != true {
-			continue
-		}
-		switch subSchema["type"] {
-		case "number", "string", "boolean":
-			objectProp[itemPropKey] = primitiveToTypeDef(subSchema, subRequired)
-		case "array":
-			objectProp[itemPropKey] = arrayToTypedef(subSchema, subRequired)
-		case "object":
-			objectProp[itemPropKey] = Object{
-				Description: propertyDesc,
-				Properties:  objectToProperties(subSchema, subRequired),
-				Required:    isRequired,
-			}
+func (r *mcpToolResult) texts() []string {
+	texts := make([]string, 0, len(r.Content))
+	for _, c := range r.Content {
+		if text, ok := c["text"].(string); ok && c["type"] == "text" {
+			texts = append(texts, text)
 		}
 	}
-	return objectProp
+	return texts
+}
+
+func (r *mcpToolResult) errorMessage() string {
+	if texts := r.texts(); 0 < len(texts) {
+		return strings.Join(texts, "\n")
+	}
+	return "tool returned error"
 }
 
-func convertInputSchema(schema mcp.ToolInputSchema) Object {
-	prop := Properties{}
-	requiredMap := make(map[string]struct{}, len(schema.Required))
-	for _, key := range schema.Required {
-		requiredMap[key] = struct{}{}
+// resp returns structuredContent as it is.
+// Tools that have outputSchema may return the structured result only as JSON text,
+// otherwise contents are {"results": [...]}, text as string and others(image, resource...) as content object
+func (r *mcpToolResult) resp(hasOutputSchema bool) map[string]any {
+	if r.StructuredContent != nil {
+		return r.StructuredContent
+	}
+	if texts := r.texts(); hasOutputSchema && len(r.Content) == 1 && len(texts) == 1 {
+		structured := map[string]any{}
+		if err := json.Unmarshal([]byte(texts[0]), &structured); err == nil {
+			return structured
+		}
 	}
 
-	for key, properySchema := range schema.Properties {
-		schema, ok := properySchema.(map[string]any)
-		if ok != true {
+	results := make([]any, len(r.Content))
+	for i, c := range r.Content {
+		if text, ok := c["text"].(string); ok && c["type"] == "text" {
+			results[i] = text
 			continue
 		}
+		results[i] = c
+	}
+	return Resp{"results": results}.ToMap()
+}
 
-		_, isRequired := requiredMap[key]
+// rawRequestID uses negative id not to collide with requests of mcp client
+var rawRequestID atomic.Int64
 
-		switch schema["type"] {
-		case "number", "string", "boolean":
-			prop[key] = primitiveToTypeDef(schema, isRequired)
-		case "array":
-			prop[key] = arrayToTypedef(schema, isRequired)
-		case "object":
-			propertyDesc, ok := schema["description"].(string)
-			if ok != true {
-				propertyDesc = ""
-			}
-			objProperties := schema["properties"].(map[string]any)
-			prop[key] = Object{
-				Description: propertyDesc,
-				Properties:  objectToProperties(objProperties, isRequired),
-				Required:    isRequired,
-			}
-		}
+// convertInputSchema converts inputSchema by the JSON Schema importer,
+// unsupported schema is an error instead of being dropped silently
+func convertInputSchema(schema mcp.ToolInputSchema, defs map[string]any) (Object, error) {
+	required := make([]any, len(schema.Required))
+	for i, key := range schema.Required {
+		required[i] = key
 	}
-	return Object{
-		Properties: prop,
-		Required:   true,
+	raw := map[string]any{
+		"type":     "object",
+		"required": required,
+	}
+	if schema.Properties != nil {
+		raw["properties"] = schema.Properties
+	}
+	if 0 < len(defs) {
+		raw["$defs"] = defs
+	}
+	obj, err := objectFromJSONSchema(raw)
+	if err != nil {
+		return Object{}, errors.WithStack(err)
 	}
+	return obj, nil
 }
@@ -0,0 +1,683 @@
+package polaris
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"google.golang.org/genai"
+)
+
+func TestConvertInputSchemaUnion(t *testing.T) {
+	obj, err := convertInputSchema(mcp.ToolInputSchema{
+		Type: "object",
+		Properties: map[string]any{
+			"hosts": map[string]any{
+				"description": "host name or list",
+				"anyOf": []any{
+					map[string]any{"type": "string"},
+					map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+				},
+			},
+			"id": map[string]any{
+				"oneOf": []any{
+					map[string]any{"type": "string"},
+					map[string]any{"type": "null"},
+				},
+			},
+		},
+		Required: []string{"hosts"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	hosts, ok := obj.Properties["hosts"].(AnyOf)
+	if ok != true {
+		t.Fatalf("hosts = %#v", obj.Properties["hosts"])
+	}
+	if hosts.Description != "host name or list" || hosts.Required != true || len(hosts.Variants) != 2 {
+		t.Errorf("hosts = %#v", hosts)
+	}
+	if _, ok := hosts.Variants[1].(StringArray); ok != true {
+		t.Errorf("hosts.Variants[1] = %#v", hosts.Variants[1])
+	}
+
+	id, ok := obj.Properties["id"].(OneOf)
+	if ok != true {
+		t.Fatalf("id = %#v", obj.Properties["id"])
+	}
+	if id.Nullable != NullableYes || len(id.Variants) != 1 {
+		t.Errorf("id = %#v", id)
+	}
+}
+
+func TestConvertInputSchemaRef(t *testing.T) {
+	obj, err := convertInputSchema(mcp.ToolInputSchema{
+		Type: "object",
+		Properties: map[string]any{
+			"filter": map[string]any{"$ref": "#/$defs/Filter"},
+			"tags": map[string]any{
+				"type":  "array",
+				"items": map[string]any{"$ref": "#/definitions/Tag"},
+			},
+		},
+		Required: []string{"filter"},
+	}, map[string]any{
+		"Filter": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"field": map[string]any{"type": "string"},
+				"and": map[string]any{
+					"type":  "array",
+					"items": map[string]any{"$ref": "#/$defs/Filter"},
+				},
+			},
+		},
+		"Tag": map[string]any{"type": "string"},
+	})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	filter, ok := obj.Properties["filter"].(Ref)
+	if ok != true || filter.Name != "Filter" || filter.Required != true {
+		t.Errorf("filter = %#v", obj.Properties["filter"])
+	}
+	tags, ok := obj.Properties["tags"].(Array)
+	if ok != true {
+		t.Fatalf("tags = %#v", obj.Properties["tags"])
+	}
+	if ref, ok := tags.Items.(Ref); ok != true || ref.Name != "Tag" {
+		t.Errorf("tags.Items = %#v", tags.Items)
+	}
+	def, ok := obj.Defs["Filter"].(Object)
+	if ok != true {
+		t.Fatalf("Defs = %#v", obj.Defs)
+	}
+	and, ok := def.Properties["and"].(Array)
+	if ok != true {
+		t.Fatalf("and = %#v", def.Properties["and"])
+	}
+	if ref, ok := and.Items.(Ref); ok != true || ref.Name != "Filter" {
+		t.Errorf("and.Items = %#v", and.Items)
+	}
+
+	if err := Validate(obj, map[string]any{
+		"filter": map[string]any{"and": []any{map[string]any{"field": "x"}}},
+		"tags":   []any{"a"},
+	}); err != nil {
+		t.Errorf("no error: %+v", err)
+	}
+}
+
+func TestConvertInputSchema(t *testing.T) {
+	obj, err := convertInputSchema(mcp.ToolInputSchema{
+		Type: "object",
+		Properties: map[string]any{
+			"count": map[string]any{"type": "integer", "description": "count", "default": float64(10)},
+			"ratio": map[string]any{"type": "number"},
+			"level": map[string]any{"type": "string", "enum": []any{"low", "high"}},
+			"port":  map[string]any{"type": "integer", "enum": []any{float64(80), float64(443)}},
+			"name":  map[string]any{"type": []any{"string", "null"}},
+			"matrix": map[string]any{
+				"type":  "array",
+				"items": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+			},
+			"ids": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+			"option": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"verbose": map[string]any{"type": "boolean"},
+					"depth":   map[string]any{"type": "integer"},
+				},
+				"required": []any{"depth"},
+			},
+			"items": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"key": map[string]any{"type": "string"},
+					},
+					"required": []any{"key"},
+				},
+			},
+			"empty": map[string]any{"type": "object"},
+		},
+		Required: []string{"count", "level"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	count, ok := obj.Properties["count"].(Int)
+	if ok != true || count.Required != true || count.Default != 10 || count.Description != "count" {
+		t.Errorf("count = %#v", obj.Properties["count"])
+	}
+	if ratio, ok := obj.Properties["ratio"].(Float); ok != true || ratio.Required {
+		t.Errorf("ratio = %#v", obj.Properties["ratio"])
+	}
+	level, ok := obj.Properties["level"].(StringEnum)
+	if ok != true || level.Required != true || len(level.Values) != 2 || level.Values[1] != "high" {
+		t.Errorf("level = %#v", obj.Properties["level"])
+	}
+	port, ok := obj.Properties["port"].(IntEnum)
+	if ok != true || len(port.Values) != 2 || port.Values[0] != "80" {
+		t.Errorf("port = %#v", obj.Properties["port"])
+	}
+	if name, ok := obj.Properties["name"].(String); ok != true || name.Nullable != NullableYes {
+		t.Errorf("name = %#v", obj.Properties["name"])
+	}
+	matrix, ok := obj.Properties["matrix"].(Array)
+	if ok != true {
+		t.Fatalf("matrix = %#v", obj.Properties["matrix"])
+	}
+	if _, ok := matrix.Items.(IntArray); ok != true {
+		t.Errorf("matrix.Items = %#v", matrix.Items)
+	}
+	if _, ok := obj.Properties["ids"].(IntArray); ok != true {
+		t.Errorf("ids = %#v", obj.Properties["ids"])
+	}
+	option, ok := obj.Properties["option"].(Object)
+	if ok != true {
+		t.Fatalf("option = %#v", obj.Properties["option"])
+	}
+	if option.Properties["depth"].IsRequired() != true || option.Properties["verbose"].IsRequired() {
+		t.Errorf("option = %#v", option)
+	}
+	items, ok := obj.Properties["items"].(ObjectArray)
+	if ok != true || items.Items["key"].IsRequired() != true {
+		t.Errorf("items = %#v", obj.Properties["items"])
+	}
+	if empty, ok := obj.Properties["empty"].(Object); ok != true || len(empty.Properties) != 0 {
+		t.Errorf("empty = %#v", obj.Properties["empty"])
+	}
+
+	if err := Validate(obj, map[string]any{
+		"count":  3,
+		"level":  "low",
+		"port":   443,
+		"name":   nil,
+		"matrix": []any{[]any{1, 2}, []any{3}},
+		"option": map[string]any{"depth": 1},
+		"items":  []any{map[string]any{"key": "a"}},
+	}); err != nil {
+		t.Errorf("no error: %+v", err)
+	}
+	if err := Validate(obj, map[string]any{"count": 1.5, "level": "mid"}); err == nil {
+		t.Errorf("must be error")
+	}
+}
+
+func TestConvertInputSchemaError(t *testing.T) {
+	tests := []struct {
+		name       string
+		properties map[string]any
+	}{
+		{"property is not object", map[string]any{"x": "string"}},
+		{"unknown type", map[string]any{"x": map[string]any{"type": "date"}}},
+		{"multiple types", map[string]any{"x": map[string]any{"type": []any{"string", "integer"}}}},
+		{"float enum", map[string]any{"x": map[string]any{"type": "integer", "enum": []any{1.5}}}},
+		{"remote ref", map[string]any{"x": map[string]any{"$ref": "https://example.com/schema.json"}}},
+		{"nested", map[string]any{"x": map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"y": map[string]any{"type": "array", "items": "string"}},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := convertInputSchema(mcp.ToolInputSchema{Type: "object", Properties: tt.properties}, nil)
+			if err == nil {
+				t.Errorf("must be error")
+			}
+		})
+	}
+}
+
+func TestConvertInputSchemaUntyped(t *testing.T) {
+	obj, err := convertInputSchema(mcp.ToolInputSchema{
+		Type: "object",
+		Properties: map[string]any{
+			"value": map[string]any{},
+			"note":  map[string]any{"description": "any value"},
+			"name":  map[string]any{"type": "string"},
+		},
+		Required: []string{"value"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("untyped property must not drop the tool: %+v", err)
+	}
+	value, ok := obj.Properties["value"].(Any)
+	if ok != true || value.Required != true {
+		t.Errorf("value = %#v", obj.Properties["value"])
+	}
+	note, ok := obj.Properties["note"].(Any)
+	if ok != true || note.Description != "any value" {
+		t.Errorf("note = %#v", obj.Properties["note"])
+	}
+	if s := value.Schema().ToGenAI(); s.Type != genai.TypeUnspecified {
+		t.Errorf("type = %s", s.Type)
+	}
+
+	for _, v := range []any{"text", float64(1), true, []any{"a"}, map[string]any{"k": "v"}} {
+		if err := ValidateSchema(obj.Schema(), map[string]any{"value": v, "note": v}); err != nil {
+			t.Errorf("%v must be valid: %+v", v, err)
+		}
+	}
+	if err := ValidateSchema(obj.Schema(), map[string]any{"name": "x"}); err == nil {
+		t.Errorf("required untyped property must be error")
+	}
+}
+
+func TestConvertInputSchemaArrayWithoutItems(t *testing.T) {
+	obj, err := convertInputSchema(mcp.ToolInputSchema{
+		Type: "object",
+		Properties: map[string]any{
+			"list": map[string]any{"type": "array", "description": "any values"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("array without items must not drop the tool: %+v", err)
+	}
+	list, ok := obj.Properties["list"].(Array)
+	if ok != true || list.Description != "any values" {
+		t.Fatalf("list = %#v", obj.Properties["list"])
+	}
+	if _, ok := list.Items.(Any); ok != true {
+		t.Errorf("items = %#v", list.Items)
+	}
+	if err := ValidateSchema(obj.Schema(), map[string]any{"list": []any{"a", float64(1), map[string]any{}}}); err != nil {
+		t.Errorf("no error: %+v", err)
+	}
+	if err := ValidateSchema(obj.Schema(), map[string]any{"list": "a"}); err == nil {
+		t.Errorf("non array must be error")
+	}
+}
+
+func TestConvertInputSchemaEmpty(t *testing.T) {
+	obj, err := convertInputSchema(mcp.ToolInputSchema{Type: "object"}, nil)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if obj.Required != true || len(obj.Properties) != 0 {
+		t.Errorf("obj = %#v", obj)
+	}
+}
+
+func waitRegistryTools(t *testing.T, conn *Conn, cond func(names []string) bool) {
+	t.Helper()
+
+	names := []string{}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		list, err := request(conn, TopicListTool, JSONEncoder[[]WrapFunctionDeclaration]())
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		names = names[:0]
+		for _, d := range list {
+			names = append(names, d.Name)
+		}
+		if cond(names) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("unexpected tools: %v", names)
+}
+
+func testAddedTool(s *server.MCPServer) {
+	s.AddTool(
+		mcp.NewTool("test_added", mcp.WithDescription("added later")),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("added"), nil
+		},
+	)
+}
+
+func TestMCPToolsSyncListChanged(t *testing.T) {
+	r, caller := testRegistry(t)
+
+	mcpServer := newTestMCPServer()
+	ts := server.NewTestServer(mcpServer)
+	t.Cleanup(ts.Close)
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("sse-mcp"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+	if err := conn.RegisterSSEMCPTools(ts.URL+"/sse", testMCPInitRequest()); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	waitRegistryTools(t, caller, func(names []string) bool {
+		return slices.Contains(names, "test_echo")
+	})
+
+	testAddedTool(mcpServer)
+	waitRegistryTools(t, caller, func(names []string) bool {
+		return slices.Contains(names, "test_added")
+	})
+	resp, err := caller.Call(context.Background(), "test_added", Req{})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if results, _ := resp["results"].([]any); len(results) != 1 || results[0] != "added" {
+		t.Errorf("resp = %v", resp)
+	}
+
+	mcpServer.DeleteTools("test_echo")
+	waitRegistryTools(t, caller, func(names []string) bool {
+		return slices.Contains(names, "test_echo") != true
+	})
+	if _, ok := conn.Tool("test_echo"); ok {
+		t.Errorf("removed tool must be removed from Conn")
+	}
+	resp, err = caller.Call(context.Background(), "test_echo", Req{"message": "removed"})
+	if err == nil {
+		t.Errorf("removed tool must not be called: %v", resp)
+	}
+}
+
+func TestMCPToolsSyncSkipFailedTool(t *testing.T) {
+	r, caller := testRegistry(t)
+
+	owner, err := Connect(NatsURL(r.ns.ClientURL()), Name("owner"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(owner.Close)
+	if err := owner.RegisterTool(testOwnerTool("test_echo", "owner")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	mcpServer := newTestMCPServer()
+	ts := server.NewTestServer(mcpServer)
+	t.Cleanup(ts.Close)
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("sse-mcp"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+	if err := conn.RegisterSSEMCPTools(ts.URL+"/sse", testMCPInitRequest()); err != nil {
+		t.Fatalf("tool that fails to bridge must be skipped: %+v", err)
+	}
+	waitRegistryTools(t, caller, func(names []string) bool {
+		return slices.Contains(names, "test_pid") && slices.Contains(names, "test_echo")
+	})
+	resp, err := caller.Call(context.Background(), "test_echo", Req{"message": "hello"})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if resp["owner"] != "owner" {
+		t.Errorf("tool of other Conn must not be replaced: %v", resp)
+	}
+
+	// bridged on next sync after the collision is resolved
+	if err := owner.unregisterTool("test_echo"); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	testAddedTool(mcpServer)
+	waitRegistryTools(t, caller, func(names []string) bool {
+		return slices.Contains(names, "test_added") && slices.Contains(names, "test_echo")
+	})
+	if _, ok := conn.Tool("test_echo"); ok != true {
+		t.Errorf("skipped tool must be bridged on next sync")
+	}
+}
+
+func TestMCPToolsSyncPolling(t *testing.T) {
+	r, caller := testRegistry(t)
+
+	mcpServer := &testStreamableHTTPServer{
+		mcp:      newTestMCPServer(),
+		token:    "secret",
+		sessions: map[string]bool{},
+		pending:  map[string][]byte{},
+	}
+	ts := httptest.NewServer(mcpServer)
+	t.Cleanup(ts.Close)
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("http-mcp"), ConnectMCPSyncInterval(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+	if err := conn.RegisterHTTPMCPTools(ts.URL, testMCPInitRequest(), HTTPMCPBearerToken("secret")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	// the test server doesn't notify list_changed
+	testAddedTool(mcpServer.mcp)
+	waitRegistryTools(t, caller, func(names []string) bool {
+		return slices.Contains(names, "test_added")
+	})
+
+	mcpServer.mcp.DeleteTools("test_pid")
+	waitRegistryTools(t, caller, func(names []string) bool {
+		return slices.Contains(names, "test_pid") != true && slices.Contains(names, "test_echo")
+	})
+}
+
+func TestMCPToolsListOnce(t *testing.T) {
+	_, conn := testRegistry(t)
+
+	listRequests := atomic.Int32{}
+	mcpServer := &testStreamableHTTPServer{
+		mcp:      newTestMCPServer(),
+		token:    "secret",
+		sessions: map[string]bool{},
+		pending:  map[string][]byte{},
+		result: func(method string, params json.RawMessage) any {
+			if method != "tools/list" {
+				return nil
+			}
+			listRequests.Add(1)
+			page := struct {
+				Cursor string `json:"cursor"`
+			}{}
+			json.Unmarshal(params, &page)
+			if page.Cursor == "" {
+				return map[string]any{
+					"tools": []any{map[string]any{
+						"name": "first",
+						"inputSchema": map[string]any{
+							"type":       "object",
+							"properties": map[string]any{"node": map[string]any{"$ref": "#/$defs/Node"}},
+							"$defs":      map[string]any{"Node": map[string]any{"type": "object"}},
+						},
+					}},
+					"nextCursor": "2",
+				}
+			}
+			return map[string]any{"tools": []any{map[string]any{
+				"name":         "second",
+				"inputSchema":  map[string]any{"type": "object"},
+				"outputSchema": map[string]any{"type": "object", "properties": map[string]any{"ok": map[string]any{"type": "boolean"}}},
+			}}}
+		},
+	}
+	ts := httptest.NewServer(mcpServer)
+	t.Cleanup(ts.Close)
+
+	opt := &HTTPMCPOption{Headers: http.Header{}, RequestTimeout: 5 * time.Second}
+	HTTPMCPBearerToken("secret")(opt)
+	opt.HTTPClient = newMCPHTTPClient(opt.RequestTimeout)
+	mcpClient := client.NewClient(newStreamableHTTP(ts.URL, opt, conn.logger))
+	if _, err := conn.startMCPClient(mcpClient, testMCPInitRequest()); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(func() { mcpClient.Close() })
+
+	tools, err := conn.listMCPTools(context.Background(), mcpClient)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if n := listRequests.Load(); n != 2 {
+		t.Errorf("tools/list must be requested once per page: %d", n)
+	}
+	if _, ok := tools["first"].Parameters.Defs["Node"]; ok != true {
+		t.Errorf("$defs = %#v", tools["first"].Parameters.Defs)
+	}
+	if _, ok := tools["second"].Response.Properties["ok"].(Bool); ok != true {
+		t.Errorf("outputSchema = %#v", tools["second"].Response)
+	}
+}
+
+func TestMCPToolResult(t *testing.T) {
+	r, caller := testRegistry(t)
+
+	mcpServer := &testStreamableHTTPServer{
+		mcp:      newTestMCPServer(),
+		token:    "secret",
+		sessions: map[string]bool{},
+		pending:  map[string][]byte{},
+		result: func(method string, params json.RawMessage) any {
+			switch method {
+			case "tools/list":
+				return map[string]any{"tools": []any{
+					map[string]any{
+						"name":        "weather",
+						"inputSchema": map[string]any{"type": "object", "properties": map[string]any{"city": map[string]any{"type": "string"}}},
+						"outputSchema": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"temperature": map[string]any{"type": "number"},
+								"condition":   map[string]any{"type": "string"},
+							},
+							"required": []any{"temperature"},
+						},
+					},
+					map[string]any{
+						"name":         "weather_text",
+						"inputSchema":  map[string]any{"type": "object"},
+						"outputSchema": map[string]any{"type": "object", "properties": map[string]any{"temperature": map[string]any{"type": "number"}}},
+					},
+					map[string]any{"name": "fail", "inputSchema": map[string]any{"type": "object"}},
+					map[string]any{"name": "image", "inputSchema": map[string]any{"type": "object"}},
+				}}
+			case "tools/call":
+				call := struct {
+					Name string `json:"name"`
+				}{}
+				json.Unmarshal(params, &call)
+				switch call.Name {
+				case "weather":
+					return map[string]any{
+						"content":           []any{map[string]any{"type": "text", "text": `{"temperature":21.5,"condition":"sunny"}`}},
+						"structuredContent": map[string]any{"temperature": 21.5, "condition": "sunny"},
+					}
+				case "weather_text":
+					return map[string]any{
+						"content": []any{map[string]any{"type": "text", "text": `{"temperature":3}`}},
+					}
+				case "fail":
+					return map[string]any{
+						"content": []any{map[string]any{"type": "text", "text": "city not found"}},
+						"isError": true,
+					}
+				case "image":
+					return map[string]any{
+						"content": []any{
+							map[string]any{"type": "text", "text": "chart"},
+							map[string]any{"type": "image", "data": "aGVsbG8=", "mimeType": "image/png"},
+						},
+					}
+				}
+			}
+			return nil
+		},
+	}
+	ts := httptest.NewServer(mcpServer)
+	t.Cleanup(ts.Close)
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("http-mcp"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+	if err := conn.RegisterHTTPMCPTools(ts.URL, testMCPInitRequest(), HTTPMCPBearerToken("secret")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	ctx := context.Background()
+	t.Run("outputSchema", func(t *testing.T) {
+		weather, ok := conn.Tool("weather")
+		if ok != true {
+			t.Fatalf("weather must be bridged")
+		}
+		if _, ok := weather.Response.Properties["temperature"].(Float); ok != true {
+			t.Errorf("Response = %#v", weather.Response)
+		}
+		if weather.Response.Properties["temperature"].IsRequired() != true {
+			t.Errorf("temperature must be required")
+		}
+	})
+	t.Run("structuredContent", func(t *testing.T) {
+		resp, err := caller.Call(ctx, "weather", Req{"city": "Tokyo"})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if resp["temperature"] != 21.5 || resp["condition"] != "sunny" {
+			t.Errorf("resp = %v", resp)
+		}
+	})
+	t.Run("json text", func(t *testing.T) {
+		resp, err := caller.Call(ctx, "weather_text", Req{})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if resp["temperature"] != float64(3) {
+			t.Errorf("resp = %v", resp)
+		}
+	})
+	t.Run("isError", func(t *testing.T) {
+		errs := make(chan error, 1)
+		fail, _ := conn.Tool("fail")
+		fail.ErrorHandler = func(err error) { errs <- err }
+		session := conn.mcpSessions[0]
+		resp := handleMCPToolCall(session, fail)(map[string]any{})
+		if msg, _ := resp["_error"].(string); msg != "tool fail: city not found" {
+			t.Errorf("resp = %v", resp)
+		}
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Errorf("must be error")
+			}
+		default:
+			t.Errorf("ErrorHandler must be called")
+		}
+
+		resp, err := caller.Call(ctx, "fail", Req{})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if _, ok := resp["_error"]; ok != true {
+			t.Errorf("resp = %v", resp)
+		}
+	})
+	t.Run("contents", func(t *testing.T) {
+		resp, err := caller.Call(ctx, "image", Req{})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		results, _ := resp["results"].([]any)
+		if len(results) != 2 || results[0] != "chart" {
+			t.Fatalf("resp = %v", resp)
+		}
+		image, _ := results[1].(map[string]any)
+		if image["type"] != "image" || image["mimeType"] != "image/png" || image["data"] != "aGVsbG8=" {
+			t.Errorf("image = %v", results[1])
+		}
+	})
+}
@@ -0,0 +1,452 @@
+package polaris
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/pkg/errors"
+)
+
+const (
+	mcpSessionIDHeader     = "Mcp-Session-Id"
+	mcpLastEventIDHeader   = "Last-Event-ID"
+	mcpStreamMaxResume     = 3
+	mcpListenMinWait       = time.Second
+	mcpListenMaxWait       = 30 * time.Second
+	mcpCloseSessionTimeout = 5 * time.Second
+)
+
+var (
+	// errMCPSessionExpired is returned when the server no longer knows the session,
+	// the client has to initialize a new session
+	errMCPSessionExpired = errors.New("mcp session expired")
+)
+
+type mcpHTTPStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *mcpHTTPStatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+type HTTPMCPOptionFunc func(*HTTPMCPOption)
+
+type HTTPMCPOption struct {
+	Headers        http.Header
+	HTTPClient     *http.Client
+	RequestTimeout time.Duration
+}
+
+// HTTPMCPHeader adds header to every request, e.g. auth header
+func HTTPMCPHeader(key, value string) HTTPMCPOptionFunc {
+	return func(o *HTTPMCPOption) {
+		o.Headers.Add(key, value)
+	}
+}
+
+func HTTPMCPBearerToken(token string) HTTPMCPOptionFunc {
+	return func(o *HTTPMCPOption) {
+		o.Headers.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func HTTPMCPHTTPClient(client *http.Client) HTTPMCPOptionFunc {
+	return func(o *HTTPMCPOption) {
+		o.HTTPClient = client
+	}
+}
+
+// HTTPMCPRequestTimeout bounds each JSON-RPC request to the server, defaults to RequestTimeout of the Conn
+func HTTPMCPRequestTimeout(timeout time.Duration) HTTPMCPOptionFunc {
+	return func(o *HTTPMCPOption) {
+		o.RequestTimeout = timeout
+	}
+}
+
+// newMCPHTTPClient bounds connecting and waiting for response header by timeout,
+// the body is not bounded because the GET stream of notifications is long-lived
+func newMCPHTTPClient(timeout time.Duration) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	tr.TLSHandshakeTimeout = timeout
+	tr.ResponseHeaderTimeout = timeout
+	return &http.Client{Transport: tr}
+}
+
+// RegisterHTTPMCPTools bridges tools of MCP server served by Streamable HTTP transport into the registry.
+// Interrupted response streams are resumed with Last-Event-ID,
+// and a new session is initialized when the server expires the session
+func (c *Conn) RegisterHTTPMCPTools(endpoint string, initReq mcp.InitializeRequest, options ...HTTPMCPOptionFunc) error {
+	opt := &HTTPMCPOption{
+		Headers:        http.Header{},
+		RequestTimeout: c.opt.ReqTimeout,
+	}
+	for _, f := range options {
+		f(opt)
+	}
+	if opt.HTTPClient == nil {
+		opt.HTTPClient = newMCPHTTPClient(opt.RequestTimeout)
+	}
+
+	connect := func() (*client.Client, *mcp.InitializeResult, error) {
+		mcpClient := client.NewClient(newStreamableHTTP(endpoint, opt, c.logger))
+		initResp, err := c.startMCPClient(mcpClient, initReq)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "endpoint %s", endpoint)
+		}
+		return mcpClient, initResp, nil
+	}
+
+	mcpClient, initResp, err := connect()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	session := newMCPSession(c.ctx, mcpClient, initResp)
+	session.reconnect = func() (*client.Client, error) {
+		mcpClient, _, err := connect()
+		return mcpClient, err
+	}
+	return c.bridgeMCPSession(session)
+}
+
+var (
+	_ transport.Interface = (*streamableHTTP)(nil)
+)
+
+// streamableHTTP implements Streamable HTTP transport of MCP,
+// the mcp-go client in use only supports stdio and the deprecated SSE transport,
+// and the streamable HTTP client of later mcp-go does not resume streams by Last-Event-ID
+type streamableHTTP struct {
+	endpoint       string
+	headers        http.Header
+	httpClient     *http.Client
+	reqTimeout     time.Duration
+	logger         Logger
+	mutex          *sync.RWMutex
+	sessionID      string
+	onNotification func(mcp.JSONRPCNotification)
+	ctx            context.Context
+	cancel         context.CancelFunc
+}
+
+func newStreamableHTTP(endpoint string, opt *HTTPMCPOption, logger Logger) *streamableHTTP {
+	return &streamableHTTP{
+		endpoint:   endpoint,
+		headers:    opt.Headers,
+		httpClient: opt.HTTPClient,
+		reqTimeout: opt.RequestTimeout,
+		logger:     logger,
+		mutex:      new(sync.RWMutex),
+	}
+}
+
+func (t *streamableHTTP) Start(ctx context.Context) error {
+	t.ctx, t.cancel = context.WithCancel(ctx)
+	return nil
+}
+
+func (t *streamableHTTP) SetNotificationHandler(handler func(mcp.JSONRPCNotification)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.onNotification = handler
+}
+
+func (t *streamableHTTP) notify(notification mcp.JSONRPCNotification) {
+	t.mutex.RLock()
+	handler := t.onNotification
+	t.mutex.RUnlock()
+
+	if handler != nil {
+		handler(notification)
+	}
+}
+
+func (t *streamableHTTP) currentSessionID() string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.sessionID
+}
+
+func (t *streamableHTTP) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for key, values := range t.headers {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+	if sessionID := t.currentSessionID(); sessionID != "" {
+		req.Header.Set(mcpSessionIDHeader, sessionID)
+	}
+	return req, nil
+}
+
+func (t *streamableHTTP) post(ctx context.Context, message any) (*http.Response, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req, err := t.newRequest(ctx, http.MethodPost, body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := t.checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, errors.WithStack(err)
+	}
+	if sessionID := resp.Header.Get(mcpSessionIDHeader); sessionID != "" {
+		t.mutex.Lock()
+		t.sessionID = sessionID
+		t.mutex.Unlock()
+	}
+	return resp, nil
+}
+
+func (t *streamableHTTP) checkStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound && t.currentSessionID() != "" {
+		return errors.WithStack(errMCPSessionExpired)
+	}
+	if resp.StatusCode < 200 || 299 < resp.StatusCode {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return errors.WithStack(&mcpHTTPStatusError{resp.StatusCode, strings.TrimSpace(string(body))})
+	}
+	return nil
+}
+
+func (t *streamableHTTP) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	if 0 < t.reqTimeout {
+		c, cancel := context.WithTimeout(ctx, t.reqTimeout)
+		defer cancel()
+		ctx = c
+	}
+	resp, err := t.post(ctx, request)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return t.readResponseStream(ctx, resp.Body, request.ID)
+	}
+
+	result := &transport.JSONRPCResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+// readResponseStream reads SSE until the response of id,
+// the stream is resumed by Last-Event-ID when it is disconnected before the response
+func (t *streamableHTTP) readResponseStream(ctx context.Context, body io.ReadCloser, id int64) (*transport.JSONRPCResponse, error) {
+	var result *transport.JSONRPCResponse
+	lastEventID := ""
+	handle := func(eventID string, data []byte) bool {
+		if eventID != "" {
+			lastEventID = eventID
+		}
+		msg := &transport.JSONRPCResponse{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			t.logger.Warnf("invalid mcp message: %+v", err)
+			return true
+		}
+		if msg.ID == nil {
+			t.dispatchNotification(data)
+			return true
+		}
+		if *msg.ID == id {
+			result = msg
+			return false
+		}
+		return true
+	}
+
+	err := readSSE(body, handle)
+	for i := 0; result == nil && lastEventID != "" && i < mcpStreamMaxResume; i += 1 {
+		if ctx.Err() != nil {
+			return nil, errors.WithStack(ctx.Err())
+		}
+		t.logger.Debugf("resume mcp stream %s from %s: %v", t.endpoint, lastEventID, err)
+
+		resumed, resumeErr := t.openStream(ctx, lastEventID)
+		if resumeErr != nil {
+			return nil, errors.Wrapf(resumeErr, "resume stream after %v", err)
+		}
+		err = readSSE(resumed.Body, handle)
+		resumed.Body.Close()
+	}
+	if result == nil {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, errors.Wrapf(err, "stream closed before response id=%d", id)
+	}
+	return result, nil
+}
+
+func (t *streamableHTTP) dispatchNotification(data []byte) {
+	notification := mcp.JSONRPCNotification{}
+	if err := json.Unmarshal(data, &notification); err != nil {
+		t.logger.Warnf("invalid mcp notification: %+v", err)
+		return
+	}
+	t.notify(notification)
+}
+
+// openStream opens GET stream, lastEventID resumes the stream after the event
+func (t *streamableHTTP) openStream(ctx context.Context, lastEventID string) (*http.Response, error) {
+	req, err := t.newRequest(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set(mcpLastEventIDHeader, lastEventID)
+	}
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := t.checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, errors.WithStack(err)
+	}
+	return resp, nil
+}
+
+func (t *streamableHTTP) SendNotification(ctx context.Context, notification mcp.JSONRPCNotification) error {
+	resp, err := t.post(ctx, notification)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp.Body.Close()
+
+	if notification.Method == "notifications/initialized" {
+		go t.listen()
+	}
+	return nil
+}
+
+// listen receives notifications from the server by GET stream,
+// the stream is optional for the server so 405 stops listening
+func (t *streamableHTTP) listen() {
+	wait := mcpListenMinWait
+	lastEventID := ""
+	for {
+		resp, err := t.openStream(t.ctx, lastEventID)
+		if err == nil {
+			wait = mcpListenMinWait
+			err = readSSE(resp.Body, func(eventID string, data []byte) bool {
+				if eventID != "" {
+					lastEventID = eventID
+				}
+				t.dispatchNotification(data)
+				return true
+			})
+			resp.Body.Close()
+		}
+		if t.ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errMCPSessionExpired) {
+			return
+		}
+		statusErr := &mcpHTTPStatusError{}
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusMethodNotAllowed {
+			return
+		}
+		t.logger.Debugf("mcp stream %s disconnected, reconnect after %s: %v", t.endpoint, wait, err)
+
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, mcpListenMaxWait)
+	}
+}
+
+// Close terminates the session on the server
+func (t *streamableHTTP) Close() error {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	if t.currentSessionID() == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mcpCloseSessionTimeout)
+	defer cancel()
+
+	req, err := t.newRequest(ctx, http.MethodDelete, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// readSSE calls fn for each event until fn returns false, returns nil on EOF
+func readSSE(r io.Reader, fn func(eventID string, data []byte) bool) error {
+	br := bufio.NewReader(r)
+	eventID := ""
+	data := bytes.NewBuffer(nil)
+	for {
+		line, err := br.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "" && err == nil:
+			if 0 < data.Len() {
+				if fn(eventID, data.Bytes()) != true {
+					return nil
+				}
+			}
+			eventID = ""
+			data.Reset()
+		case strings.HasPrefix(line, "id:"):
+			eventID = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+		case strings.HasPrefix(line, "data:"):
+			if 0 < data.Len() {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		if err != nil {
+			if err == io.EOF {
+				if 0 < data.Len() {
+					fn(eventID, data.Bytes())
+				}
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+	}
+}
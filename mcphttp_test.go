@@ -0,0 +1,229 @@
+package polaris
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// testStreamableHTTPServer is a minimal Streamable HTTP MCP server,
+// cutStream closes the stream of tools/call before the response to test resumption,
+// result overrides result of the method when it returns non-nil, mcp.JSONRPCError is replied as error
+type testStreamableHTTPServer struct {
+	mcp         *server.MCPServer
+	result      func(method string, params json.RawMessage) any
+	token       string
+	mutex       sync.Mutex
+	sessions    map[string]bool
+	pending     map[string][]byte
+	initialized int
+	resumed     int
+	deleted     int
+	cutStream   bool
+}
+
+func (s *testStreamableHTTPServer) expireSessions() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sessions = map[string]bool{}
+}
+
+func (s *testStreamableHTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+s.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessionID := r.Header.Get(mcpSessionIDHeader)
+	switch r.Method {
+	case http.MethodPost:
+		body, _ := io.ReadAll(r.Body)
+		msg := struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}{}
+		json.Unmarshal(body, &msg)
+
+		if msg.Method == "initialize" {
+			s.initialized += 1
+			sessionID = fmt.Sprintf("session-%d", s.initialized)
+			s.sessions[sessionID] = true
+			w.Header().Set(mcpSessionIDHeader, sessionID)
+		} else if s.sessions[sessionID] != true {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+
+		var resp any = s.mcp.HandleMessage(r.Context(), body)
+		if s.result != nil {
+			if result := s.result(msg.Method, msg.Params); result != nil {
+				resp = map[string]any{"jsonrpc": "2.0", "id": msg.ID, "result": result}
+				if rpcErr, ok := result.(mcp.JSONRPCError); ok {
+					rpcErr.JSONRPC, rpcErr.ID = mcp.JSONRPC_VERSION, msg.ID
+					resp = rpcErr
+				}
+			}
+		}
+		if resp == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		data, _ := json.Marshal(resp)
+		if msg.Method != "tools/call" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "id: %s-1\ndata: %s\n\n", sessionID, `{"jsonrpc":"2.0","method":"notifications/message","params":{"level":"info","data":"calling"}}`)
+		if s.cutStream {
+			s.cutStream = false
+			s.pending[sessionID+"-1"] = data
+			return
+		}
+		fmt.Fprintf(w, "id: %s-2\ndata: %s\n\n", sessionID, data)
+	case http.MethodGet:
+		data, ok := s.pending[r.Header.Get(mcpLastEventIDHeader)]
+		if ok != true {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.resumed += 1
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "id: %s-2\ndata: %s\n\n", sessionID, data)
+	case http.MethodDelete:
+		s.deleted += 1
+		delete(s.sessions, sessionID)
+	}
+}
+
+func TestRegisterHTTPMCPTools(t *testing.T) {
+	r, caller := testRegistry(t)
+
+	mcpServer := &testStreamableHTTPServer{
+		mcp:       newTestMCPServer(),
+		token:     "secret",
+		sessions:  map[string]bool{},
+		pending:   map[string][]byte{},
+		cutStream: true,
+	}
+	ts := httptest.NewServer(mcpServer)
+	t.Cleanup(ts.Close)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		conn, err := Connect(NatsURL(r.ns.ClientURL()))
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		defer conn.Close()
+
+		if err := conn.RegisterHTTPMCPTools(ts.URL, testMCPInitRequest(), HTTPMCPBearerToken("invalid")); err == nil {
+			t.Errorf("must be error")
+		}
+	})
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("http-mcp"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if err := conn.RegisterHTTPMCPTools(ts.URL, testMCPInitRequest(), HTTPMCPBearerToken("secret")); err != nil {
+		conn.Close()
+		t.Fatalf("no error: %+v", err)
+	}
+
+	echo := func(t *testing.T, msg string) {
+		resp, err := caller.Call(context.Background(), "test_echo", Req{"message": msg})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if results, _ := resp["results"].([]any); len(results) != 1 || results[0] != msg {
+			t.Errorf("resp = %v", resp)
+		}
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		echo(t, "resume")
+		if mcpServer.resumed != 1 {
+			t.Errorf("stream must be resumed: %d", mcpServer.resumed)
+		}
+	})
+	t.Run("expired", func(t *testing.T) {
+		mcpServer.expireSessions()
+		echo(t, "expired")
+		if mcpServer.initialized != 2 {
+			t.Errorf("session must be initialized again: %d", mcpServer.initialized)
+		}
+		echo(t, "reuse")
+		if mcpServer.initialized != 2 {
+			t.Errorf("session must be reused: %d", mcpServer.initialized)
+		}
+	})
+	t.Run("close", func(t *testing.T) {
+		conn.Close()
+		mcpServer.mutex.Lock()
+		defer mcpServer.mutex.Unlock()
+		if mcpServer.deleted < 1 {
+			t.Errorf("session must be deleted on Close")
+		}
+	})
+}
+
+func TestStreamableHTTPTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stream") != "" {
+			// header is sent, response is not
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(ts.Close)
+	t.Cleanup(func() { close(release) })
+
+	tests := []struct {
+		name     string
+		endpoint string
+		opt      *HTTPMCPOption
+	}{
+		{"response header", ts.URL, &HTTPMCPOption{Headers: http.Header{}, HTTPClient: newMCPHTTPClient(200 * time.Millisecond)}},
+		{"request", ts.URL, &HTTPMCPOption{Headers: http.Header{}, HTTPClient: &http.Client{}, RequestTimeout: 200 * time.Millisecond}},
+		{"response stream", ts.URL + "?stream=1", &HTTPMCPOption{Headers: http.Header{}, HTTPClient: newMCPHTTPClient(200 * time.Millisecond), RequestTimeout: 200 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, conn := testRegistry(t)
+			tr := newStreamableHTTP(tt.endpoint, tt.opt, conn.logger)
+			if err := tr.Start(context.Background()); err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+
+			start := time.Now()
+			if _, err := tr.SendRequest(context.Background(), transport.JSONRPCRequest{JSONRPC: mcp.JSONRPC_VERSION, ID: 1, Method: "tools/list"}); err == nil {
+				t.Fatalf("must be error")
+			}
+			if elapsed := time.Since(start); 5*time.Second < elapsed {
+				t.Errorf("must be timed out: %s", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package polaris
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/pkg/errors"
+	"google.golang.org/genai"
+)
+
+const (
+	mcpListResources       = "list_resources"
+	mcpReadResource        = "read_resource"
+	defaultMCPServerPrefix = "mcp"
+)
+
+// mcpServerPrefix returns name of the server usable as a prefix of tool name
+func mcpServerPrefix(server *mcp.InitializeResult) string {
+	if server == nil {
+		return defaultMCPServerPrefix
+	}
+	name := strings.Trim(invalidToolNameChars.ReplaceAllString(server.ServerInfo.Name, "_"), "_")
+	if name == "" {
+		return defaultMCPServerPrefix
+	}
+	return name
+}
+
+func hasMCPResources(server *mcp.InitializeResult) bool {
+	return server != nil && server.Capabilities.Resources != nil
+}
+
+func hasMCPPrompts(server *mcp.InitializeResult) bool {
+	return server != nil && server.Capabilities.Prompts != nil
+}
+
+// withClient calls fn with the current client, fn is retried once by new client when the session is expired.
+// the context of fn is done when either ctx of the caller or the session is done
+func (s *mcpSession) withClient(ctx context.Context, fn func(context.Context, *client.Client) error) error {
+	mcpClient, sessionCtx := s.current()
+	if mcpClient == nil {
+		return errors.New("mcp server is not available")
+	}
+	err := callWithContext(ctx, sessionCtx, mcpClient, fn)
+	if errors.Is(err, errMCPSessionExpired) {
+		if mcpClient, sessionCtx, err = s.reconnectClient(mcpClient); err == nil {
+			err = callWithContext(ctx, sessionCtx, mcpClient, fn)
+		}
+	}
+	return err
+}
+
+func callWithContext(ctx, sessionCtx context.Context, mcpClient *client.Client, fn func(context.Context, *client.Client) error) error {
+	merged, cancel := context.WithCancel(sessionCtx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	return fn(merged, mcpClient)
+}
+
+// mcpResourceTools returns <server>_list_resources and <server>_read_resource tools
+// that make resources of the server available to the model,
+// the tools are list_resources and read_resource in the namespace of the server if it has
+func mcpResourceTools(session *mcpSession) []Tool {
+	prefix := mcpServerPrefix(session.server)
+	listName, readName := prefix+"_"+mcpListResources, prefix+"_"+mcpReadResource
+	if session.namespace != "" {
+		listName, readName = mcpListResources, mcpReadResource
+	}
+	return []Tool{
+		{
+			Name:        listName,
+			Description: "List resources and resource templates provided by MCP server " + prefix + ", resources are read by " + readName,
+			Parameters:  Object{},
+			Response: Object{
+				Properties: Properties{
+					"resources": ObjectArray{
+						Description: "resources that can be read by uri",
+						Items: Properties{
+							"uri":         String{Required: true},
+							"name":        String{Required: true},
+							"description": String{},
+							"mimeType":    String{},
+						},
+						Required: true,
+					},
+					"templates": ObjectArray{
+						Description: "RFC 6570 URI templates of resources, expand uriTemplate to read",
+						Items: Properties{
+							"uriTemplate": String{Required: true},
+							"name":        String{Required: true},
+							"description": String{},
+							"mimeType":    String{},
+						},
+					},
+				},
+			},
+			Handler: handleMCPListResources(session),
+		},
+		{
+			Name:        readName,
+			Description: "Read a resource provided by MCP server " + prefix,
+			Parameters: Object{
+				Properties: Properties{
+					"uri": String{
+						Description: "uri of the resource",
+						Required:    true,
+					},
+				},
+			},
+			Response: Object{
+				Properties: Properties{
+					"contents": ObjectArray{
+						Description: "contents of the resource, binary content is base64 encoded blob",
+						Items: Properties{
+							"uri":      String{Required: true},
+							"mimeType": String{},
+							"text":     String{},
+							"blob":     String{},
+						},
+						Required: true,
+					},
+				},
+			},
+			Handler: handleMCPReadResource(session),
+		},
+	}
+}
+
+func handleMCPListResources(session *mcpSession) ToolHandler {
+	return func(r *ReqCtx) (Resp, error) {
+		resources := make([]any, 0)
+		templates := make([]any, 0)
+		err := session.withClient(r.Context(), func(ctx context.Context, mcpClient *client.Client) error {
+			res, err := mcpClient.ListResources(ctx, mcp.ListResourcesRequest{})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			for _, v := range res.Resources {
+				resources = append(resources, map[string]any{
+					"uri":         v.URI,
+					"name":        v.Name,
+					"description": v.Description,
+					"mimeType":    v.MIMEType,
+				})
+			}
+
+			tmpl, err := listMCPResourceTemplates(ctx, mcpClient)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			for _, v := range tmpl {
+				if v.URITemplate == nil || v.URITemplate.Template == nil {
+					continue
+				}
+				templates = append(templates, map[string]any{
+					"uriTemplate": v.URITemplate.Raw(),
+					"name":        v.Name,
+					"description": v.Description,
+					"mimeType":    v.MIMEType,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return Resp{
+			"resources": resources,
+			"templates": templates,
+		}, nil
+	}
+}
+
+// listMCPResourceTemplates requests resources/templates/list as raw JSON to know the error code,
+// the method is optional for servers, so method not found is an empty list
+func listMCPResourceTemplates(ctx context.Context, mcpClient *client.Client) ([]mcp.ResourceTemplate, error) {
+	resp, err := mcpClient.GetTransport().SendRequest(ctx, transport.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      rawRequestID.Add(-1),
+		Method:  string(mcp.MethodResourcesTemplatesList),
+		Params:  map[string]any{},
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if resp.Error != nil {
+		if resp.Error.Code == mcp.METHOD_NOT_FOUND {
+			return nil, nil
+		}
+		return nil, errors.New(resp.Error.Message)
+	}
+	result := mcp.ListResourceTemplatesResult{}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result.ResourceTemplates, nil
+}
+
+func handleMCPReadResource(session *mcpSession) ToolHandler {
+	return func(r *ReqCtx) (Resp, error) {
+		uri := r.String("uri")
+		contents := make([]any, 0)
+		err := session.withClient(r.Context(), func(ctx context.Context, mcpClient *client.Client) error {
+			req := mcp.ReadResourceRequest{}
+			req.Params.URI = uri
+			res, err := mcpClient.ReadResource(ctx, req)
+			if err != nil {
+				return errors.Wrapf(err, "read %s", uri)
+			}
+			for _, c := range res.Contents {
+				switch v := c.(type) {
+				case mcp.TextResourceContents:
+					contents = append(contents, map[string]any{
+						"uri":      v.URI,
+						"mimeType": v.MIMEType,
+						"text":     v.Text,
+					})
+				case mcp.BlobResourceContents:
+					contents = append(contents, map[string]any{
+						"uri":      v.URI,
+						"mimeType": v.MIMEType,
+						"blob":     v.Blob,
+					})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return Resp{"contents": contents}, nil
+	}
+}
+
+// MCPPrompt is a prompt template of MCP server bridged by RegisterXXXMCPTools
+type MCPPrompt struct {
+	Server      string
+	Name        string
+	Description string
+	Arguments   []MCPPromptArgument
+
+	session *mcpSession
+}
+
+type MCPPromptArgument struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// MCPPromptMessage is a text message rendered by the prompt template, Role is "user" or "assistant"
+type MCPPromptMessage struct {
+	Role string
+	Text string
+}
+
+// MCPPrompts lists prompt templates of bridged MCP servers,
+// Server is the prefix of tools of the server
+func (c *Conn) MCPPrompts(ctx context.Context) ([]MCPPrompt, error) {
+	c.mutex.RLock()
+	sessions := append([]*mcpSession{}, c.mcpSessions...)
+	c.mutex.RUnlock()
+
+	prompts := make([]MCPPrompt, 0)
+	for _, session := range sessions {
+		if hasMCPPrompts(session.server) != true {
+			continue
+		}
+		err := session.withClient(ctx, func(ctx context.Context, mcpClient *client.Client) error {
+			res, err := mcpClient.ListPrompts(ctx, mcp.ListPromptsRequest{})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			for _, p := range res.Prompts {
+				args := make([]MCPPromptArgument, len(p.Arguments))
+				for i, a := range p.Arguments {
+					args[i] = MCPPromptArgument{
+						Name:        a.Name,
+						Description: a.Description,
+						Required:    a.Required,
+					}
+				}
+				prompts = append(prompts, MCPPrompt{
+					Server:      mcpServerPrefix(session.server),
+					Name:        p.Name,
+					Description: p.Description,
+					Arguments:   args,
+					session:     session,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "list prompts of %s", mcpServerPrefix(session.server))
+		}
+	}
+	return prompts, nil
+}
+
+// MCPPrompt returns prompt template by server and name
+func (c *Conn) MCPPrompt(ctx context.Context, server, name string) (MCPPrompt, error) {
+	prompts, err := c.MCPPrompts(ctx)
+	if err != nil {
+		return MCPPrompt{}, errors.WithStack(err)
+	}
+	for _, p := range prompts {
+		if p.Server == server && p.Name == name {
+			return p, nil
+		}
+	}
+	return MCPPrompt{}, errors.Errorf("prompt %s not found in %s", name, server)
+}
+
+// Render gets messages of the prompt filled with args, non-text contents are skipped
+func (p MCPPrompt) Render(ctx context.Context, args map[string]string) ([]MCPPromptMessage, error) {
+	if p.session == nil {
+		return nil, errors.Errorf("prompt %s is not bridged", p.Name)
+	}
+	for _, a := range p.Arguments {
+		if _, ok := args[a.Name]; a.Required && ok != true {
+			return nil, errors.Errorf("prompt %s: argument %s required", p.Name, a.Name)
+		}
+	}
+
+	messages := make([]MCPPromptMessage, 0)
+	err := p.session.withClient(ctx, func(ctx context.Context, mcpClient *client.Client) error {
+		req := mcp.GetPromptRequest{}
+		req.Params.Name = p.Name
+		req.Params.Arguments = args
+		res, err := mcpClient.GetPrompt(ctx, req)
+		if err != nil {
+			return errors.Wrapf(err, "get prompt %s", p.Name)
+		}
+		for _, m := range res.Messages {
+			switch v := m.Content.(type) {
+			case mcp.TextContent:
+				messages = append(messages, MCPPromptMessage{string(m.Role), v.Text})
+			case mcp.EmbeddedResource:
+				if text, ok := v.Resource.(mcp.TextResourceContents); ok {
+					messages = append(messages, MCPPromptMessage{string(m.Role), text.Text})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return messages, nil
+}
+
+// Text renders the prompt and joins messages by blank line
+func (p MCPPrompt) Text(ctx context.Context, args map[string]string) (string, error) {
+	messages, err := p.Render(ctx, args)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	texts := make([]string, len(messages))
+	for i, m := range messages {
+		texts[i] = m.Text
+	}
+	return strings.Join(texts, "\n\n"), nil
+}
+
+// SystemInstruction renders the prompt as system instruction for UseSystemInstruction
+//
+//	prompt, err := conn.MCPPrompt(ctx, "docs", "code_review")
+//	sys, err := prompt.SystemInstruction(ctx, map[string]string{"lang": "go"})
+//	session, err := conn.Use(ctx, polaris.UseSystemInstruction(sys))
+func (p MCPPrompt) SystemInstruction(ctx context.Context, args map[string]string) (SystemInstructionOptfion, error) {
+	messages, err := p.Render(ctx, args)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return func() []*genai.Part {
+		parts := make([]*genai.Part, len(messages))
+		for i, m := range messages {
+			parts[i] = genai.NewPartFromText(m.Text)
+		}
+		return parts
+	}, nil
+}
@@ -0,0 +1,309 @@
+package polaris
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/pkg/errors"
+)
+
+func newTestMCPResourceServer() *server.MCPServer {
+	s := newTestMCPServer()
+	s.AddResource(
+		mcp.NewResource("file:///readme.md", "readme",
+			mcp.WithResourceDescription("readme of the project"),
+			mcp.WithMIMEType("text/markdown"),
+		),
+		func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			return []mcp.ResourceContents{
+				mcp.TextResourceContents{URI: req.Params.URI, MIMEType: "text/markdown", Text: "# polaris"},
+			}, nil
+		},
+	)
+	s.AddResource(
+		mcp.NewResource("file:///logo.png", "logo", mcp.WithMIMEType("image/png")),
+		func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			return []mcp.ResourceContents{
+				mcp.BlobResourceContents{URI: req.Params.URI, MIMEType: "image/png", Blob: base64.StdEncoding.EncodeToString([]byte("png"))},
+			}, nil
+		},
+	)
+	s.AddResourceTemplate(
+		mcp.NewResourceTemplate("file:///docs/{name}", "docs"),
+		func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			return []mcp.ResourceContents{
+				mcp.TextResourceContents{URI: req.Params.URI, MIMEType: "text/plain", Text: "docs of " + req.Params.URI},
+			}, nil
+		},
+	)
+	s.AddPrompt(
+		mcp.NewPrompt("code_review",
+			mcp.WithPromptDescription("review code"),
+			mcp.WithArgument("lang", mcp.ArgumentDescription("language"), mcp.RequiredArgument()),
+		),
+		func(ctx context.Context, req mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+			return mcp.NewGetPromptResult("review", []mcp.PromptMessage{
+				mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent("You are a reviewer of "+req.Params.Arguments["lang"])),
+				mcp.NewPromptMessage(mcp.RoleAssistant, mcp.NewTextContent("OK")),
+			}), nil
+		},
+	)
+	return s
+}
+
+func TestMCPResources(t *testing.T) {
+	r, caller := testRegistry(t)
+
+	ts := server.NewTestServer(newTestMCPResourceServer())
+	t.Cleanup(ts.Close)
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("resource-mcp"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+	if err := conn.RegisterSSEMCPTools(ts.URL+"/sse", testMCPInitRequest()); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	waitRegistryTools(t, caller, func(names []string) bool {
+		return slices.Contains(names, "polaris_test_list_resources") && slices.Contains(names, "polaris_test_read_resource")
+	})
+
+	t.Run("list", func(tt *testing.T) {
+		resp, err := caller.Call(context.Background(), "polaris_test_list_resources", Req{})
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		resources, _ := resp["resources"].([]any)
+		if len(resources) != 2 {
+			tt.Fatalf("resources = %v", resp)
+		}
+		uris := make([]string, len(resources))
+		for i, v := range resources {
+			uris[i], _ = v.(map[string]any)["uri"].(string)
+		}
+		slices.Sort(uris)
+		if slices.Equal(uris, []string{"file:///logo.png", "file:///readme.md"}) != true {
+			tt.Errorf("uris = %v", uris)
+		}
+		templates, _ := resp["templates"].([]any)
+		if len(templates) != 1 || templates[0].(map[string]any)["uriTemplate"] != "file:///docs/{name}" {
+			tt.Errorf("templates = %v", resp["templates"])
+		}
+	})
+	t.Run("read", func(tt *testing.T) {
+		tests := []struct {
+			name string
+			uri  string
+			key  string
+			want string
+		}{
+			{"text", "file:///readme.md", "text", "# polaris"},
+			{"blob", "file:///logo.png", "blob", base64.StdEncoding.EncodeToString([]byte("png"))},
+			{"template", "file:///docs/intro", "text", "docs of file:///docs/intro"},
+		}
+		for _, test := range tests {
+			tt.Run(test.name, func(ttt *testing.T) {
+				resp, err := caller.Call(context.Background(), "polaris_test_read_resource", Req{"uri": test.uri})
+				if err != nil {
+					ttt.Fatalf("no error: %+v", err)
+				}
+				contents, _ := resp["contents"].([]any)
+				if len(contents) != 1 {
+					ttt.Fatalf("contents = %v", resp)
+				}
+				if v := contents[0].(map[string]any)[test.key]; v != test.want {
+					ttt.Errorf("%s = %v", test.key, v)
+				}
+			})
+		}
+	})
+	t.Run("read/unknown", func(tt *testing.T) {
+		resp, err := caller.Call(context.Background(), "polaris_test_read_resource", Req{"uri": "file:///unknown"})
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		if _, ok := resp["_error"]; ok != true {
+			tt.Errorf("unknown resource must be _error: %v", resp)
+		}
+	})
+	t.Run("prompt", func(tt *testing.T) {
+		prompts, err := conn.MCPPrompts(context.Background())
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		if len(prompts) != 1 {
+			tt.Fatalf("prompts = %v", prompts)
+		}
+		p := prompts[0]
+		if p.Server != "polaris_test" || p.Name != "code_review" || len(p.Arguments) != 1 || p.Arguments[0].Required != true {
+			tt.Errorf("prompt = %+v", p)
+		}
+
+		if _, err := p.Render(context.Background(), map[string]string{}); err == nil {
+			tt.Errorf("required argument must be error")
+		}
+		messages, err := p.Render(context.Background(), map[string]string{"lang": "go"})
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		if len(messages) != 2 || messages[0].Role != "user" || messages[0].Text != "You are a reviewer of go" {
+			tt.Errorf("messages = %v", messages)
+		}
+
+		p, err = conn.MCPPrompt(context.Background(), "polaris_test", "code_review")
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		sys, err := p.SystemInstruction(context.Background(), map[string]string{"lang": "go"})
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		opt := &UseOption{}
+		UseSystemInstruction(sys)(opt)
+		if len(opt.SystemInstructions) != 2 || opt.SystemInstructions[0].Text != "You are a reviewer of go" {
+			tt.Errorf("system instructions = %v", opt.SystemInstructions)
+		}
+
+		if _, err := conn.MCPPrompt(context.Background(), "polaris_test", "unknown"); err == nil {
+			tt.Errorf("unknown prompt must be error")
+		}
+	})
+}
+
+func TestMCPResourcesSession(t *testing.T) {
+	r, caller := testRegistry(t)
+
+	expireOnList := false
+	templatesNotFound := false
+	mcpServer := &testStreamableHTTPServer{
+		mcp:      newTestMCPResourceServer(),
+		token:    "secret",
+		sessions: map[string]bool{},
+		pending:  map[string][]byte{},
+	}
+	mcpServer.result = func(method string, params json.RawMessage) any {
+		switch method {
+		case "resources/list":
+			if expireOnList {
+				// resources/templates/list of the same call finds the session expired
+				expireOnList = false
+				mcpServer.sessions = map[string]bool{}
+			}
+		case "resources/templates/list":
+			if templatesNotFound {
+				rpcErr := mcp.JSONRPCError{}
+				rpcErr.Error.Code = mcp.METHOD_NOT_FOUND
+				rpcErr.Error.Message = "method not found"
+				return rpcErr
+			}
+		}
+		return nil
+	}
+	ts := httptest.NewServer(mcpServer)
+	t.Cleanup(ts.Close)
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("resource-mcp"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+	if err := conn.RegisterHTTPMCPTools(ts.URL, testMCPInitRequest(), HTTPMCPBearerToken("secret")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	waitRegistryTools(t, caller, func(names []string) bool {
+		return slices.Contains(names, "polaris_test_list_resources")
+	})
+	listResources := func(t *testing.T) map[string]any {
+		resp, err := caller.Call(context.Background(), "polaris_test_list_resources", Req{})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if _, ok := resp["_error"]; ok {
+			t.Fatalf("resp = %v", resp)
+		}
+		return resp
+	}
+
+	t.Run("expired while listing templates", func(tt *testing.T) {
+		mcpServer.mutex.Lock()
+		expireOnList = true
+		initialized := mcpServer.initialized
+		mcpServer.mutex.Unlock()
+
+		resp := listResources(tt)
+		if templates, _ := resp["templates"].([]any); len(templates) != 1 {
+			tt.Errorf("templates must be listed by new session: %v", resp)
+		}
+		mcpServer.mutex.Lock()
+		defer mcpServer.mutex.Unlock()
+		if mcpServer.initialized != initialized+1 {
+			tt.Errorf("session must be reconnected: %d", mcpServer.initialized)
+		}
+	})
+	t.Run("templates not supported", func(tt *testing.T) {
+		mcpServer.mutex.Lock()
+		templatesNotFound = true
+		mcpServer.mutex.Unlock()
+		defer func() {
+			mcpServer.mutex.Lock()
+			templatesNotFound = false
+			mcpServer.mutex.Unlock()
+		}()
+
+		resp := listResources(tt)
+		if resources, _ := resp["resources"].([]any); len(resources) != 2 {
+			tt.Errorf("resources = %v", resp)
+		}
+		if templates, _ := resp["templates"].([]any); len(templates) != 0 {
+			tt.Errorf("templates = %v", resp)
+		}
+	})
+	t.Run("caller cancel", func(tt *testing.T) {
+		conn.mutex.RLock()
+		session := conn.mcpSessions[0]
+		conn.mutex.RUnlock()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := handleMCPReadResource(session)(&ReqCtx{ctx, jsonMap{"uri": "file:///readme.md"}, Object{}})
+		if errors.Is(err, context.Canceled) != true {
+			tt.Errorf("read must be canceled by the caller: %+v", err)
+		}
+	})
+}
+
+func TestMCPResourcesNotSupported(t *testing.T) {
+	r, caller := testRegistry(t)
+
+	ts := server.NewTestServer(newTestMCPServer())
+	t.Cleanup(ts.Close)
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("tool-mcp"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+	if err := conn.RegisterSSEMCPTools(ts.URL+"/sse", testMCPInitRequest()); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	waitRegistryTools(t, caller, func(names []string) bool {
+		return slices.Contains(names, "test_echo")
+	})
+	if _, ok := conn.Tool("polaris_test_list_resources"); ok {
+		t.Errorf("server without resources capability must not have resource tools")
+	}
+	prompts, err := conn.MCPPrompts(context.Background())
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if len(prompts) != 0 {
+		t.Errorf("prompts = %v", prompts)
+	}
+}
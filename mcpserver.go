@@ -0,0 +1,567 @@
+package polaris
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMCPServerName         = "polaris"
+	defaultMCPServerVersion      = "1.0.0"
+	defaultMCPSessionIdleTimeout = 30 * time.Minute
+	mcpMaxRequestBodySize        = 4 * 1024 * 1024
+	mcpNotificationBuffer        = 16
+	mcpDelegatedTokenTTL         = time.Minute
+)
+
+var (
+	ErrMCPUnauthenticated = errors.New("mcp client is not authenticated")
+)
+
+type MCPServerOptionFunc func(*MCPServerOption)
+
+type MCPServerOption struct {
+	Name               string
+	Version            string
+	Authenticator      MCPAuthenticator
+	IdentityIssuer     nkeys.KeyPair
+	StdioIdentity      *Identity
+	SessionIdleTimeout time.Duration
+	Approver           Approver
+}
+
+// MCPAuthenticator returns identity of the MCP client of the HTTP request,
+// returned error rejects the request as 401
+type MCPAuthenticator func(r *http.Request) (Identity, error)
+
+// NewMCPBearerAuthenticator authenticates "Authorization: Bearer <token>" by tokens to identities of the clients
+func NewMCPBearerAuthenticator(tokens map[string]Identity) MCPAuthenticator {
+	return func(r *http.Request) (Identity, error) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok != true || presented == "" {
+			return Identity{}, errors.WithStack(ErrMCPUnauthenticated)
+		}
+		for token, id := range tokens {
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+				return id, nil
+			}
+		}
+		return Identity{}, errors.WithStack(ErrMCPUnauthenticated)
+	}
+}
+
+// MCPServerInfo sets serverInfo replied to initialize request
+func MCPServerInfo(name, version string) MCPServerOptionFunc {
+	return func(o *MCPServerOption) {
+		o.Name = name
+		o.Version = version
+	}
+}
+
+// MCPServerAuthenticator authenticates every HTTP request, ServeHTTP rejects all requests without it
+func MCPServerAuthenticator(authenticator MCPAuthenticator) MCPServerOptionFunc {
+	return func(o *MCPServerOption) {
+		o.Authenticator = authenticator
+	}
+}
+
+// MCPServerIdentityIssuer delegates identity of the MCP client to tool calls,
+// each call presents a short-lived token signed by issuer, agents trust it by NewTokenAuthorizer(<public key of issuer>).
+// Without issuer the calls are anonymous, identity of the Conn is never used for MCP clients
+func MCPServerIdentityIssuer(issuer nkeys.KeyPair) MCPServerOptionFunc {
+	return func(o *MCPServerOption) {
+		o.IdentityIssuer = issuer
+	}
+}
+
+// MCPServerStdioIdentity is identity of the MCP client of ServeStdio, delegated by MCPServerIdentityIssuer
+func MCPServerStdioIdentity(id Identity) MCPServerOptionFunc {
+	return func(o *MCPServerOption) {
+		o.StdioIdentity = &id
+	}
+}
+
+// MCPServerSessionIdleTimeout expires HTTP sessions that have no request and no stream for timeout
+func MCPServerSessionIdleTimeout(timeout time.Duration) MCPServerOptionFunc {
+	return func(o *MCPServerOption) {
+		o.SessionIdleTimeout = timeout
+	}
+}
+
+// MCPServerApprover asks approver before calling the tool that RequiresApproval, the call is denied without approver
+func MCPServerApprover(approver Approver) MCPServerOptionFunc {
+	return func(o *MCPServerOption) {
+		o.Approver = approver
+	}
+}
+
+// mcpIdentityKey is context key of identity of the MCP client
+type mcpIdentityKey struct{}
+
+// MCPServer exposes tools of the registry to MCP clients by stdio or Streamable HTTP,
+// tools/call is forwarded to the tool over NATS
+type MCPServer struct {
+	conn          *Conn
+	opt           *MCPServerOption
+	server        *server.MCPServer
+	mutex         *sync.Mutex
+	digest        string
+	approvals     map[string]string
+	approvalMutex *sync.Mutex
+	sessions      *sync.Map
+}
+
+// NewMCPServer creates MCPServer that follows changes of the registry
+// and notifies notifications/tools/list_changed to the clients
+func (c *Conn) NewMCPServer(options ...MCPServerOptionFunc) (*MCPServer, error) {
+	opt := &MCPServerOption{
+		Name:               defaultMCPServerName,
+		Version:            defaultMCPServerVersion,
+		SessionIdleTimeout: defaultMCPSessionIdleTimeout,
+	}
+	for _, f := range options {
+		f(opt)
+	}
+
+	s := &MCPServer{
+		conn:          c,
+		opt:           opt,
+		server:        server.NewMCPServer(opt.Name, opt.Version, server.WithToolCapabilities(true)),
+		mutex:         new(sync.Mutex),
+		approvals:     make(map[string]string),
+		approvalMutex: new(sync.Mutex),
+		sessions:      new(sync.Map),
+	}
+	if err := s.syncTools(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sub, err := c.nc.Subscribe(TopicToolChanged, func(*nats.Msg) {
+		if err := s.syncTools(); err != nil {
+			c.logger.Warnf("sync mcp server tools: %+v", err)
+		}
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c.nc.Flush()
+	c.addSub(sub)
+
+	go s.expireSessionsLoop(c.ctx)
+	return s, nil
+}
+
+// syncTools replaces tools by the registry, list_changed is notified only when the list is changed
+func (s *MCPServer) syncTools() error {
+	list, err := request(
+		s.conn,
+		TopicListTool,
+		JSONEncoder[[]WrapFunctionDeclaration](),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	slices.SortFunc(list, func(a, b WrapFunctionDeclaration) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	digest, err := json.Marshal(list)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.digest == string(digest) {
+		return nil
+	}
+	tools := make([]server.ServerTool, 0, len(list))
+	approvals := make(map[string]string)
+	for _, d := range list {
+		if d.RequiresApproval {
+			approvals[d.Name] = cmp.Or(d.ApprovalReason, d.Description)
+		}
+		t, err := mcpToolFromDeclaration(d)
+		if err != nil {
+			s.conn.logger.Warnf("skip tool %s: %+v", d.Name, err)
+			continue
+		}
+		tools = append(tools, server.ServerTool{
+			Tool:    t,
+			Handler: s.handleCallTool,
+		})
+	}
+	s.server.SetTools(tools...)
+	s.approvals = approvals
+	s.digest = string(digest)
+	return nil
+}
+
+// mcpToolFromDeclaration converts parameters to inputSchema as JSON Schema, $defs are kept
+func mcpToolFromDeclaration(d WrapFunctionDeclaration) (mcp.Tool, error) {
+	inputSchema := wrapToJSONSchema(d.Parameters)
+	inputSchema["type"] = "object"
+	delete(inputSchema, "nullable")
+
+	data, err := json.Marshal(inputSchema)
+	if err != nil {
+		return mcp.Tool{}, errors.WithStack(err)
+	}
+	return mcp.NewToolWithRawSchema(d.Name, d.Description, data), nil
+}
+
+func (s *MCPServer) handleCallTool(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := req.Params.Arguments
+	if args == nil {
+		args = map[string]any{}
+	}
+
+	if denied, ok := s.approve(ctx, req.Params.Name, args); ok != true {
+		return mcp.NewToolResultError(fmt.Sprintf("%v", denied["_error"])), nil
+	}
+	identity, err := s.delegatedIdentity(ctx)
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("call "+req.Params.Name, err), nil
+	}
+	rc := &defaultRemoteCall{s.conn, s.conn.logger, nil, "", "", identity}
+	resp, err := rc.callFunction(req.Params.Name, args)
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("call "+req.Params.Name, err), nil
+	}
+	if errValue, ok := resp["_error"]; ok {
+		return mcp.NewToolResultError(fmt.Sprintf("%v", errValue)), nil
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return mcp.NewToolResultText(string(data)), nil
+}
+
+// approve asks MCPServerApprover when the tool requires approval
+func (s *MCPServer) approve(ctx context.Context, name string, args map[string]any) (map[string]any, bool) {
+	s.mutex.Lock()
+	reason, ok := s.approvals[name]
+	s.mutex.Unlock()
+	if ok != true {
+		return nil, true
+	}
+	return approveCall(ctx, s.opt.Approver, s.approvalMutex, s.conn.logger, ApprovalRequest{name, args, reason})
+}
+
+// delegatedIdentity is identity of tool calls by the MCP client of ctx, anonymous without MCPServerIdentityIssuer
+func (s *MCPServer) delegatedIdentity(ctx context.Context) (callerIdentity, error) {
+	id, ok := ctx.Value(mcpIdentityKey{}).(Identity)
+	if ok != true || id.Subject == "" || s.opt.IdentityIssuer == nil {
+		return callerIdentity{}, nil
+	}
+	key, err := nkeys.CreateUser()
+	if err != nil {
+		return callerIdentity{}, errors.WithStack(err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		return callerIdentity{}, errors.WithStack(err)
+	}
+	id.Key = pub
+	token, err := SignIdentityToken(id, s.opt.IdentityIssuer, mcpDelegatedTokenTTL)
+	if err != nil {
+		return callerIdentity{}, errors.WithStack(err)
+	}
+	return callerIdentity{token, key}, nil
+}
+
+// ServeStdio serves MCP over stdin/stdout(newline delimited JSON-RPC) until ctx is done or stdin is closed,
+// the client is MCPServerStdioIdentity
+func (s *MCPServer) ServeStdio(ctx context.Context, stdin io.Reader, stdout io.Writer) error {
+	if s.opt.StdioIdentity != nil {
+		ctx = context.WithValue(ctx, mcpIdentityKey{}, *s.opt.StdioIdentity)
+	}
+	if err := server.NewStdioServer(s.server).Listen(ctx, stdin, stdout); err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, io.EOF) {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+var (
+	_ http.Handler         = (*MCPServer)(nil)
+	_ server.ClientSession = (*mcpHTTPSession)(nil)
+)
+
+// ServeHTTP serves Streamable HTTP transport of MCP,
+// POST receives JSON-RPC messages, GET streams notifications and DELETE terminates the session.
+// Every request is authenticated by MCPServerAuthenticator, and a session is used only by the client that initialized it
+func (s *MCPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.opt.Authenticator == nil {
+		http.Error(w, "mcp server has no authenticator", http.StatusUnauthorized)
+		return
+	}
+	id, err := s.opt.Authenticator(r)
+	if err != nil {
+		s.conn.logger.Warnf("mcp client %s: %+v", r.RemoteAddr, err)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), mcpIdentityKey{}, id))
+
+	switch r.Method {
+	case http.MethodPost:
+		s.handleHTTPMessage(w, r)
+	case http.MethodGet:
+		s.handleHTTPStream(w, r)
+	case http.MethodDelete:
+		s.handleHTTPDelete(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *MCPServer) httpSession(w http.ResponseWriter, r *http.Request) (*mcpHTTPSession, bool) {
+	sessionID := r.Header.Get(mcpSessionIDHeader)
+	if sessionID == "" {
+		http.Error(w, mcpSessionIDHeader+" required", http.StatusBadRequest)
+		return nil, false
+	}
+	v, ok := s.sessions.Load(sessionID)
+	if ok != true {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return nil, false
+	}
+	session := v.(*mcpHTTPSession)
+	id, _ := r.Context().Value(mcpIdentityKey{}).(Identity)
+	if id.Subject != session.subject {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return nil, false
+	}
+	session.touch()
+	return session, true
+}
+
+func (s *MCPServer) handleHTTPMessage(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, mcpMaxRequestBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg := struct {
+		Method string `json:"method"`
+	}{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, "invalid JSON-RPC message", http.StatusBadRequest)
+		return
+	}
+
+	var session *mcpHTTPSession
+	if msg.Method == string(mcp.MethodInitialize) {
+		id, _ := r.Context().Value(mcpIdentityKey{}).(Identity)
+		session, err = newMCPHTTPSession(id.Subject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := s.server.RegisterSession(r.Context(), session); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.sessions.Store(session.id, session)
+		w.Header().Set(mcpSessionIDHeader, session.id)
+	} else {
+		found, ok := s.httpSession(w, r)
+		if ok != true {
+			return
+		}
+		session = found
+	}
+
+	resp := s.server.HandleMessage(s.server.WithContext(r.Context(), session), body)
+	if resp == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.conn.logger.Warnf("write mcp response: %+v", errors.WithStack(err))
+	}
+}
+
+func (s *MCPServer) handleHTTPStream(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.httpSession(w, r)
+	if ok != true {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if ok != true {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	session.streams.Add(1)
+	defer func() {
+		session.streams.Add(-1)
+		session.touch()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.conn.ctx.Done():
+			return
+		case <-session.done:
+			return
+		case notification := <-session.notifications:
+			data, err := json.Marshal(notification)
+			if err != nil {
+				s.conn.logger.Warnf("marshal notification: %+v", errors.WithStack(err))
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", session.nextEventID(), data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *MCPServer) handleHTTPDelete(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.httpSession(w, r)
+	if ok != true {
+		return
+	}
+	s.deleteSession(session)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *MCPServer) deleteSession(session *mcpHTTPSession) {
+	s.sessions.Delete(session.id)
+	s.server.UnregisterSession(session.id)
+	session.close()
+}
+
+// expireSessionsLoop deletes sessions idle for SessionIdleTimeout, clients that do not send DELETE are expired
+func (s *MCPServer) expireSessionsLoop(ctx context.Context) {
+	if s.opt.SessionIdleTimeout <= 0 {
+		return
+	}
+	tick := time.NewTicker(max(s.opt.SessionIdleTimeout/2, 10*time.Millisecond))
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-tick.C:
+			s.expireSessions(now)
+		}
+	}
+}
+
+func (s *MCPServer) expireSessions(now time.Time) {
+	s.sessions.Range(func(_, v any) bool {
+		session := v.(*mcpHTTPSession)
+		if session.idle(now, s.opt.SessionIdleTimeout) {
+			s.deleteSession(session)
+		}
+		return true
+	})
+}
+
+type mcpHTTPSession struct {
+	id            string
+	subject       string
+	notifications chan mcp.JSONRPCNotification
+	initialized   *atomic.Bool
+	eventID       *atomic.Int64
+	lastSeen      *atomic.Int64
+	streams       *atomic.Int32
+	done          chan struct{}
+	closeOnce     *sync.Once
+}
+
+// newMCPHTTPSession creates session of the client identified by subject
+func newMCPHTTPSession(subject string) (*mcpHTTPSession, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	session := &mcpHTTPSession{
+		id:            hex.EncodeToString(buf),
+		subject:       subject,
+		notifications: make(chan mcp.JSONRPCNotification, mcpNotificationBuffer),
+		initialized:   new(atomic.Bool),
+		eventID:       new(atomic.Int64),
+		lastSeen:      new(atomic.Int64),
+		streams:       new(atomic.Int32),
+		done:          make(chan struct{}),
+		closeOnce:     new(sync.Once),
+	}
+	session.touch()
+	return session, nil
+}
+
+func (s *mcpHTTPSession) touch() {
+	s.lastSeen.Store(time.Now().UnixNano())
+}
+
+// idle is true when the session has no stream and no request for timeout
+func (s *mcpHTTPSession) idle(now time.Time, timeout time.Duration) bool {
+	if 0 < s.streams.Load() {
+		return false
+	}
+	return timeout < now.Sub(time.Unix(0, s.lastSeen.Load()))
+}
+
+func (s *mcpHTTPSession) SessionID() string {
+	return s.id
+}
+
+func (s *mcpHTTPSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func (s *mcpHTTPSession) Initialize() {
+	s.initialized.Store(true)
+}
+
+func (s *mcpHTTPSession) Initialized() bool {
+	return s.initialized.Load()
+}
+
+func (s *mcpHTTPSession) nextEventID() int64 {
+	return s.eventID.Add(1)
+}
+
+func (s *mcpHTTPSession) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
@@ -0,0 +1,368 @@
+package polaris
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/nats-io/nkeys"
+)
+
+const testMCPInitBody = `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","clientInfo":{"name":"test","version":"1"},"capabilities":{}}}`
+
+func testGreetTool(name string) Tool {
+	return Tool{
+		Name:        name,
+		Description: "greet to name",
+		Parameters: Object{
+			Properties: Properties{
+				"name": String{Description: "name", Required: true},
+			},
+		},
+		Response: Object{
+			Properties: Properties{
+				"message": String{Required: true},
+			},
+		},
+		Handler: func(r *ReqCtx) (Resp, error) {
+			return Resp{"message": "hello " + r.String("name")}, nil
+		},
+	}
+}
+
+func testMCPBearerHeader(token string) http.Header {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	return header
+}
+
+func TestMCPServerHTTP(t *testing.T) {
+	r, toolConn := testRegistry(t)
+	if err := toolConn.RegisterTool(testGreetTool("greet")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	s, err := conn.NewMCPServer(
+		MCPServerInfo("polaris-test", "0.0.1"),
+		MCPServerAuthenticator(NewMCPBearerAuthenticator(map[string]Identity{
+			"alice-token": {Subject: "alice"},
+			"bob-token":   {Subject: "bob"},
+		})),
+	)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	mcpClient := client.NewClient(newStreamableHTTP(ts.URL, &HTTPMCPOption{Headers: testMCPBearerHeader("alice-token"), HTTPClient: http.DefaultClient}, conn.logger))
+	changed := make(chan struct{}, 1)
+	mcpClient.OnNotification(func(n mcp.JSONRPCNotification) {
+		if n.Method == "notifications/tools/list_changed" {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if _, err := conn.startMCPClient(mcpClient, testMCPInitRequest()); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(func() { mcpClient.Close() })
+
+	ctx := context.Background()
+	t.Run("list", func(t *testing.T) {
+		r, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if len(r.Tools) != 1 || r.Tools[0].Name != "greet" {
+			t.Fatalf("tools = %v", r.Tools)
+		}
+		if r.Tools[0].InputSchema.Type != "object" {
+			t.Errorf("type = %s", r.Tools[0].InputSchema.Type)
+		}
+		if _, ok := r.Tools[0].InputSchema.Properties["name"]; ok != true {
+			t.Errorf("properties = %v", r.Tools[0].InputSchema.Properties)
+		}
+		if r.Tools[0].InputSchema.Required[0] != "name" {
+			t.Errorf("required = %v", r.Tools[0].InputSchema.Required)
+		}
+	})
+	t.Run("call", func(t *testing.T) {
+		req := mcp.CallToolRequest{}
+		req.Params.Name = "greet"
+		req.Params.Arguments = map[string]any{"name": "polaris"}
+		res, err := mcpClient.CallTool(ctx, req)
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if res.IsError {
+			t.Fatalf("must not be error: %v", res.Content)
+		}
+		resp := map[string]any{}
+		if err := json.Unmarshal([]byte(res.Content[0].(mcp.TextContent).Text), &resp); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if resp["message"] != "hello polaris" {
+			t.Errorf("resp = %v", resp)
+		}
+	})
+	t.Run("list_changed", func(t *testing.T) {
+		if err := toolConn.RegisterTool(testGreetTool("greet2")); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("list_changed must be notified")
+		}
+		r, err := mcpClient.ListTools(ctx, mcp.ListToolsRequest{})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if len(r.Tools) != 2 {
+			t.Errorf("tools = %v", r.Tools)
+		}
+	})
+	t.Run("unknown session", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+		req.Header = testMCPBearerHeader("alice-token")
+		req.Header.Set(mcpSessionIDHeader, "unknown")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("status = %d", resp.StatusCode)
+		}
+	})
+	t.Run("authentication", func(t *testing.T) {
+		sessionID := ""
+		s.sessions.Range(func(k, _ any) bool {
+			sessionID = k.(string)
+			return false
+		})
+		tests := []struct {
+			name   string
+			token  string
+			status int
+		}{
+			{"no token", "", http.StatusUnauthorized},
+			{"unknown token", "mallory-token", http.StatusUnauthorized},
+			{"session of other client", "bob-token", http.StatusNotFound},
+			{"owner", "alice-token", http.StatusOK},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+				if tt.token != "" {
+					req.Header = testMCPBearerHeader(tt.token)
+				}
+				req.Header.Set(mcpSessionIDHeader, sessionID)
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("no error: %+v", err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != tt.status {
+					t.Errorf("status = %d", resp.StatusCode)
+				}
+			})
+		}
+	})
+}
+
+func TestMCPServerHTTPNoAuthenticator(t *testing.T) {
+	_, conn := testRegistry(t)
+	s, err := conn.NewMCPServer()
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(testMCPInitBody))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d", resp.StatusCode)
+	}
+}
+
+func TestMCPServerDelegatedIdentity(t *testing.T) {
+	r, _ := testRegistry(t)
+	issuer, issuerPub := testNKey(t, nkeys.CreateAccount)
+
+	agent, err := Connect(NatsURL(r.ns.ClientURL()), Name("agent"), ConnectAuthorizer(NewTokenAuthorizer(issuerPub)))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(agent.Close)
+	adminTool := testOwnerTool("admin_op", "agent")
+	adminTool.Roles = []string{"admin"}
+	if err := agent.RegisterTool(adminTool); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	// identity of the server Conn is not used for MCP clients
+	serverToken, serverSeed := testIdentity(t, issuer, Identity{Subject: "server", Roles: []string{"admin"}})
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("mcp-server"), ConnectIdentity(serverToken, serverSeed))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	tests := []struct {
+		name    string
+		options []MCPServerOptionFunc
+		token   string
+		allowed bool
+	}{
+		{"admin", []MCPServerOptionFunc{MCPServerIdentityIssuer(issuer)}, "alice-token", true},
+		{"dev", []MCPServerOptionFunc{MCPServerIdentityIssuer(issuer)}, "bob-token", false},
+		{"no issuer", nil, "alice-token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := append([]MCPServerOptionFunc{
+				MCPServerAuthenticator(NewMCPBearerAuthenticator(map[string]Identity{
+					"alice-token": {Subject: "alice", Roles: []string{"admin"}},
+					"bob-token":   {Subject: "bob", Roles: []string{"dev"}},
+				})),
+			}, tt.options...)
+			s, err := conn.NewMCPServer(options...)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			ts := httptest.NewServer(s)
+			t.Cleanup(ts.Close)
+
+			mcpClient := client.NewClient(newStreamableHTTP(ts.URL, &HTTPMCPOption{Headers: testMCPBearerHeader(tt.token), HTTPClient: http.DefaultClient}, conn.logger))
+			if _, err := conn.startMCPClient(mcpClient, testMCPInitRequest()); err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			t.Cleanup(func() { mcpClient.Close() })
+
+			req := mcp.CallToolRequest{}
+			req.Params.Name = "admin_op"
+			res, err := mcpClient.CallTool(context.Background(), req)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			if tt.allowed {
+				if res.IsError {
+					t.Errorf("must be allowed: %v", res.Content)
+				}
+				return
+			}
+			if res.IsError != true || strings.Contains(res.Content[0].(mcp.TextContent).Text, "permission denied") != true {
+				t.Errorf("must be denied: %v", res.Content)
+			}
+		})
+	}
+}
+
+func TestMCPServerSessionIdleTimeout(t *testing.T) {
+	_, conn := testRegistry(t)
+	s, err := conn.NewMCPServer(
+		MCPServerAuthenticator(func(*http.Request) (Identity, error) {
+			return Identity{}, nil
+		}),
+		MCPServerSessionIdleTimeout(100*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	resp, err := http.Post(ts.URL, "application/json", strings.NewReader(testMCPInitBody))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	resp.Body.Close()
+	sessionID := resp.Header.Get(mcpSessionIDHeader)
+	if _, ok := s.sessions.Load(sessionID); ok != true {
+		t.Fatalf("session must be created: %s", sessionID)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := s.sessions.Load(sessionID); ok != true {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("idle session must be expired")
+}
+
+func TestMCPServerStdio(t *testing.T) {
+	r, toolConn := testRegistry(t)
+	if err := toolConn.RegisterTool(testGreetTool("greet")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	s, err := conn.NewMCPServer()
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ServeStdio(ctx, stdinR, stdoutW)
+
+	lines := bufio.NewReader(stdoutR)
+	send := func(msg string) map[string]any {
+		t.Helper()
+		if _, err := io.WriteString(stdinW, msg+"\n"); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		resp := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		return resp
+	}
+
+	send(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","clientInfo":{"name":"test","version":"1"},"capabilities":{}}}`)
+	resp := send(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"greet","arguments":{"name":"stdio"}}}`)
+	result, _ := resp["result"].(map[string]any)
+	content, _ := result["content"].([]any)
+	if len(content) != 1 {
+		t.Fatalf("resp = %v", resp)
+	}
+	text, _ := content[0].(map[string]any)["text"].(string)
+	if strings.Contains(text, "hello stdio") != true {
+		t.Errorf("text = %s", text)
+	}
+}
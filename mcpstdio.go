@@ -0,0 +1,142 @@
+package polaris
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/pkg/errors"
+)
+
+const (
+	stdioMCPRestartMinWait = 500 * time.Millisecond
+	stdioMCPRestartMaxWait = 30 * time.Second
+	// backoff is reset when the process was running longer than this
+	stdioMCPStableDuration = time.Minute
+)
+
+// RegisterStdioMCPTools spawns MCP server as a child process and bridges its tools into the registry.
+// env is "KEY=VALUE" added to the current environment.
+// The process is restarted with backoff when it exits, and is terminated on Conn.Close
+func (c *Conn) RegisterStdioMCPTools(command string, args []string, env []string, initReq mcp.InitializeRequest) error {
+	s := &stdioMCPServer{
+		conn:    c,
+		command: command,
+		args:    args,
+		env:     env,
+		initReq: initReq,
+	}
+	mcpClient, initResp, exited, err := s.start()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	session := newMCPSession(c.ctx, mcpClient, initResp)
+	if err := c.bridgeMCPSession(session); err != nil {
+		return errors.WithStack(err)
+	}
+
+	go s.supervise(session, exited)
+	return nil
+}
+
+type stdioMCPServer struct {
+	conn    *Conn
+	command string
+	args    []string
+	env     []string
+	initReq mcp.InitializeRequest
+}
+
+// start spawns process, returned channel is closed when the process exits
+func (s *stdioMCPServer) start() (*client.Client, *mcp.InitializeResult, <-chan struct{}, error) {
+	stdio := transport.NewStdio(s.command, s.env, s.args...)
+	mcpClient := client.NewClient(stdio)
+	// process is killed when Conn is closed
+	if err := mcpClient.Start(s.conn.ctx); err != nil {
+		return nil, nil, nil, errors.WithStack(err)
+	}
+	exited := s.drainStderr(stdio.Stderr())
+
+	ctx, cancel := context.WithTimeout(s.conn.ctx, s.conn.opt.ReqTimeout)
+	defer cancel()
+
+	initResp, err := mcpClient.Initialize(ctx, s.initReq)
+	if err != nil {
+		mcpClient.Close()
+		return nil, nil, nil, errors.Wrapf(err, "initialize %s", s.command)
+	}
+	s.conn.logger.Debugf("stdioMCPClient init=%v", initResp.ServerInfo)
+	return mcpClient, initResp, exited, nil
+}
+
+// drainStderr logs stderr of the process, EOF of stderr means exit of the process
+func (s *stdioMCPServer) drainStderr(stderr io.Reader) <-chan struct{} {
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+
+		r := bufio.NewReader(stderr)
+		for {
+			line, err := r.ReadString('\n')
+			if line = strings.TrimSpace(line); line != "" {
+				s.conn.logger.Debugf("mcp %s: %s", s.command, line)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return exited
+}
+
+func (s *stdioMCPServer) supervise(session *mcpSession, exited <-chan struct{}) {
+	ctx := s.conn.ctx
+	wait := stdioMCPRestartMinWait
+	startedAt := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-exited:
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		// in-flight calls of the exited process fail immediately
+		if session.replace(nil) != true {
+			return
+		}
+		if stdioMCPStableDuration < time.Since(startedAt) {
+			wait = stdioMCPRestartMinWait
+		}
+		s.conn.logger.Warnf("mcp server %s exited, restart after %s", s.command, wait)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			wait = min(wait*2, stdioMCPRestartMaxWait)
+
+			mcpClient, _, nextExited, err := s.start()
+			if err != nil {
+				s.conn.logger.Warnf("failed to restart mcp server %s: %+v", s.command, err)
+				continue
+			}
+			if session.replace(mcpClient) != true {
+				mcpClient.Close()
+				return
+			}
+			exited = nextExited
+			startedAt = time.Now()
+			break
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package polaris
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const testStdioMCPEnv = "POLARIS_TEST_STDIO_MCP"
+
+// TestMain runs the test binary as a tiny stdio MCP server when testStdioMCPEnv is set
+func TestMain(m *testing.M) {
+	if os.Getenv(testStdioMCPEnv) == "1" {
+		serveTestStdioMCP()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func serveTestStdioMCP() {
+	s := newTestMCPServer()
+	s.AddTool(
+		mcp.NewTool("test_crash", mcp.WithDescription("exit server")),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			os.Exit(1)
+			return nil, nil
+		},
+	)
+	server.ServeStdio(s)
+}
+
+func newTestMCPServer() *server.MCPServer {
+	s := server.NewMCPServer("polaris-test", "1.0.0")
+	s.AddTool(
+		mcp.NewTool("test_echo",
+			mcp.WithDescription("echo message"),
+			mcp.WithString("message", mcp.Required()),
+		),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			msg, _ := req.Params.Arguments["message"].(string)
+			return mcp.NewToolResultText(msg), nil
+		},
+	)
+	s.AddTool(
+		mcp.NewTool("test_pid", mcp.WithDescription("pid of server")),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText(strconv.Itoa(os.Getpid())), nil
+		},
+	)
+	return s
+}
+
+func testRegistry(t *testing.T) (*Registry, *Conn) {
+	t.Helper()
+
+	r, err := CreateRegistry(WithBind("127.0.0.1", -1))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(r.Close)
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name(t.Name()), RequestTimeout(10*time.Second))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+	return r, conn
+}
+
+func testMCPInitRequest() mcp.InitializeRequest {
+	initReq := mcp.InitializeRequest{}
+	initReq.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initReq.Params.ClientInfo = mcp.Implementation{Name: "polaris-test", Version: "1.0.0"}
+	return initReq
+}
+
+func TestRegisterStdioMCPTools(t *testing.T) {
+	r, caller := testRegistry(t)
+
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("stdio-mcp"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	err = conn.RegisterStdioMCPTools(
+		os.Args[0],
+		[]string{"-test.run=^$"},
+		[]string{testStdioMCPEnv + "=1"},
+		testMCPInitRequest(),
+	)
+	if err != nil {
+		conn.Close()
+		t.Fatalf("no error: %+v", err)
+	}
+
+	ctx := context.Background()
+	pid := func() int {
+		resp, err := caller.Call(ctx, "test_pid", Req{})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		results, _ := resp["results"].([]any)
+		if len(results) != 1 {
+			return 0
+		}
+		n, _ := strconv.Atoi(results[0].(string))
+		return n
+	}
+
+	resp, err := caller.Call(ctx, "test_echo", Req{"message": "hello"})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if results, _ := resp["results"].([]any); len(results) != 1 || results[0] != "hello" {
+		t.Errorf("resp = %v", resp)
+	}
+
+	firstPID := pid()
+	if firstPID == 0 {
+		t.Fatalf("pid required")
+	}
+
+	resp, err = caller.Call(ctx, "test_crash", Req{})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if _, ok := resp["_error"]; ok != true {
+		t.Errorf("crash must be error: %v", resp)
+	}
+
+	restartedPID := 0
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+		if restartedPID = pid(); restartedPID != 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if restartedPID == 0 || restartedPID == firstPID {
+		t.Fatalf("server must be restarted: pid %d -> %d", firstPID, restartedPID)
+	}
+
+	conn.Close()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if err := syscall.Kill(restartedPID, 0); errors.Is(err, syscall.ESRCH) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("server must be terminated on Close: pid=%d", restartedPID)
+}
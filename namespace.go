@@ -0,0 +1,200 @@
+package polaris
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/pkg/errors"
+)
+
+type CollisionPolicy string
+
+const (
+	// CollisionReject fails to register the tool whose name is already registered
+	CollisionReject CollisionPolicy = "reject"
+	// CollisionPrefix registers the tool as "<Conn name>.<tool name>" when the name is already registered
+	CollisionPrefix CollisionPolicy = "prefix"
+	// CollisionReplace takes over the name from the previous owner
+	CollisionReplace CollisionPolicy = "replace"
+)
+
+const (
+	toolNamespaceSeparator = "."
+	// function name of Gemini is up to 64 characters
+	maxToolNameLength  = 64
+	toolNameHashLength = 8
+)
+
+var (
+	invalidFunctionNameChars = regexp.MustCompile(`[^A-Za-z0-9_.:\-]+`)
+	validFunctionNameStart   = regexp.MustCompile(`^[A-Za-z_]`)
+)
+
+// MCPNamespaceFunc returns namespace of tools bridged from the MCP server, empty means no namespace
+type MCPNamespaceFunc func(serverInfo mcp.Implementation) string
+
+// MCPServerNameNamespace uses name of the server as namespace, e.g. "github.search"
+func MCPServerNameNamespace(serverInfo mcp.Implementation) string {
+	return mcpServerPrefix(&mcp.InitializeResult{ServerInfo: serverInfo})
+}
+
+// ConnectToolNamespace prefixes tools registered by the Conn as "<namespace>.<name>"
+func ConnectToolNamespace(namespace string) ConnectOptionFunc {
+	return func(o *ConnectOption) {
+		o.ToolNamespace = namespace
+	}
+}
+
+// ConnectMCPNamespace prefixes tools bridged from each MCP server by the namespace returned by f
+func ConnectMCPNamespace(f MCPNamespaceFunc) ConnectOptionFunc {
+	return func(o *ConnectOption) {
+		o.MCPNamespace = f
+	}
+}
+
+// ConnectCollisionPolicy sets how to register the tool whose name is already registered by other Conn,
+// defaults to CollisionReject
+func ConnectCollisionPolicy(policy CollisionPolicy) ConnectOptionFunc {
+	return func(o *ConnectOption) {
+		o.CollisionPolicy = policy
+	}
+}
+
+// SanitizeToolName converts name to satisfy function name of Gemini:
+// starts with a letter or underscore, consists of a-z, A-Z, 0-9, underscores, dots, colons and dashes
+// and is up to 64 characters. A long name is truncated with hash of the name to keep it unique
+func SanitizeToolName(name string) string {
+	sanitized := invalidFunctionNameChars.ReplaceAllString(name, "_")
+	if validFunctionNameStart.MatchString(sanitized) != true {
+		sanitized = "_" + sanitized
+	}
+	if len(sanitized) <= maxToolNameLength {
+		return sanitized
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("%s_%08x", sanitized[:maxToolNameLength-toolNameHashLength-1], h.Sum32())
+}
+
+// NamespacedToolName joins namespaces and name by ".", empty namespaces are skipped
+func NamespacedToolName(name string, namespaces ...string) string {
+	full := name
+	for i := len(namespaces) - 1; 0 <= i; i -= 1 {
+		if namespaces[i] == "" {
+			continue
+		}
+		full = namespaces[i] + toolNamespaceSeparator + full
+	}
+	return SanitizeToolName(full)
+}
+
+// toolName returns name of the tool in the registry, namespace of the Conn is the outermost
+func (c *Conn) toolName(name string, namespaces ...string) string {
+	return NamespacedToolName(name, append([]string{c.opt.ToolNamespace}, namespaces...)...)
+}
+
+func (c *Conn) mcpNamespace(server *mcp.InitializeResult) string {
+	if c.opt.MCPNamespace == nil || server == nil {
+		return ""
+	}
+	return c.opt.MCPNamespace(server.ServerInfo)
+}
+
+// registerTool subscribes handler as the tool named with namespaces, collision of the name is resolved by CollisionPolicy.
+// the registered tool is returned since the name may differ from t.Name
+func (c *Conn) registerTool(t Tool, handler reqrespHandler[map[string]any, map[string]any], namespaces ...string) (Tool, error) {
+	t.Name = c.toolName(t.Name, namespaces...)
+
+	registered, err := c.registerToolAs(t, handler, c.opt.CollisionPolicy == CollisionReplace)
+	if errors.Is(err, ErrToolAlreadyRegistered) && c.opt.CollisionPolicy == CollisionPrefix {
+		prefixed := t
+		prefixed.Name = NamespacedToolName(t.Name, c.natsOpt.Name)
+		c.logger.Warnf("tool %s is already registered, register as %s", t.Name, prefixed.Name)
+		registered, err = c.registerToolAs(prefixed, handler, false)
+	}
+	if err != nil {
+		return Tool{}, errors.WithStack(err)
+	}
+	return registered, nil
+}
+
+func (c *Conn) registerToolAs(t Tool, handler reqrespHandler[map[string]any, map[string]any], replace bool) (Tool, error) {
+	sub, err := subscribeReqRespMsg(
+		c,
+		tooltopic(t.Name),
+		JSONEncoder[map[string]any](),
+		JSONEncoder[map[string]any](),
+		c.toolCallHandler(t, handler),
+	)
+	if err != nil {
+		return Tool{}, errors.WithStack(err)
+	}
+
+	register := c.registerDeclaration
+	if replace {
+		register = c.replaceDeclaration
+	}
+	if err := register(t.FunctionDeclaration()); err != nil {
+		c.unsubscribe(sub)
+		return Tool{}, errors.Wrapf(err, "tool %s", t.Name)
+	}
+	c.appendTool(t, sub)
+	return t, nil
+}
+
+// unregisterTool unregisters the tool owned by the Conn, the tool replaced by other Conn is already dropped
+func (c *Conn) unregisterTool(name string) error {
+	c.mutex.RLock()
+	sub, ok := c.toolSubs[name]
+	c.mutex.RUnlock()
+	if ok != true {
+		return nil
+	}
+
+	t, _ := c.Tool(name)
+	c.dropTool(name)
+	if err := c.unsubscribe(sub); err != nil {
+		c.logger.Warnf("unsubscribe %s: %+v", name, err)
+	}
+	if err := c.unregisterDeclaration(t.FunctionDeclaration()); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (c *Conn) replaceDeclaration(dec WrapFunctionDeclaration) error {
+	resp, err := requestWithData(
+		c,
+		TopicReplaceTool,
+		JSONEncoder[toolReplace](),
+		JSONEncoder[RespError](),
+		toolReplace{dec, c.id},
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := resp.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// handleToolReplaced drops the tool taken over by other Conn without unregistering it
+func (c *Conn) handleToolReplaced(req toolReplace) {
+	if req.Owner == c.id {
+		return
+	}
+	c.mutex.RLock()
+	sub, ok := c.toolSubs[req.Declare.Name]
+	c.mutex.RUnlock()
+	if ok != true {
+		return
+	}
+	c.dropTool(req.Declare.Name)
+	if err := c.unsubscribe(sub); err != nil {
+		c.logger.Warnf("unsubscribe %s: %+v", req.Declare.Name, err)
+	}
+	c.logger.Warnf("tool %s is replaced by other", req.Declare.Name)
+}
@@ -0,0 +1,234 @@
+package polaris
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/pkg/errors"
+)
+
+func TestSanitizeToolName(t *testing.T) {
+	long := strings.Repeat("a", 70)
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"valid", "github.search_issues:v1-beta", "github.search_issues:v1-beta"},
+		{"space", "search issues", "search_issues"},
+		{"invalid chars", "a/b?c=d", "a_b_c_d"},
+		{"leading digit", "1password", "_1password"},
+		{"leading dot", ".hidden", "_.hidden"},
+		{"empty", "", "_"},
+		{"unicode", "検索", "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeToolName(tt.input); got != tt.want {
+				t.Errorf("actual=%s expect=%s", got, tt.want)
+			}
+		})
+	}
+	t.Run("long", func(t *testing.T) {
+		got := SanitizeToolName(long)
+		if len(got) != maxToolNameLength {
+			t.Errorf("len=%d: %s", len(got), got)
+		}
+		if SanitizeToolName(long+"b") == got {
+			t.Errorf("truncated names must be unique")
+		}
+	})
+}
+
+func TestNamespacedToolName(t *testing.T) {
+	tests := []struct {
+		name       string
+		tool       string
+		namespaces []string
+		want       string
+	}{
+		{"none", "search", nil, "search"},
+		{"one", "search", []string{"github"}, "github.search"},
+		{"nested", "search", []string{"fleet", "github"}, "fleet.github.search"},
+		{"empty namespace", "search", []string{"", "github"}, "github.search"},
+		{"sanitized", "search", []string{"my server"}, "my_server.search"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NamespacedToolName(tt.tool, tt.namespaces...); got != tt.want {
+				t.Errorf("actual=%s expect=%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func testOwnerTool(name, owner string) Tool {
+	return Tool{
+		Name: name,
+		Response: Object{
+			Properties: Properties{
+				"owner": String{Required: true},
+			},
+		},
+		Handler: func(r *ReqCtx) (Resp, error) {
+			return Resp{"owner": owner}, nil
+		},
+	}
+}
+
+func TestToolCollisionPolicy(t *testing.T) {
+	connect := func(t *testing.T, r *Registry, name string, options ...ConnectOptionFunc) *Conn {
+		conn, err := Connect(append([]ConnectOptionFunc{NatsURL(r.ns.ClientURL()), Name(name)}, options...)...)
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		t.Cleanup(conn.Close)
+		return conn
+	}
+	call := func(t *testing.T, caller *Conn, name string) Resp {
+		resp, err := caller.Call(context.Background(), name, Req{})
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		return resp
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		r, caller := testRegistry(t)
+		connA := connect(t, r, "agentA")
+		connB := connect(t, r, "agentB")
+		if err := connA.RegisterTool(testOwnerTool("search", "A")); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		err := connB.RegisterTool(testOwnerTool("search", "B"))
+		if errors.Is(err, ErrToolAlreadyRegistered) != true {
+			t.Fatalf("must be ErrToolAlreadyRegistered: %+v", err)
+		}
+		if _, ok := connB.Tool("search"); ok {
+			t.Errorf("rejected tool must not be kept")
+		}
+		if resp := call(t, caller, "search"); resp["owner"] != "A" {
+			t.Errorf("rejected tool must not respond: %v", resp)
+		}
+	})
+	t.Run("prefix", func(t *testing.T) {
+		r, caller := testRegistry(t)
+		connA := connect(t, r, "agentA")
+		connB := connect(t, r, "agentB", ConnectCollisionPolicy(CollisionPrefix))
+		if err := connA.RegisterTool(testOwnerTool("search", "A")); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if err := connB.RegisterTool(testOwnerTool("search", "B")); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if _, ok := connB.Tool("agentB.search"); ok != true {
+			t.Errorf("tool must be prefixed by name of Conn")
+		}
+		if resp := call(t, caller, "search"); resp["owner"] != "A" {
+			t.Errorf("resp = %v", resp)
+		}
+		if resp := call(t, caller, "agentB.search"); resp["owner"] != "B" {
+			t.Errorf("resp = %v", resp)
+		}
+	})
+	t.Run("replace", func(t *testing.T) {
+		r, caller := testRegistry(t)
+		connA := connect(t, r, "agentA")
+		connB := connect(t, r, "agentB", ConnectCollisionPolicy(CollisionReplace))
+		if err := connA.RegisterTool(testOwnerTool("search", "A")); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if err := connB.RegisterTool(testOwnerTool("search", "B")); err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if _, ok := connA.Tool("search"); ok != true {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if _, ok := connA.Tool("search"); ok {
+			t.Fatalf("replaced tool must be dropped from previous owner")
+		}
+		for i := 0; i < 10; i += 1 {
+			if resp := call(t, caller, "search"); resp["owner"] != "B" {
+				t.Fatalf("resp = %v", resp)
+			}
+		}
+
+		// previous owner must not unregister the replaced tool
+		connA.Close()
+		waitRegistryTools(t, caller, func(names []string) bool {
+			return slices.Contains(names, "search")
+		})
+		if resp := call(t, caller, "search"); resp["owner"] != "B" {
+			t.Errorf("resp = %v", resp)
+		}
+	})
+}
+
+func TestToolNamespace(t *testing.T) {
+	r, caller := testRegistry(t)
+
+	s := newTestMCPServer()
+	s.AddResource(mcp.NewResource("file:///readme.md", "readme"),
+		func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			return []mcp.ResourceContents{
+				mcp.TextResourceContents{URI: req.Params.URI, Text: "readme"},
+			}, nil
+		},
+	)
+	ts := server.NewTestServer(s)
+	t.Cleanup(ts.Close)
+
+	conn, err := Connect(
+		NatsURL(r.ns.ClientURL()),
+		Name("fleet"),
+		ConnectToolNamespace("fleet"),
+		ConnectMCPNamespace(MCPServerNameNamespace),
+	)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	if err := conn.RegisterTool(testOwnerTool("search", "fleet")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	if err := conn.RegisterSSEMCPTools(ts.URL+"/sse", testMCPInitRequest()); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	want := []string{
+		"fleet.polaris_test.list_resources",
+		"fleet.polaris_test.read_resource",
+		"fleet.polaris_test.test_echo",
+		"fleet.polaris_test.test_pid",
+		"fleet.search",
+	}
+	waitRegistryTools(t, caller, func(names []string) bool {
+		slices.Sort(names)
+		return slices.Equal(names, want)
+	})
+
+	resp, err := caller.Call(context.Background(), "fleet.polaris_test.test_echo", Req{"message": "hello"})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if results, _ := resp["results"].([]any); len(results) != 1 || results[0] != "hello" {
+		t.Errorf("bridged tool must be called by original name: %v", resp)
+	}
+	resp, err = caller.Call(context.Background(), "fleet.polaris_test.read_resource", Req{"uri": "file:///readme.md"})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if contents, _ := resp["contents"].([]any); len(contents) != 1 {
+		t.Errorf("resp = %v", resp)
+	}
+}
@@ -0,0 +1,536 @@
+package polaris
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	openAPISchemaRefPrefix = "#/components/schemas/"
+	openAPIBodyKey         = "body"
+	defaultOpenAPITimeout  = 30 * time.Second
+	// openAPIMaxResponseSize bounds the response body read into memory
+	openAPIMaxResponseSize = 4 * 1024 * 1024
+)
+
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+type OpenAPIOptionFunc func(*OpenAPIOption)
+
+type OpenAPIOption struct {
+	Operations []string
+	Tags       []string
+	Headers    http.Header
+	HTTPClient *http.Client
+}
+
+// OpenAPIOperations selects operations by operationId(or generated tool name)
+func OpenAPIOperations(operationIDs ...string) OpenAPIOptionFunc {
+	return func(o *OpenAPIOption) {
+		o.Operations = append(o.Operations, operationIDs...)
+	}
+}
+
+// OpenAPITags selects operations that have any of tags
+func OpenAPITags(tags ...string) OpenAPIOptionFunc {
+	return func(o *OpenAPIOption) {
+		o.Tags = append(o.Tags, tags...)
+	}
+}
+
+// OpenAPIHeader adds header to every request, e.g. auth header
+func OpenAPIHeader(key, value string) OpenAPIOptionFunc {
+	return func(o *OpenAPIOption) {
+		o.Headers.Add(key, value)
+	}
+}
+
+func OpenAPIBearerToken(token string) OpenAPIOptionFunc {
+	return func(o *OpenAPIOption) {
+		o.Headers.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func OpenAPIHTTPClient(client *http.Client) OpenAPIOptionFunc {
+	return func(o *OpenAPIOption) {
+		o.HTTPClient = client
+	}
+}
+
+type openAPISpec struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas       map[string]map[string]any     `json:"schemas"`
+		Parameters    map[string]openAPIParameter   `json:"parameters"`
+		RequestBodies map[string]openAPIRequestBody `json:"requestBodies"`
+		Responses     map[string]openAPIResponse    `json:"responses"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Description string                     `json:"description"`
+	Tags        []string                   `json:"tags"`
+	Parameters  []openAPIParameter         `json:"parameters"`
+	RequestBody *openAPIRequestBody        `json:"requestBody"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Ref         string         `json:"$ref"`
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description"`
+	Required    bool           `json:"required"`
+	Schema      map[string]any `json:"schema"`
+}
+
+type openAPIMediaType struct {
+	Schema map[string]any `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Ref         string                      `json:"$ref"`
+	Description string                      `json:"description"`
+	Required    bool                        `json:"required"`
+	Content     map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Ref         string                      `json:"$ref"`
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content"`
+}
+
+// RegisterOpenAPITools registers operations of OpenAPI 3 spec(JSON or YAML) as Tool,
+// the tool calls baseURL(or servers[0].url of spec when empty) over HTTP.
+// path/query/header parameters are properties of Parameters and JSON request body is "body" property("_body" when a parameter is named "body"),
+// "." and ".." are rejected as path parameter
+func (c *Conn) RegisterOpenAPITools(spec []byte, baseURL string, options ...OpenAPIOptionFunc) error {
+	opt := &OpenAPIOption{
+		Headers:    http.Header{},
+		HTTPClient: &http.Client{Timeout: defaultOpenAPITimeout},
+	}
+	for _, f := range options {
+		f(opt)
+	}
+
+	tools, err := openAPITools(spec, baseURL, opt)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, t := range tools {
+		if err := c.RegisterTool(t); err != nil {
+			return errors.WithStack(err)
+		}
+		c.logger.Debugf("openapi tool registered: %s", t.Name)
+	}
+	return nil
+}
+
+func parseOpenAPISpec(data []byte) (*openAPISpec, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) != true {
+		jsonData, err := yamlToJSON(data)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		data = jsonData
+	}
+	spec := &openAPISpec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return spec, nil
+}
+
+func openAPITools(data []byte, baseURL string, opt *OpenAPIOption) ([]Tool, error) {
+	spec, err := parseOpenAPISpec(data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if baseURL == "" {
+		if len(spec.Servers) < 1 {
+			return nil, errors.Errorf("baseURL required, spec has no servers")
+		}
+		baseURL = spec.Servers[0].URL
+	}
+
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	tools := make([]Tool, 0)
+	for _, path := range paths {
+		item := spec.Paths[path]
+		common := []openAPIParameter{}
+		if raw, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(raw, &common); err != nil {
+				return nil, errors.Wrapf(err, "%s parameters", path)
+			}
+		}
+		for _, method := range openAPIMethods {
+			raw, ok := item[method]
+			if ok != true {
+				continue
+			}
+			op := openAPIOperation{}
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, errors.Wrapf(err, "%s %s", method, path)
+			}
+			name := openAPIToolName(op.OperationID, method, path)
+			if opt.selected(name, op.Tags) != true {
+				continue
+			}
+			op.Parameters = append(slices.Clone(common), op.Parameters...)
+
+			params := spec.resolveParameters(op.Parameters)
+			bodyKey := openAPIBodyName(params)
+			t, err := spec.tool(name, method, path, op, bodyKey)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s %s", strings.ToUpper(method), path)
+			}
+			if _, ok := t.Parameters.Properties[bodyKey]; ok != true {
+				bodyKey = ""
+			}
+			t.Handler = (&openAPICall{
+				method:     strings.ToUpper(method),
+				baseURL:    strings.TrimSuffix(baseURL, "/"),
+				path:       path,
+				params:     params,
+				bodyKey:    bodyKey,
+				headers:    opt.Headers,
+				httpClient: opt.HTTPClient,
+			}).handle
+			tools = append(tools, t)
+		}
+	}
+	return tools, nil
+}
+
+func (o *OpenAPIOption) selected(name string, tags []string) bool {
+	if 0 < len(o.Operations) && slices.Contains(o.Operations, name) != true {
+		return false
+	}
+	if 0 < len(o.Tags) {
+		for _, tag := range tags {
+			if slices.Contains(o.Tags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+var invalidToolNameChars = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// openAPIToolName returns operationId, or method and path such as "get_users_id"
+func openAPIToolName(operationID, method, path string) string {
+	if operationID != "" {
+		return operationID
+	}
+	name := invalidToolNameChars.ReplaceAllString(method+"_"+path, "_")
+	return strings.Trim(name, "_")
+}
+
+// resolveParameters resolves $ref, a parameter overrides the former one that has the same name and in,
+// e.g. parameter of the operation overrides the one of the path
+func (s *openAPISpec) resolveParameters(params []openAPIParameter) []openAPIParameter {
+	resolved := make([]openAPIParameter, 0, len(params))
+	for _, p := range params {
+		if p.Ref != "" {
+			p = s.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+		}
+		if p.Name == "" {
+			continue
+		}
+		i := slices.IndexFunc(resolved, func(r openAPIParameter) bool { return r.Name == p.Name && r.In == p.In })
+		if 0 <= i {
+			resolved[i] = p
+			continue
+		}
+		resolved = append(resolved, p)
+	}
+	return resolved
+}
+
+// openAPIBodyName is the property of request body, renamed with "_" prefix when a parameter has the same name
+func openAPIBodyName(params []openAPIParameter) string {
+	name := openAPIBodyKey
+	for slices.ContainsFunc(params, func(p openAPIParameter) bool { return p.Name == name }) {
+		name = "_" + name
+	}
+	return name
+}
+
+func (s *openAPISpec) tool(name, method, path string, op openAPIOperation, bodyKey string) (Tool, error) {
+	desc := op.Summary
+	if op.Description != "" {
+		desc = strings.TrimSpace(strings.Join([]string{op.Summary, op.Description}, "\n"))
+	}
+
+	props := Properties{}
+	refs := map[string]struct{}{}
+	for _, p := range s.resolveParameters(op.Parameters) {
+		schema := p.Schema
+		if schema == nil {
+			schema = map[string]any{"type": "string"}
+		}
+		schema = s.rewriteRefs(schema, refs)
+		if _, ok := schema["description"]; ok != true && p.Description != "" {
+			schema["description"] = p.Description
+		}
+		def, err := typeDefFromJSONSchema(schema, "$."+p.Name, p.Required || p.In == "path")
+		if err != nil {
+			return Tool{}, errors.WithStack(err)
+		}
+		props[p.Name] = def
+	}
+
+	if body := op.RequestBody; body != nil {
+		if body.Ref != "" {
+			resolved := s.Components.RequestBodies[strings.TrimPrefix(body.Ref, "#/components/requestBodies/")]
+			body = &resolved
+		}
+		if media, ok := body.Content["application/json"]; ok && media.Schema != nil {
+			schema := s.rewriteRefs(media.Schema, refs)
+			if _, ok := schema["description"]; ok != true && body.Description != "" {
+				schema["description"] = body.Description
+			}
+			def, err := typeDefFromJSONSchema(schema, "$."+bodyKey, body.Required)
+			if err != nil {
+				return Tool{}, errors.WithStack(err)
+			}
+			props[bodyKey] = def
+		}
+	}
+
+	defs, err := s.definitions(refs)
+	if err != nil {
+		return Tool{}, errors.WithStack(err)
+	}
+	respRefs := map[string]struct{}{}
+	resp, err := s.responseObject(op.Responses, respRefs)
+	if err != nil {
+		return Tool{}, errors.WithStack(err)
+	}
+	resp.Defs, err = s.definitions(respRefs)
+	if err != nil {
+		return Tool{}, errors.WithStack(err)
+	}
+	return Tool{
+		Name:        name,
+		Description: desc,
+		Parameters: Object{
+			Properties: props,
+			Defs:       defs,
+			Required:   true,
+		},
+		Response: resp,
+	}, nil
+}
+
+// responseObject returns Object of the first 2xx JSON response, non-object response is "result" property
+func (s *openAPISpec) responseObject(responses map[string]openAPIResponse, refs map[string]struct{}) (Object, error) {
+	codes := make([]string, 0, len(responses))
+	for code := range responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		r := responses[code]
+		if r.Ref != "" {
+			r = s.Components.Responses[strings.TrimPrefix(r.Ref, "#/components/responses/")]
+		}
+		media, ok := r.Content["application/json"]
+		if ok != true || media.Schema == nil {
+			continue
+		}
+		schema := media.Schema
+		if ref, ok := schema["$ref"].(string); ok {
+			// {"$ref": "#/components/schemas/User"} is used as Response itself
+			if name, ok := strings.CutPrefix(ref, openAPISchemaRefPrefix); ok {
+				if def, exists := s.Components.Schemas[name]; exists {
+					schema = def
+				}
+			}
+		}
+		def, err := typeDefFromJSONSchema(s.rewriteRefs(schema, refs), "$", true)
+		if err != nil {
+			return Object{}, errors.WithStack(err)
+		}
+		if obj, ok := def.(Object); ok {
+			return obj, nil
+		}
+		return Object{
+			Description: r.Description,
+			Properties:  Properties{"result": def},
+		}, nil
+	}
+	return Object{}, nil
+}
+
+// rewriteRefs returns copy of schema whose "#/components/schemas/Name" is "#/$defs/Name",
+// referred names are collected in refs
+func (s *openAPISpec) rewriteRefs(schema map[string]any, refs map[string]struct{}) map[string]any {
+	ret := make(map[string]any, len(schema))
+	for k, v := range schema {
+		ret[k] = s.rewriteRefValue(k, v, refs)
+	}
+	return ret
+}
+
+func (s *openAPISpec) rewriteRefValue(key string, v any, refs map[string]struct{}) any {
+	switch vv := v.(type) {
+	case string:
+		if name, ok := strings.CutPrefix(vv, openAPISchemaRefPrefix); ok && key == "$ref" {
+			if _, visited := refs[name]; visited != true {
+				refs[name] = struct{}{}
+				if def, exists := s.Components.Schemas[name]; exists {
+					s.rewriteRefs(def, refs)
+				}
+			}
+			return refPrefix + name
+		}
+		return vv
+	case map[string]any:
+		return s.rewriteRefs(vv, refs)
+	case []any:
+		list := make([]any, len(vv))
+		for i, item := range vv {
+			list[i] = s.rewriteRefValue("", item, refs)
+		}
+		return list
+	}
+	return v
+}
+
+func (s *openAPISpec) definitions(refs map[string]struct{}) (Definitions, error) {
+	if len(refs) < 1 {
+		return nil, nil
+	}
+	defs := Definitions{}
+	for name := range refs {
+		schema, ok := s.Components.Schemas[name]
+		if ok != true {
+			return nil, errors.Errorf("schema not found: %s%s", openAPISchemaRefPrefix, name)
+		}
+		def, err := typeDefFromJSONSchema(s.rewriteRefs(schema, map[string]struct{}{}), "$defs."+name, false)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defs[name] = def
+	}
+	return defs, nil
+}
+
+type openAPICall struct {
+	method     string
+	baseURL    string
+	path       string
+	params     []openAPIParameter
+	bodyKey    string
+	headers    http.Header
+	httpClient *http.Client
+}
+
+func (o *openAPICall) handle(r *ReqCtx) (Resp, error) {
+	req := r.Req()
+	path := o.path
+	query := url.Values{}
+	headers := o.headers.Clone()
+	for _, p := range o.params {
+		v, ok := req[p.Name]
+		if ok != true || v == nil {
+			continue
+		}
+		values, err := commandArgValues(p.Name, v)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		switch p.In {
+		case "path":
+			value := strings.Join(values, ",")
+			if value == "." || value == ".." {
+				return nil, errors.Errorf("path parameter %s must not be %q", p.Name, value)
+			}
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", url.PathEscape(value))
+		case "query":
+			for _, value := range values {
+				query.Add(p.Name, value)
+			}
+		case "header":
+			headers.Set(p.Name, strings.Join(values, ","))
+		}
+	}
+
+	u := o.baseURL + path
+	if 0 < len(query) {
+		u += "?" + query.Encode()
+	}
+
+	body := io.Reader(nil)
+	if v, ok := req[o.bodyKey]; o.bodyKey != "" && ok && v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		body = bytes.NewReader(data)
+		headers.Set("Content-Type", "application/json")
+	}
+
+	httpReq, err := http.NewRequestWithContext(r.Context(), o.method, u, body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	httpReq.Header = headers
+	if httpReq.Header.Get("Accept") == "" {
+		httpReq.Header.Set("Accept", "application/json")
+	}
+
+	httpResp, err := o.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(httpResp.Body, openAPIMaxResponseSize+1))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if openAPIMaxResponseSize < len(data) {
+		return nil, errors.Errorf("%s %s: response exceeds %d bytes", o.method, o.path, openAPIMaxResponseSize)
+	}
+	if httpResp.StatusCode < 200 || 300 <= httpResp.StatusCode {
+		return nil, errors.Errorf("%s %s: status %d: %s", o.method, o.path, httpResp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if len(bytes.TrimSpace(data)) < 1 {
+		return Resp{}, nil
+	}
+
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return Resp{"result": string(data)}, nil
+	}
+	if m, ok := v.(map[string]any); ok {
+		return Resp(m), nil
+	}
+	return Resp{"result": v}, nil
+}
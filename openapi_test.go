@@ -0,0 +1,368 @@
+package polaris
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testOpenAPISpec = `
+openapi: 3.0.3
+info: {title: users, version: "1.0"}
+servers:
+  - url: http://localhost:0
+paths:
+  /users/{id}:
+    parameters:
+      - name: id
+        in: path
+        required: true
+        schema: {type: integer}
+    get:
+      operationId: getUser
+      summary: get user
+      tags: [users]
+      parameters:
+        - $ref: '#/components/parameters/Verbose'
+      responses:
+        "200":
+          description: user
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/User'}
+    put:
+      operationId: updateUser
+      tags: [admin]
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/User'}
+      responses:
+        "204": {description: updated}
+  /users:
+    get:
+      tags: [users]
+      parameters:
+        - name: tag
+          in: query
+          schema: {type: array, items: {type: string}}
+      responses:
+        "200":
+          description: users
+          content:
+            application/json:
+              schema:
+                type: array
+                items: {$ref: '#/components/schemas/User'}
+components:
+  parameters:
+    Verbose:
+      name: verbose
+      in: query
+      schema: {type: boolean}
+  schemas:
+    User:
+      type: object
+      required: [name]
+      properties:
+        name: {type: string}
+        manager: {$ref: '#/components/schemas/User'}
+`
+
+func TestOpenAPITools(t *testing.T) {
+	type captured struct {
+		method, path, query, auth string
+		body                      map[string]any
+	}
+	last := captured{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = captured{r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), nil}
+		if r.Body != nil {
+			data, _ := io.ReadAll(r.Body)
+			json.Unmarshal(data, &last.body)
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/users/404":
+			http.Error(w, "not found", http.StatusNotFound)
+		case r.Method == http.MethodGet && r.URL.Path == "/users":
+			w.Write([]byte(`[{"name": "a"}, {"name": "b"}]`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"name": "foo"}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	opt := &OpenAPIOption{Headers: http.Header{}, HTTPClient: srv.Client()}
+	OpenAPIBearerToken("secret")(opt)
+	tools, err := openAPITools([]byte(testOpenAPISpec), srv.URL, opt)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	byName := map[string]Tool{}
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+	}
+	if len(byName) != 3 {
+		t.Fatalf("tools = %v", byName)
+	}
+
+	getUser := byName["getUser"]
+	if id, ok := getUser.Parameters.Properties["id"].(Int); ok != true || id.Required != true {
+		t.Errorf("id = %#v", getUser.Parameters.Properties["id"])
+	}
+	if _, ok := getUser.Parameters.Properties["verbose"].(Bool); ok != true {
+		t.Errorf("verbose = %#v", getUser.Parameters.Properties["verbose"])
+	}
+	if _, ok := getUser.Response.Properties["manager"].(Ref); ok != true || getUser.Response.Defs["User"] == nil {
+		t.Errorf("Response = %#v", getUser.Response)
+	}
+
+	resp := handleToolCall(context.Background(), getUser)(map[string]any{"id": float64(1), "verbose": true})
+	if resp["name"] != "foo" {
+		t.Errorf("resp = %v", resp)
+	}
+	if last.method != http.MethodGet || last.path != "/users/1" || last.query != "verbose=true" || last.auth != "Bearer secret" {
+		t.Errorf("request = %+v", last)
+	}
+
+	resp = handleToolCall(context.Background(), getUser)(map[string]any{"id": float64(404)})
+	if resp["_error"] == nil {
+		t.Errorf("non 2xx must be error: %v", resp)
+	}
+
+	updateUser := byName["updateUser"]
+	if body, ok := updateUser.Parameters.Properties["body"].(Ref); ok != true || body.Required != true {
+		t.Errorf("body = %#v", updateUser.Parameters.Properties["body"])
+	}
+	resp = handleToolCall(context.Background(), updateUser)(map[string]any{
+		"id":   float64(2),
+		"body": map[string]any{"name": "bar", "manager": map[string]any{"name": "boss"}},
+	})
+	if _, ok := resp["_error"]; ok {
+		t.Errorf("no error: %v", resp)
+	}
+	if last.method != http.MethodPut || last.path != "/users/2" || last.body["name"] != "bar" {
+		t.Errorf("request = %+v", last)
+	}
+	resp = handleToolCall(context.Background(), updateUser)(map[string]any{
+		"id":   float64(2),
+		"body": map[string]any{"manager": map[string]any{}},
+	})
+	if resp["_violations"] == nil {
+		t.Errorf("body must be validated via $ref: %v", resp)
+	}
+
+	listUsers := byName["get_users"]
+	resp = handleToolCall(context.Background(), listUsers)(map[string]any{"tag": []any{"x", "y"}})
+	if list, ok := resp["result"].([]any); ok != true || len(list) != 2 {
+		t.Errorf("resp = %v", resp)
+	}
+	if last.query != "tag=x&tag=y" {
+		t.Errorf("query = %s", last.query)
+	}
+}
+
+func TestOpenAPIToolsSelect(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []OpenAPIOptionFunc
+		want    []string
+	}{
+		{"operations", []OpenAPIOptionFunc{OpenAPIOperations("getUser", "get_users")}, []string{"get_users", "getUser"}},
+		{"tags", []OpenAPIOptionFunc{OpenAPITags("admin")}, []string{"updateUser"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := &OpenAPIOption{Headers: http.Header{}}
+			for _, f := range tt.options {
+				f(opt)
+			}
+			tools, err := openAPITools([]byte(testOpenAPISpec), "", opt)
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			names := make([]string, len(tools))
+			for i, tool := range tools {
+				names[i] = tool.Name
+			}
+			if len(names) != len(tt.want) {
+				t.Fatalf("tools = %v, want %v", names, tt.want)
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Errorf("tools = %v, want %v", names, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAPIToolsParameterSafety(t *testing.T) {
+	spec := `
+openapi: 3.0.3
+info: {title: files, version: "1.0"}
+paths:
+  /files/{name}:
+    get:
+      operationId: getFile
+      parameters:
+        - {name: name, in: path, required: true, schema: {type: string}}
+      responses:
+        "200": {description: file}
+  /notes:
+    post:
+      operationId: createNote
+      parameters:
+        - {name: body, in: query, schema: {type: string}}
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              properties:
+                text: {type: string}
+      responses:
+        "204": {description: created}
+`
+	type captured struct {
+		method, path, query string
+		body                map[string]any
+	}
+	requests := []captured{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := captured{r.Method, r.URL.EscapedPath(), r.URL.RawQuery, nil}
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &c.body)
+		requests = append(requests, c)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	tools, err := openAPITools([]byte(spec), srv.URL, &OpenAPIOption{Headers: http.Header{}, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	byName := map[string]Tool{}
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+	}
+
+	t.Run("dot segment", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			value  string
+			denied bool
+			path   string
+		}{
+			{"dot", ".", true, ""},
+			{"dot dot", "..", true, ""},
+			{"slash", "../admin", false, "/files/..%2Fadmin"},
+			{"dots in name", "a..b", false, "/files/a..b"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				requests = requests[:0]
+				resp := handleToolCall(context.Background(), byName["getFile"])(map[string]any{"name": tt.value})
+				if tt.denied {
+					if resp["_error"] == nil || len(requests) != 0 {
+						t.Errorf("must be denied: resp=%v requests=%v", resp, requests)
+					}
+					return
+				}
+				if len(requests) != 1 || requests[0].path != tt.path {
+					t.Errorf("requests = %+v", requests)
+				}
+			})
+		}
+	})
+	t.Run("body parameter", func(t *testing.T) {
+		createNote := byName["createNote"]
+		if _, ok := createNote.Parameters.Properties["body"].(String); ok != true {
+			t.Errorf("body parameter must be kept: %#v", createNote.Parameters.Properties["body"])
+		}
+		if _, ok := createNote.Parameters.Properties["_body"].(Object); ok != true {
+			t.Errorf("request body must be renamed: %#v", createNote.Parameters.Properties)
+		}
+
+		requests = requests[:0]
+		resp := handleToolCall(context.Background(), createNote)(map[string]any{
+			"body":  "query",
+			"_body": map[string]any{"text": "hello"},
+		})
+		if _, ok := resp["_error"]; ok {
+			t.Fatalf("no error: %v", resp)
+		}
+		if len(requests) != 1 || requests[0].query != "body=query" || requests[0].body["text"] != "hello" {
+			t.Errorf("requests = %+v", requests)
+		}
+	})
+}
+
+func TestOpenAPIToolsOverrideAndResponseLimit(t *testing.T) {
+	spec := `
+openapi: 3.0.3
+info: {title: items, version: "1.0"}
+paths:
+  /items:
+    parameters:
+      - {name: limit, in: query, description: path level, schema: {type: integer}}
+    get:
+      operationId: listItems
+      parameters:
+        - {name: limit, in: query, description: operation level, schema: {type: integer}}
+      responses:
+        "200": {description: items}
+  /large:
+    get:
+      operationId: getLarge
+      responses:
+        "200": {description: large}
+`
+	queries := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large" {
+			w.Write(bytes.Repeat([]byte("a"), openAPIMaxResponseSize+1))
+			return
+		}
+		queries = append(queries, r.URL.RawQuery)
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer srv.Close()
+
+	tools, err := openAPITools([]byte(spec), srv.URL, &OpenAPIOption{Headers: http.Header{}, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	byName := map[string]Tool{}
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+	}
+
+	t.Run("operation overrides path", func(t *testing.T) {
+		listItems := byName["listItems"]
+		if limit, ok := listItems.Parameters.Properties["limit"].(Int); ok != true || limit.Description != "operation level" {
+			t.Errorf("limit = %#v", listItems.Parameters.Properties["limit"])
+		}
+		resp := handleToolCall(context.Background(), listItems)(map[string]any{"limit": float64(10)})
+		if resp["ok"] != true {
+			t.Fatalf("resp = %v", resp)
+		}
+		if len(queries) != 1 || queries[0] != "limit=10" {
+			t.Errorf("query must not be duplicated: %v", queries)
+		}
+	})
+	t.Run("response limit", func(t *testing.T) {
+		resp := handleToolCall(context.Background(), byName["getLarge"])(map[string]any{})
+		if msg, ok := resp["_error"].(string); ok != true || strings.Contains(msg, "exceeds") != true {
+			t.Errorf("large response must be error: %.100v", resp)
+		}
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 )
 
@@ -16,6 +17,21 @@ const (
 	TopicUnregisterTool string = "polaris:tool:unregister"
 	TopicToolKeepalive  string = "polaris:tool:keepalive"
 	TopicListTool       string = "polaris:tool:list"
+	// TopicToolChanged is published by the registry when the list of tools is changed
+	TopicToolChanged string = "polaris:tool:changed"
+	// TopicReplaceTool registers tool even if the name is already registered by other Conn
+	TopicReplaceTool string = "polaris:tool:replace"
+	// TopicToolReplaced is published by the registry to let the previous owner drop the tool
+	TopicToolReplaced string = "polaris:tool:replaced"
+)
+
+const (
+	// RespCodeConflict is RespError.Code of the tool name that is already registered
+	RespCodeConflict string = "conflict"
+)
+
+var (
+	ErrToolAlreadyRegistered = errors.New("tool already registered")
 )
 
 type toolDeclareWithDeadline struct {
@@ -23,10 +39,17 @@ type toolDeclareWithDeadline struct {
 	Deadline time.Time
 }
 
+type toolReplace struct {
+	Declare WrapFunctionDeclaration `json:"declare"`
+	// Owner is the id of Conn that replaces the tool
+	Owner string `json:"owner"`
+}
+
 type (
 	RespError struct {
 		Success bool   `json:"success"`
 		Msg     string `json:"msg"`
+		Code    string `json:"code,omitempty"`
 	}
 )
 
@@ -38,7 +61,10 @@ func (e RespError) Err() error {
 	if e.Success {
 		return nil
 	}
-	return errors.Errorf(e.Msg)
+	if e.Code == RespCodeConflict {
+		return errors.Wrap(ErrToolAlreadyRegistered, e.Msg)
+	}
+	return errors.New(e.Msg)
 }
 
 type Registry struct {
@@ -48,9 +74,23 @@ type Registry struct {
 	ns     *server.Server
 	conn   *Conn
 	tools  map[string]*toolDeclareWithDeadline
+
+	// tenant is the NATS account of the catalog, empty for the global account
+	tenant  string
+	tenants []*Registry
+	// shared are catalogs of shared tenants that are visible from this tenant
+	shared []*Registry
+	// sharing are tenants that see this shared catalog
+	sharing    []*Registry
+	sharedSubs map[string]*nats.Subscription
 }
 
 func (r *Registry) Close() {
+	for _, t := range r.tenants {
+		t.cancel()
+		t.conn.Close()
+	}
+	r.cancel()
 	r.conn.Close()
 	r.ns.Shutdown()
 }
@@ -76,6 +116,16 @@ func (r *Registry) subscribeTool() error {
 		return errors.WithStack(err)
 	}
 
+	if err := subscribeReqResp(
+		r.conn,
+		TopicReplaceTool,
+		JSONEncoder[toolReplace](),
+		JSONEncoder[RespError](),
+		r.handleReplaceTool,
+	); err != nil {
+		return errors.WithStack(err)
+	}
+
 	if err := subscribeResp(
 		r.conn,
 		TopicListTool,
@@ -114,6 +164,19 @@ func (r *Registry) toolGC() {
 		r.mutex.Lock()
 		delete(r.tools, name)
 		r.mutex.Unlock()
+		r.unshareTool(name)
+	}
+	if 0 < len(deadTools) {
+		r.publishToolChanged()
+	}
+}
+
+func (r *Registry) publishToolChanged() {
+	if err := r.conn.nc.Publish(TopicToolChanged, []byte{}); err != nil {
+		log.Printf("WARN: publish %s: %+v", TopicToolChanged, errors.WithStack(err))
+	}
+	for _, t := range r.sharing {
+		t.publishToolChanged()
 	}
 }
 
@@ -132,33 +195,80 @@ func (r *Registry) toolGCLoop() {
 }
 
 func (r *Registry) handleRegisterTool(declare WrapFunctionDeclaration) RespError {
+	if r.sharedConflict(declare.Name) {
+		return RespError{false, fmt.Sprintf("register: %s is shared", declare.Name), RespCodeConflict}
+	}
 	r.mutex.Lock()
-	defer r.mutex.Unlock()
-
 	if _, ok := r.tools[declare.Name]; ok {
-		return RespError{false, fmt.Sprintf("register: %s already registered", declare.Name)}
+		r.mutex.Unlock()
+		return RespError{false, fmt.Sprintf("register: %s", declare.Name), RespCodeConflict}
 	}
 	r.tools[declare.Name] = &toolDeclareWithDeadline{
 		Declare:  declare,
 		Deadline: time.Now().Add(time.Hour),
 	}
+	r.mutex.Unlock()
+
 	log.Printf("INFO: tool %s registered", declare.Name)
-	return RespError{true, "OK"}
+	r.shareTool(declare.Name)
+	r.publishToolChanged()
+	return RespError{true, "OK", ""}
 }
 
-func (r *Registry) handleUnregisterTool(declare WrapFunctionDeclaration) RespError {
+// handleReplaceTool overwrites the declaration, the previous owner drops the tool by TopicToolReplaced
+func (r *Registry) handleReplaceTool(req toolReplace) RespError {
+	if r.sharedConflict(req.Declare.Name) {
+		return RespError{false, fmt.Sprintf("replace: %s is shared", req.Declare.Name), RespCodeConflict}
+	}
 	r.mutex.Lock()
-	defer r.mutex.Unlock()
+	_, replaced := r.tools[req.Declare.Name]
+	r.tools[req.Declare.Name] = &toolDeclareWithDeadline{
+		Declare:  req.Declare,
+		Deadline: time.Now().Add(time.Hour),
+	}
+	r.mutex.Unlock()
 
+	if replaced {
+		data, err := JSONEncoder[toolReplace]().Encode(req)
+		if err != nil {
+			return RespError{false, err.Error(), ""}
+		}
+		if err := r.conn.nc.Publish(TopicToolReplaced, data); err != nil {
+			log.Printf("WARN: publish %s: %+v", TopicToolReplaced, errors.WithStack(err))
+		}
+		log.Printf("INFO: tool %s replaced", req.Declare.Name)
+	} else {
+		log.Printf("INFO: tool %s registered", req.Declare.Name)
+		r.shareTool(req.Declare.Name)
+	}
+	r.publishToolChanged()
+	return RespError{true, "OK", ""}
+}
+
+func (r *Registry) handleUnregisterTool(declare WrapFunctionDeclaration) RespError {
+	r.mutex.Lock()
 	if _, ok := r.tools[declare.Name]; ok != true {
-		return RespError{false, fmt.Sprintf("unregister: %s not found", declare.Name)}
+		r.mutex.Unlock()
+		return RespError{false, fmt.Sprintf("unregister: %s not found", declare.Name), ""}
 	}
 	delete(r.tools, declare.Name)
+	r.mutex.Unlock()
+
 	log.Printf("INFO: tool %s unregistered", declare.Name)
-	return RespError{true, "OK"}
+	r.unshareTool(declare.Name)
+	r.publishToolChanged()
+	return RespError{true, "OK", ""}
 }
 
 func (r *Registry) handleListTool() []WrapFunctionDeclaration {
+	list := r.declarations()
+	for _, shared := range r.shared {
+		list = append(list, shared.declarations()...)
+	}
+	return list
+}
+
+func (r *Registry) declarations() []WrapFunctionDeclaration {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
@@ -169,20 +279,41 @@ func (r *Registry) handleListTool() []WrapFunctionDeclaration {
 	return list
 }
 
+func (r *Registry) hasTool(name string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	_, ok := r.tools[name]
+	return ok
+}
+
 func (r *Registry) handleToolKeepAlive(list []WrapFunctionDeclaration) RespError {
+	added := false
 	for _, d := range list {
+		if r.sharedConflict(d.Name) {
+			continue
+		}
 		r.mutex.Lock()
-		if v, ok := r.tools[d.Name]; ok {
+		v, ok := r.tools[d.Name]
+		if ok {
 			v.Deadline = time.Now().Add(time.Hour)
 		} else {
 			r.tools[d.Name] = &toolDeclareWithDeadline{
 				Declare:  d,
 				Deadline: time.Now().Add(time.Hour),
 			}
+			added = true
 		}
 		r.mutex.Unlock()
+		if ok != true {
+			r.shareTool(d.Name)
+		}
 	}
-	return RespError{true, "OK"}
+	if added {
+		// e.g. registry restarted
+		r.publishToolChanged()
+	}
+	return RespError{true, "OK", ""}
 }
 
 func newRegistry(ns *server.Server, conn *Conn) *Registry {
@@ -194,6 +325,11 @@ func newRegistry(ns *server.Server, conn *Conn) *Registry {
 		ns:     ns,
 		conn:   conn,
 		tools:  make(map[string]*toolDeclareWithDeadline, 0),
+
+		tenants:    make([]*Registry, 0),
+		shared:     make([]*Registry, 0),
+		sharing:    make([]*Registry, 0),
+		sharedSubs: make(map[string]*nats.Subscription),
 	}
 }
 
@@ -255,6 +391,14 @@ func WithClusterAdvertise(advertise string) RegistryClusterOption {
 	}
 }
 
+// WithJetStream enables JetStream of the registry, e.g. as the stream of NewJetStreamAuditSink
+func WithJetStream(storeDir string) RegistryOption {
+	return func(o *server.Options) {
+		o.JetStream = true
+		o.StoreDir = storeDir
+	}
+}
+
 func CreateRegistry(opts ...RegistryOption) (*Registry, error) {
 	o := &server.Options{
 		Debug:  false,
@@ -267,9 +411,15 @@ func CreateRegistry(opts ...RegistryOption) (*Registry, error) {
 	if o.Cluster.PoolSize < 1 {
 		o.Cluster.PoolSize = -1
 	}
+	internalUsers, err := addRegistryUsers(o)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
 	ns := server.New(o)
-	ns.DisableJetStream()
+	if o.JetStream != true {
+		ns.DisableJetStream()
+	}
 	go ns.Start()
 
 	waitRouting := make(chan struct{})
@@ -285,6 +435,7 @@ func CreateRegistry(opts ...RegistryOption) (*Registry, error) {
 	conn, err := Connect(
 		NatsURL(ns.ClientURL()),
 		Name("registry"),
+		ConnectAuth(internalUsers.user(globalAccountName)),
 	)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -295,5 +446,10 @@ func CreateRegistry(opts ...RegistryOption) (*Registry, error) {
 		return nil, errors.WithStack(err)
 	}
 	go r.toolGCLoop()
+
+	if err := r.startTenants(o.Accounts, internalUsers); err != nil {
+		r.Close()
+		return nil, errors.WithStack(err)
+	}
 	return r, nil
 }
@@ -1,12 +1,15 @@
 package polaris
 
 import (
+	"cmp"
 	"context"
 	"iter"
 	"log"
 	"os"
+	"strings"
 	"sync"
 
+	"github.com/nats-io/nuid"
 	"github.com/pkg/errors"
 	"google.golang.org/genai"
 )
@@ -39,15 +42,23 @@ func createSession(ctx context.Context, tc toolConn, rc remoteCall, options ...U
 	if opt.DefaultArgsFunc != nil {
 		rc.setDefaultArgsFunc(opt.DefaultArgsFunc)
 	}
+	if opt.SessionID == "" {
+		opt.SessionID = nuid.Next()
+	}
+	rc.setSession(opt.SessionID, opt.Model)
 
 	remoteTools, err := tc.listTools(opt.UseLocalTool)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	approvals := make(map[string]string)
 	functionDeclarations := make([]*genai.FunctionDeclaration, len(remoteTools))
-	functionNames := make([]string, len(remoteTools))
-	for i, rt := range remoteTools {
+	for i, wt := range remoteTools {
+		if wt.RequiresApproval {
+			approvals[wt.Name] = cmp.Or(wt.ApprovalReason, wt.Description)
+		}
+		rt := wt.ToGenAI()
 		if _, ok := rt.Parameters.Properties["_error"]; ok != true {
 			rt.Parameters.Properties["_error"] = &genai.Schema{
 				Type:        genai.TypeString,
@@ -61,7 +72,6 @@ func createSession(ctx context.Context, tc toolConn, rc remoteCall, options ...U
 			Parameters:  rt.Parameters,
 			Response:    rt.Response,
 		}
-		functionNames[i] = rt.Name
 	}
 
 	client, err := geminiClient(ctx)
@@ -77,26 +87,28 @@ func createSession(ctx context.Context, tc toolConn, rc remoteCall, options ...U
 		},
 	}
 
-	if opt.JSONOutput {
+	responseSchema := (*genai.Schema)(nil)
+	if opt.JSONOutput && opt.OutputSchema != nil {
+		responseSchema = opt.OutputSchema.Schema().ToGenAI()
+	}
+	// JSONOutput && Tools = tool calling first, then JSON output
+	twoPhaseJSON := opt.JSONOutput && opt.JSONOutputWithTools && 0 < len(functionDeclarations)
+	if opt.JSONOutput && twoPhaseJSON != true {
 		config.ResponseMIMEType = "application/json"
-		if opt.OutputSchema != nil {
-			config.ResponseSchema = opt.OutputSchema.Schema().ToGenAI()
-		}
+		config.ResponseSchema = responseSchema
 	}
 	if 0 < len(opt.SystemInstructions) {
 		config.SystemInstruction = genai.NewContentFromParts(opt.SystemInstructions, genai.RoleUser)
 	}
 
-	// JSONOutput && Tools = does not support
-	if 0 < len(functionDeclarations) && opt.JSONOutput != true {
-		config.Tools = []*genai.Tool{{
-			FunctionDeclarations: functionDeclarations,
-		}}
-		config.ToolConfig = &genai.ToolConfig{
-			FunctionCallingConfig: &genai.FunctionCallingConfig{
-				Mode: genai.FunctionCallingConfigModeAuto,
-				//AllowedFunctionNames: functionNames,
-			},
+	// JSONOutput && Tools = does not support without JSONOutputWithTools
+	selector := (*toolSelector)(nil)
+	if 0 < len(functionDeclarations) && (opt.JSONOutput != true || twoPhaseJSON) {
+		if 0 < opt.ToolSelectTopK && opt.ToolSelectTopK < len(functionDeclarations) {
+			selector = newToolSelector(opt.ToolSelectTopK, opt.ToolScorer, functionDeclarations)
+			setTools(config, selector.activeDeclarations())
+		} else {
+			setTools(config, functionDeclarations)
 		}
 	}
 
@@ -111,11 +123,24 @@ func createSession(ctx context.Context, tc toolConn, rc remoteCall, options ...U
 		return nil, errors.WithStack(err)
 	}
 
-	return &LiveSession{ctx, opt, logger, rc, client, chat}, nil
+	return &LiveSession{ctx, opt, logger, rc, client, chat, config, selector, twoPhaseJSON, responseSchema, approvals, new(sync.Mutex)}, nil
+}
+
+func setTools(config *genai.GenerateContentConfig, functionDeclarations []*genai.FunctionDeclaration) {
+	config.Tools = []*genai.Tool{{
+		FunctionDeclarations: functionDeclarations,
+	}}
+	config.ToolConfig = &genai.ToolConfig{
+		FunctionCallingConfig: &genai.FunctionCallingConfig{
+			Mode: genai.FunctionCallingConfigModeAuto,
+		},
+	}
 }
 
+const jsonOutputInstruction string = "Return the final result of the previous steps as JSON following the response schema."
+
 type toolConn interface {
-	listTools(bool) ([]genai.FunctionDeclaration, error)
+	listTools(bool) ([]WrapFunctionDeclaration, error)
 }
 
 var (
@@ -124,7 +149,7 @@ var (
 
 type noToolConn struct{}
 
-func (*noToolConn) listTools(bool) ([]genai.FunctionDeclaration, error) {
+func (*noToolConn) listTools(bool) ([]WrapFunctionDeclaration, error) {
 	return nil, nil
 }
 
@@ -136,12 +161,21 @@ type funcallCtx struct {
 }
 
 type LiveSession struct {
-	ctx     context.Context
-	opt     *UseOption
-	logger  Logger
-	rc      remoteCall
-	client  *genai.Client
-	session *genai.Chat
+	ctx      context.Context
+	opt      *UseOption
+	logger   Logger
+	rc       remoteCall
+	client   *genai.Client
+	session  *genai.Chat
+	config   *genai.GenerateContentConfig
+	selector *toolSelector
+
+	twoPhaseJSON   bool
+	responseSchema *genai.Schema
+
+	// approvals is reason of the tools that require approval
+	approvals     map[string]string
+	approvalMutex *sync.Mutex
 }
 
 func (s *LiveSession) JSONOutput() bool {
@@ -153,13 +187,63 @@ func (s *LiveSession) SendText(values ...string) (iter.Seq2[string, error], erro
 	for i, v := range values {
 		texts[i] = genai.NewPartFromText(v)
 	}
+	if s.selector != nil {
+		if err := s.selector.selectTools(s.ctx, strings.Join(values, "\n")); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		setTools(s.config, s.selector.activeDeclarations())
+	}
 	resp, err := s.session.Send(s.ctx, texts...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if s.twoPhaseJSON {
+		return s.handleJSONOutput(s.handleMsg(resp)), nil
+	}
 	return s.handleMsg(resp), nil
 }
 
+// handleJSONOutput drains tool calling phase, then requests the final answer as JSON
+func (s *LiveSession) handleJSONOutput(toolPhase iter.Seq2[string, error]) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		for _, err := range toolPhase {
+			if err != nil {
+				yield("", errors.WithStack(err))
+				return
+			}
+		}
+
+		tools, toolConfig := s.config.Tools, s.config.ToolConfig
+		s.config.Tools = nil
+		s.config.ToolConfig = nil
+		s.config.ResponseMIMEType = "application/json"
+		s.config.ResponseSchema = s.responseSchema
+		defer func() {
+			s.config.Tools = tools
+			s.config.ToolConfig = toolConfig
+			s.config.ResponseMIMEType = ""
+			s.config.ResponseSchema = nil
+		}()
+
+		resp, err := s.session.Send(s.ctx, genai.NewPartFromText(jsonOutputInstruction))
+		if err != nil {
+			yield("", errors.WithStack(err))
+			return
+		}
+		if len(resp.Candidates) < 1 || resp.Candidates[0].Content == nil {
+			yield("", errors.Errorf("empty JSON output"))
+			return
+		}
+		texts := make([]string, 0, len(resp.Candidates[0].Content.Parts))
+		for _, p := range resp.Candidates[0].Content.Parts {
+			if p.Text != "" && p.Thought != true {
+				texts = append(texts, p.Text)
+			}
+		}
+		yield(strings.Join(texts, ""), nil)
+	}
+}
+
 func (s *LiveSession) handleMsg(genContentResp *genai.GenerateContentResponse) iter.Seq2[string, error] {
 	return func(yield func(string, error) bool) {
 		generate := func(resp *genai.GenerateContentResponse) (*genai.GenerateContentResponse, error) {
@@ -172,7 +256,7 @@ func (s *LiveSession) handleMsg(genContentResp *genai.GenerateContentResponse) i
 				go func(i int, funcall *genai.FunctionCall) {
 					defer wg.Done()
 
-					r, err := s.rc.callFunction(funcall.Name, funcall.Args)
+					r, err := s.callFunction(funcall.Name, funcall.Args)
 					if err != nil {
 						err = errors.Wrapf(err, "name=%s, args=%v", funcall.Name, funcall.Args)
 					}
@@ -206,6 +290,9 @@ func (s *LiveSession) handleMsg(genContentResp *genai.GenerateContentResponse) i
 			if len(funcResults) < 1 {
 				return endContent(), nil
 			}
+			if s.selector != nil {
+				setTools(s.config, s.selector.activeDeclarations())
+			}
 
 			resp2, err := s.session.Send(s.ctx, funcResults...)
 			if err != nil {
@@ -243,6 +330,18 @@ func (s *LiveSession) handleMsg(genContentResp *genai.GenerateContentResponse) i
 	}
 }
 
+func (s *LiveSession) callFunction(name string, args map[string]any) (map[string]any, error) {
+	if s.selector != nil && name == ToolSearchFunctionName {
+		return s.selector.handleSearch(s.ctx, args)
+	}
+	if reason, ok := s.approvals[name]; ok {
+		if denied, ok := s.approve(name, args, reason); ok != true {
+			return denied, nil
+		}
+	}
+	return s.rc.callFunction(name, args)
+}
+
 func endContent() *genai.GenerateContentResponse {
 	return &genai.GenerateContentResponse{
 		Candidates: []*genai.Candidate{{
@@ -0,0 +1,240 @@
+package polaris
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testGeminiRequest struct {
+	Contents []struct {
+		Parts []struct {
+			Text             string         `json:"text"`
+			FunctionResponse map[string]any `json:"functionResponse"`
+		} `json:"parts"`
+	} `json:"contents"`
+	Tools            []map[string]any `json:"tools"`
+	GenerationConfig struct {
+		ResponseMIMEType string         `json:"responseMimeType"`
+		ResponseSchema   map[string]any `json:"responseSchema"`
+	} `json:"generationConfig"`
+}
+
+// testGeminiServer replies a call of tool to the user message, a text to the function response,
+// and jsonOutput to jsonOutputInstruction
+type testGeminiServer struct {
+	mutex    *sync.Mutex
+	requests []testGeminiRequest
+	tool     string
+	output   string
+}
+
+func (s *testGeminiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := testGeminiRequest{}
+	if err := json.Unmarshal(data, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mutex.Lock()
+	s.requests = append(s.requests, req)
+	s.mutex.Unlock()
+
+	part := map[string]any{"functionCall": map[string]any{"name": s.tool, "args": map[string]any{"id": "1"}}}
+	last := req.Contents[len(req.Contents)-1].Parts[0]
+	switch {
+	case last.FunctionResponse != nil:
+		part = map[string]any{"text": "looked up"}
+	case last.Text == jsonOutputInstruction:
+		part = map[string]any{"text": s.output}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"candidates": []any{
+			map[string]any{"content": map[string]any{"role": "model", "parts": []any{part}}},
+		},
+	})
+}
+
+func (s *testGeminiServer) Requests() []testGeminiRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]testGeminiRequest(nil), s.requests...)
+}
+
+func testGemini(t *testing.T, tool, output string) *testGeminiServer {
+	t.Helper()
+
+	s := &testGeminiServer{mutex: new(sync.Mutex), tool: tool, output: output}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	t.Setenv("GOOGLE_GENAI_USE_VERTEXAI", "false")
+	t.Setenv("GOOGLE_API_KEY", "test")
+	t.Setenv("GOOGLE_GEMINI_BASE_URL", ts.URL)
+	return s
+}
+
+func TestGenerateJSONWithTools(t *testing.T) {
+	r, toolConn := testRegistry(t)
+	called := make(chan string, 10)
+	lookup := testOwnerTool("lookup", "owner")
+	lookup.Handler = func(r *ReqCtx) (Resp, error) {
+		called <- r.String("id")
+		return Resp{"owner": "owner"}, nil
+	}
+	if err := toolConn.RegisterTool(lookup); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("caller"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	gemini := testGemini(t, "lookup", `{"owner":"owner"}`)
+	schema := Object{
+		Properties: Properties{
+			"owner": String{Required: true},
+		},
+	}
+	generate, err := conn.GenerateJSON(context.Background(), UseModel("gemini-test"), UseJSONOutput(schema), UseOutputValidation(true))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	for i := 0; i < 2; i += 1 {
+		resp, err := generate("who owns 1?")
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		if resp["owner"] != "owner" {
+			t.Errorf("resp = %v", resp)
+		}
+		if id := <-called; id != "1" {
+			t.Errorf("tool must be called: id=%s", id)
+		}
+	}
+
+	requests := gemini.Requests()
+	if len(requests) != 6 {
+		t.Fatalf("tool call, function response and JSON output for each: %d", len(requests))
+	}
+	for i, req := range requests {
+		jsonOutput := i%3 == 2
+		if jsonOutput {
+			if len(req.Tools) != 0 {
+				t.Errorf("[%d] tools must be removed from JSON output: %v", i, req.Tools)
+			}
+			if req.GenerationConfig.ResponseMIMEType != "application/json" || req.GenerationConfig.ResponseSchema == nil {
+				t.Errorf("[%d] JSON output must request schema: %+v", i, req.GenerationConfig)
+			}
+			continue
+		}
+		// restored after JSON output
+		if len(req.Tools) != 1 {
+			t.Errorf("[%d] tools must be requested: %v", i, req.Tools)
+		}
+		if req.GenerationConfig.ResponseMIMEType != "" || req.GenerationConfig.ResponseSchema != nil {
+			t.Errorf("[%d] tool calling must not request JSON: %+v", i, req.GenerationConfig)
+		}
+	}
+}
+
+func TestGenerateJSONWithToolsValidation(t *testing.T) {
+	r, toolConn := testRegistry(t)
+	if err := toolConn.RegisterTool(testOwnerTool("lookup", "owner")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("caller"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	testGemini(t, "lookup", `{"name":"owner"}`)
+	schema := Object{
+		Properties: Properties{
+			"owner": String{Required: true},
+		},
+	}
+	generate, err := conn.GenerateJSON(context.Background(), UseModel("gemini-test"), UseJSONOutput(schema), UseOutputValidation(true))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if _, err := generate("who owns 1?"); err == nil {
+		t.Errorf("output must be validated")
+	}
+}
+
+func TestUseJSONOutputWithTools(t *testing.T) {
+	r, toolConn := testRegistry(t)
+	if err := toolConn.RegisterTool(testOwnerTool("lookup", "owner")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	conn, err := Connect(NatsURL(r.ns.ClientURL()), Name("caller"))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(conn.Close)
+	testGemini(t, "lookup", `{}`)
+
+	tests := []struct {
+		name       string
+		withTools  bool
+		twoPhase   bool
+		tools      bool
+		jsonConfig bool
+	}{
+		{"with tools", true, true, true, false},
+		{"without tools", false, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := conn.Use(context.Background(), UseModel("gemini-test"), UseJSONOutput(Object{}), UseJSONOutputWithTools(tt.withTools))
+			if err != nil {
+				t.Fatalf("no error: %+v", err)
+			}
+			s := session.(*LiveSession)
+			if s.twoPhaseJSON != tt.twoPhase {
+				t.Errorf("twoPhaseJSON = %v", s.twoPhaseJSON)
+			}
+			if (s.config.Tools != nil) != tt.tools {
+				t.Errorf("tools = %v", s.config.Tools)
+			}
+			if (s.config.ResponseMIMEType == "application/json") != tt.jsonConfig {
+				t.Errorf("ResponseMIMEType = %s", s.config.ResponseMIMEType)
+			}
+		})
+	}
+}
+
+func TestHandleJSONOutputToolPhaseError(t *testing.T) {
+	gemini := testGemini(t, "lookup", `{}`)
+	session, err := Generate(context.Background(), UseModel("gemini-test"), UseJSONOutput(Object{}))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	s := session.(*LiveSession)
+
+	toolPhase := func(yield func(string, error) bool) {
+		yield("", io.ErrUnexpectedEOF)
+	}
+	for _, err := range s.handleJSONOutput(toolPhase) {
+		if err == nil || strings.Contains(err.Error(), io.ErrUnexpectedEOF.Error()) != true {
+			t.Errorf("error of tool phase must be returned: %+v", err)
+		}
+	}
+	if len(gemini.Requests()) != 0 {
+		t.Errorf("JSON output must not be requested after error")
+	}
+}
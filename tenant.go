@@ -0,0 +1,233 @@
+package polaris
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+const (
+	globalAccountName  = server.DEFAULT_GLOBAL_ACCOUNT
+	registryUserPrefix = "polaris-registry-"
+	// maxSharedToolCallTimeout bounds TimeoutHeader of the caller when the call of shared tool is relayed
+	maxSharedToolCallTimeout = 10 * time.Minute
+)
+
+// RegistryTenant is a NATS account that has its own tool catalog,
+// Conn joins the tenant by ConnectAuth with one of Users.
+// Tools and calls are isolated by the account, tools of Shared tenant are listed and callable from every tenant
+type RegistryTenant struct {
+	Name   string
+	Users  []RegistryUser
+	Shared bool
+}
+
+type RegistryUser struct {
+	Username string
+	Password string
+}
+
+// WithTenants creates NATS account for each tenant, clients must authenticate once tenants are configured
+func WithTenants(tenants ...RegistryTenant) RegistryOption {
+	return func(o *server.Options) {
+		for _, t := range tenants {
+			acc := server.NewAccount(t.Name)
+			if t.Shared {
+				// shared tenant is marked by exporting its catalog
+				if err := acc.AddServiceExport(TopicListTool, nil); err != nil {
+					log.Printf("WARN: shared tenant %s: %+v", t.Name, errors.WithStack(err))
+				}
+			}
+			o.Accounts = append(o.Accounts, acc)
+			for _, u := range t.Users {
+				o.Users = append(o.Users, &server.User{
+					Username: u.Username,
+					Password: u.Password,
+					Account:  acc,
+				})
+			}
+		}
+	}
+}
+
+type registryUsers map[string]RegistryUser
+
+func (u registryUsers) user(account string) (string, string) {
+	v := u[account]
+	return v.Username, v.Password
+}
+
+// addRegistryUsers adds users of the registry itself for the global account and each tenant,
+// nothing is added without tenants since the server does not require authentication
+func addRegistryUsers(o *server.Options) (registryUsers, error) {
+	users := registryUsers{}
+	if len(o.Accounts) < 1 {
+		return users, nil
+	}
+
+	accounts := append([]*server.Account{nil}, o.Accounts...)
+	for _, acc := range accounts {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		name := globalAccountName
+		if acc != nil {
+			name = acc.Name
+		}
+		u := RegistryUser{registryUserPrefix + name, hex.EncodeToString(buf)}
+		users[name] = u
+		o.Users = append(o.Users, &server.User{
+			Username: u.Username,
+			Password: u.Password,
+			Account:  acc,
+		})
+	}
+	return users, nil
+}
+
+// startTenants starts registry of each tenant, non-shared tenants see catalogs of shared tenants
+func (r *Registry) startTenants(accounts []*server.Account, users registryUsers) error {
+	for _, acc := range accounts {
+		conn, err := Connect(
+			NatsURL(r.ns.ClientURL()),
+			Name(registryUserPrefix+acc.Name),
+			ConnectAuth(users.user(acc.Name)),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "tenant %s", acc.Name)
+		}
+		t := newRegistry(r.ns, conn)
+		t.tenant = acc.Name
+		r.tenants = append(r.tenants, t)
+	}
+
+	sharedTenants := make([]*Registry, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		acc, err := r.ns.LookupAccount(t.tenant)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if acc.IsExportService(TopicListTool) {
+			sharedTenants = append(sharedTenants, t)
+		}
+	}
+	// shared tenants don't see each other, otherwise notifications are relayed back and forth
+	for _, t := range r.tenants {
+		if slices.Contains(sharedTenants, t) {
+			continue
+		}
+		for _, shared := range sharedTenants {
+			t.shared = append(t.shared, shared)
+			shared.sharing = append(shared.sharing, t)
+		}
+	}
+
+	for _, t := range r.tenants {
+		if err := t.subscribeTool(); err != nil {
+			return errors.Wrapf(err, "tenant %s", t.tenant)
+		}
+		go t.toolGCLoop()
+	}
+	return nil
+}
+
+// sharedConflict reports the name is used by shared catalog that this tenant sees,
+// or by a tenant or other shared catalog that the tenants seeing this shared catalog see
+func (r *Registry) sharedConflict(name string) bool {
+	for _, shared := range r.shared {
+		if shared.hasTool(name) {
+			return true
+		}
+	}
+	for _, t := range r.sharing {
+		if t.hasTool(name) {
+			return true
+		}
+		for _, other := range t.shared {
+			if other != r && other.hasTool(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shareTool relays calls of the tool from sharing tenants to this shared tenant
+func (r *Registry) shareTool(name string) {
+	for _, t := range r.sharing {
+		if err := t.relaySharedTool(r, name); err != nil {
+			log.Printf("WARN: share tool %s to %s: %+v", name, t.tenant, err)
+		}
+	}
+}
+
+func (r *Registry) unshareTool(name string) {
+	for _, t := range r.sharing {
+		t.mutex.Lock()
+		sub, ok := t.sharedSubs[name]
+		delete(t.sharedSubs, name)
+		t.mutex.Unlock()
+		if ok {
+			if err := sub.Unsubscribe(); err != nil {
+				log.Printf("WARN: unshare tool %s from %s: %+v", name, t.tenant, errors.WithStack(err))
+			}
+		}
+	}
+}
+
+// relaySharedTool subscribes the tool in this tenant and forwards requests to the shared tenant,
+// accounts don't see subjects of other accounts
+func (r *Registry) relaySharedTool(shared *Registry, name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.sharedSubs[name]; ok {
+		return nil
+	}
+	topic := tooltopic(name)
+	sub, err := r.conn.nc.Subscribe(topic, func(msg *nats.Msg) {
+		go func() {
+			ctx, cancel := context.WithTimeout(r.conn.ctx, r.relayTimeout(msg.Header))
+			defer cancel()
+
+			// header carries identity of the caller
+			data := []byte(nil)
+			resp, err := shared.conn.nc.RequestMsgWithContext(ctx, &nats.Msg{Subject: topic, Header: msg.Header, Data: msg.Data})
+			if err != nil {
+				log.Printf("WARN: relay %s: %+v", name, errors.WithStack(err))
+				data, _ = JSONEncoder[map[string]any]().Encode(map[string]any{
+					"_error": fmt.Sprintf("relay %s to shared tenant %s: %s", name, shared.tenant, err.Error()),
+				})
+			} else {
+				data = resp.Data
+			}
+			if err := msg.Respond(data); err != nil {
+				log.Printf("WARN: respond: %+v", errors.WithStack(err))
+			}
+		}()
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	r.conn.nc.Flush()
+	r.sharedSubs[name] = sub
+	return nil
+}
+
+// relayTimeout is TimeoutHeader of the caller up to maxSharedToolCallTimeout, defaults to ReqTimeout of the registry
+func (r *Registry) relayTimeout(header nats.Header) time.Duration {
+	timeout, err := time.ParseDuration(header.Get(TimeoutHeader))
+	if err != nil || timeout <= 0 {
+		return r.conn.opt.ReqTimeout
+	}
+	return min(timeout, maxSharedToolCallTimeout)
+}
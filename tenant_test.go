@@ -0,0 +1,221 @@
+package polaris
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+func TestRegistryTenants(t *testing.T) {
+	r, err := CreateRegistry(
+		WithBind("127.0.0.1", -1),
+		WithTenants(
+			RegistryTenant{Name: "teamA", Users: []RegistryUser{{"alice", "alice-pass"}}},
+			RegistryTenant{Name: "teamB", Users: []RegistryUser{{"bob", "bob-pass"}}},
+			RegistryTenant{Name: "common", Users: []RegistryUser{{"ops", "ops-pass"}}, Shared: true},
+		),
+	)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(r.Close)
+
+	connect := func(t *testing.T, user, password string) *Conn {
+		conn, err := Connect(NatsURL(r.ns.ClientURL()), Name(user), ConnectAuth(user, password))
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		t.Cleanup(conn.Close)
+		return conn
+	}
+	connA := connect(t, "alice", "alice-pass")
+	connB := connect(t, "bob", "bob-pass")
+	connShared := connect(t, "ops", "ops-pass")
+
+	if err := connA.RegisterTool(testOwnerTool("search", "A")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	// same name in other tenant is not a collision
+	if err := connB.RegisterTool(testOwnerTool("search", "B")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if err := connShared.RegisterTool(testOwnerTool("status", "shared")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	t.Run("list", func(tt *testing.T) {
+		waitRegistryTools(tt, connA, func(names []string) bool {
+			slices.Sort(names)
+			return slices.Equal(names, []string{"search", "status"})
+		})
+		waitRegistryTools(tt, connShared, func(names []string) bool {
+			return slices.Equal(names, []string{"status"})
+		})
+	})
+	t.Run("call", func(tt *testing.T) {
+		tests := []struct {
+			name   string
+			conn   *Conn
+			tool   string
+			expect string
+		}{
+			{"own tool A", connA, "search", "A"},
+			{"own tool B", connB, "search", "B"},
+			{"shared from A", connA, "status", "shared"},
+			{"shared from B", connB, "status", "shared"},
+		}
+		for _, test := range tests {
+			tt.Run(test.name, func(ttt *testing.T) {
+				resp, err := test.conn.Call(context.Background(), test.tool, Req{})
+				if err != nil {
+					ttt.Fatalf("no error: %+v", err)
+				}
+				if resp["owner"] != test.expect {
+					ttt.Errorf("actual=%v expect=%s", resp, test.expect)
+				}
+			})
+		}
+	})
+	t.Run("isolated", func(tt *testing.T) {
+		if _, err := connShared.Call(context.Background(), "search", Req{}); err == nil {
+			tt.Errorf("tool of other tenant must not be callable")
+		}
+	})
+	t.Run("shared collision", func(tt *testing.T) {
+		err := connA.RegisterTool(testOwnerTool("status", "A"))
+		if errors.Is(err, ErrToolAlreadyRegistered) != true {
+			tt.Errorf("shared name must be conflict: %+v", err)
+		}
+		err = connShared.RegisterTool(testOwnerTool("search", "shared"))
+		if errors.Is(err, ErrToolAlreadyRegistered) != true {
+			tt.Errorf("name of sharing tenant must be conflict: %+v", err)
+		}
+	})
+	t.Run("unshare", func(tt *testing.T) {
+		if err := connShared.UnregisterTools(); err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		waitRegistryTools(tt, connA, func(names []string) bool {
+			return slices.Equal(names, []string{"search"})
+		})
+		if _, err := connA.Call(context.Background(), "status", Req{}); err == nil {
+			tt.Errorf("unregistered shared tool must not be callable")
+		}
+	})
+	t.Run("unauthorized", func(tt *testing.T) {
+		if _, err := Connect(NatsURL(r.ns.ClientURL()), Name("anonymous")); err == nil {
+			tt.Errorf("connection without tenant must be rejected")
+		}
+	})
+}
+
+func TestRegistryMultipleSharedTenants(t *testing.T) {
+	r, err := CreateRegistry(
+		WithBind("127.0.0.1", -1),
+		WithTenants(
+			RegistryTenant{Name: "teamA", Users: []RegistryUser{{"alice", "alice-pass"}}},
+			RegistryTenant{Name: "ops", Users: []RegistryUser{{"ops", "ops-pass"}}, Shared: true},
+			RegistryTenant{Name: "infra", Users: []RegistryUser{{"infra", "infra-pass"}}, Shared: true},
+		),
+	)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(r.Close)
+
+	connect := func(t *testing.T, user, password string) *Conn {
+		conn, err := Connect(NatsURL(r.ns.ClientURL()), Name(user), ConnectAuth(user, password), RequestTimeout(5*time.Second))
+		if err != nil {
+			t.Fatalf("no error: %+v", err)
+		}
+		t.Cleanup(conn.Close)
+		return conn
+	}
+	connA := connect(t, "alice", "alice-pass")
+	connOps := connect(t, "ops", "ops-pass")
+	connInfra := connect(t, "infra", "infra-pass")
+
+	if err := connOps.RegisterTool(testOwnerTool("status", "ops")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if err := connInfra.RegisterTool(testOwnerTool("deploy", "infra")); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	t.Run("list", func(tt *testing.T) {
+		waitRegistryTools(tt, connA, func(names []string) bool {
+			slices.Sort(names)
+			return slices.Equal(names, []string{"deploy", "status"})
+		})
+		waitRegistryTools(tt, connOps, func(names []string) bool {
+			return slices.Equal(names, []string{"status"})
+		})
+	})
+	t.Run("call", func(tt *testing.T) {
+		for tool, owner := range map[string]string{"status": "ops", "deploy": "infra"} {
+			resp, err := connA.Call(context.Background(), tool, Req{})
+			if err != nil {
+				tt.Fatalf("no error: %+v", err)
+			}
+			if resp["owner"] != owner {
+				tt.Errorf("actual=%v expect=%s", resp, owner)
+			}
+		}
+	})
+	t.Run("shared collision", func(tt *testing.T) {
+		err := connInfra.RegisterTool(testOwnerTool("status", "infra"))
+		if errors.Is(err, ErrToolAlreadyRegistered) != true {
+			tt.Errorf("name of other shared tenant must be conflict: %+v", err)
+		}
+	})
+	t.Run("relay error", func(tt *testing.T) {
+		// declaration without handler, relayed request has no responders
+		if err := connOps.registerDeclaration(testOwnerTool("orphan", "ops").FunctionDeclaration()); err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		waitRegistryTools(tt, connA, func(names []string) bool {
+			return slices.Contains(names, "orphan")
+		})
+		start := time.Now()
+		resp, err := connA.Call(context.Background(), "orphan", Req{})
+		if err != nil {
+			tt.Fatalf("relay must respond error: %+v", err)
+		}
+		if _, ok := resp["_error"]; ok != true {
+			tt.Errorf("resp = %v", resp)
+		}
+		if 5*time.Second <= time.Since(start) {
+			tt.Errorf("caller must not wait for timeout")
+		}
+	})
+}
+
+func TestRegistryRelayTimeout(t *testing.T) {
+	r := &Registry{conn: &Conn{opt: &ConnectOption{ReqTimeout: 5 * time.Second}}}
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{"caller timeout", "30s", 30 * time.Second},
+		{"no header", "", 5 * time.Second},
+		{"invalid", "soon", 5 * time.Second},
+		{"negative", "-1s", 5 * time.Second},
+		{"too long", "24h", maxSharedToolCallTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := nats.Header{}
+			if tt.header != "" {
+				header.Set(TimeoutHeader, tt.header)
+			}
+			if got := r.relayTimeout(header); got != tt.want {
+				t.Errorf("timeout = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package polaris
 
-import "google.golang.org/genai"
+import (
+	"slices"
+
+	"google.golang.org/genai"
+)
 
 type NullableType string
 
@@ -20,6 +24,43 @@ func (n NullableType) Nullable() *bool {
 	}
 }
 
+type ValidationMode string
+
+const (
+	// ValidationInherit uses ConnectOption.ResponseValidation, does not validate without it
+	ValidationInherit ValidationMode = ""
+	// ValidationNone does not validate, even if ConnectOption.ResponseValidation is set
+	ValidationNone ValidationMode = "none"
+	// ValidationWarn logs violations and replies as is
+	ValidationWarn ValidationMode = "warn"
+	// ValidationStrict replies violations as _error
+	ValidationStrict ValidationMode = "strict"
+)
+
+func intPtrToFloat64(v *int) *float64 {
+	if v == nil {
+		return nil
+	}
+	return genai.Ptr(float64(*v))
+}
+
+func intPtrToInt64(v *int) *int64 {
+	if v == nil {
+		return nil
+	}
+	return genai.Ptr(int64(*v))
+}
+
+// defaultValue returns nil for zero value,
+// zero value is used as fallback in ReqCtx, no need to advertise it
+func defaultValue[T comparable](v T) any {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v
+}
+
 type (
 	ToolHandler  func(*ReqCtx) (Resp, error)
 	ErrorHandler func(error)
@@ -32,6 +73,22 @@ type Tool struct {
 	Response     Object
 	Handler      ToolHandler
 	ErrorHandler ErrorHandler
+
+	// SkipArgsValidation disables validation of arguments against Parameters
+	SkipArgsValidation bool
+	// ResponseValidation validates Resp against Response, defaults to ConnectOption.ResponseValidation
+	ResponseValidation ValidationMode
+
+	// Roles allows callers that have one of them, empty allows every caller
+	Roles []string
+	// Scopes allows callers that have all of them, empty allows every caller
+	Scopes []string
+
+	// RequiresApproval makes LiveSession and MCPServer ask the Approver before calling the tool,
+	// Conn.Call is not asked
+	RequiresApproval bool
+	// ApprovalReason is shown to the operator, defaults to Description
+	ApprovalReason string
 }
 
 func (t Tool) FunctionDeclaration() WrapFunctionDeclaration {
@@ -40,6 +97,9 @@ func (t Tool) FunctionDeclaration() WrapFunctionDeclaration {
 		Description: t.Description,
 		Parameters:  t.Parameters.Schema(),
 		Response:    t.Response.Schema(),
+
+		RequiresApproval: t.RequiresApproval,
+		ApprovalReason:   t.ApprovalReason,
 	}
 }
 
@@ -62,18 +122,30 @@ var (
 	_ TypeDef = Float{}
 	_ TypeDef = String{}
 	_ TypeDef = Bool{}
+	_ TypeDef = AnyOf{}
+	_ TypeDef = OneOf{}
+	_ TypeDef = Ref{}
+	_ TypeDef = Any{}
 )
 
 type Properties map[string]TypeDef
 
+// Definitions are named schemas referred by Ref
+type Definitions map[string]TypeDef
+
 //	*genai.Schema{
 //	  Type:        genai.TypeObject,
 //	  Description: "...",
 //	  Properties:  map[string]*genai.Schema{...},
 //	}
+//
+// Defs is emitted as $defs, usually set on Tool.Parameters/Response
 type Object struct {
+	Title       string
 	Description string
 	Properties  Properties
+	Defs        Definitions
+	Example     any
 	Required    bool
 	Nullable    NullableType
 }
@@ -87,11 +159,23 @@ func (o Object) Schema() *WrapSchema {
 			requiredKeys = append(requiredKeys, k)
 		}
 	}
+	// stable order for comparing declarations
+	slices.Sort(requiredKeys)
+	defs := map[string]*WrapSchema(nil)
+	if 0 < len(o.Defs) {
+		defs = make(map[string]*WrapSchema, len(o.Defs))
+		for k, v := range o.Defs {
+			defs[k] = v.Schema()
+		}
+	}
 	return &WrapSchema{
 		Type:        string(genai.TypeObject),
+		Title:       o.Title,
 		Description: o.Description,
 		Properties:  properties,
 		Required:    requiredKeys,
+		Defs:        defs,
+		Example:     o.Example,
 		Nullable:    o.Nullable.Nullable(),
 	}
 }
@@ -107,8 +191,11 @@ func (o Object) IsRequired() bool {
 //	}
 type (
 	Array struct {
+		Title       string
 		Description string
 		Items       TypeDef
+		MinItems    *int
+		MaxItems    *int
 		Required    bool
 		Nullable    NullableType
 	}
@@ -122,8 +209,11 @@ type (
 	//    },
 	//	}
 	IntArray struct {
+		Title           string
 		Description     string
 		ItemDescription string
+		MinItems        *int
+		MaxItems        *int
 		Required        bool
 		Nullable        NullableType
 	}
@@ -137,8 +227,11 @@ type (
 	//    },
 	//	}
 	FloatArray struct {
+		Title           string
 		Description     string
 		ItemDescription string
+		MinItems        *int
+		MaxItems        *int
 		Required        bool
 		Nullable        NullableType
 	}
@@ -152,8 +245,11 @@ type (
 	//    },
 	//	}
 	StringArray struct {
+		Title           string
 		Description     string
 		ItemDescription string
+		MinItems        *int
+		MaxItems        *int
 		Required        bool
 		Nullable        NullableType
 	}
@@ -167,8 +263,11 @@ type (
 	//    },
 	//	}
 	BoolArray struct {
+		Title           string
 		Description     string
 		ItemDescription string
+		MinItems        *int
+		MaxItems        *int
 		Required        bool
 		Nullable        NullableType
 	}
@@ -184,8 +283,11 @@ type (
 	//    },
 	//	}
 	ObjectArray struct {
+		Title           string
 		Description     string
 		ItemDescription string
+		MinItems        *int
+		MaxItems        *int
 		Required        bool
 		Nullable        NullableType
 		Items           Properties
@@ -195,8 +297,11 @@ type (
 func (a Array) Schema() *WrapSchema {
 	return &WrapSchema{
 		Type:        string(genai.TypeArray),
+		Title:       a.Title,
 		Description: a.Description,
 		Items:       a.Items.Schema(),
+		MinItems:    intPtrToInt64(a.MinItems),
+		MaxItems:    intPtrToInt64(a.MaxItems),
 		Nullable:    a.Nullable.Nullable(),
 	}
 }
@@ -208,7 +313,10 @@ func (a Array) IsRequired() bool {
 func (ia IntArray) Schema() *WrapSchema {
 	return &WrapSchema{
 		Type:        string(genai.TypeArray),
+		Title:       ia.Title,
 		Description: ia.Description,
+		MinItems:    intPtrToInt64(ia.MinItems),
+		MaxItems:    intPtrToInt64(ia.MaxItems),
 		Items: &WrapSchema{
 			Type:        string(genai.TypeInteger),
 			Description: ia.ItemDescription,
@@ -224,7 +332,10 @@ func (ia IntArray) IsRequired() bool {
 func (fa FloatArray) Schema() *WrapSchema {
 	return &WrapSchema{
 		Type:        string(genai.TypeArray),
+		Title:       fa.Title,
 		Description: fa.Description,
+		MinItems:    intPtrToInt64(fa.MinItems),
+		MaxItems:    intPtrToInt64(fa.MaxItems),
 		Items: &WrapSchema{
 			Type:        string(genai.TypeNumber),
 			Description: fa.ItemDescription,
@@ -240,7 +351,10 @@ func (fa FloatArray) IsRequired() bool {
 func (sa StringArray) Schema() *WrapSchema {
 	return &WrapSchema{
 		Type:        string(genai.TypeArray),
+		Title:       sa.Title,
 		Description: sa.Description,
+		MinItems:    intPtrToInt64(sa.MinItems),
+		MaxItems:    intPtrToInt64(sa.MaxItems),
 		Items: &WrapSchema{
 			Type:        string(genai.TypeString),
 			Description: sa.ItemDescription,
@@ -256,7 +370,10 @@ func (sa StringArray) IsRequired() bool {
 func (ba BoolArray) Schema() *WrapSchema {
 	return &WrapSchema{
 		Type:        string(genai.TypeArray),
+		Title:       ba.Title,
 		Description: ba.Description,
+		MinItems:    intPtrToInt64(ba.MinItems),
+		MaxItems:    intPtrToInt64(ba.MaxItems),
 		Items: &WrapSchema{
 			Type:        string(genai.TypeBoolean),
 			Description: ba.ItemDescription,
@@ -278,9 +395,13 @@ func (oa ObjectArray) Schema() *WrapSchema {
 			requiredKeys = append(requiredKeys, k)
 		}
 	}
+	slices.Sort(requiredKeys)
 	return &WrapSchema{
 		Type:        string(genai.TypeArray),
+		Title:       oa.Title,
 		Description: oa.Description,
+		MinItems:    intPtrToInt64(oa.MinItems),
+		MaxItems:    intPtrToInt64(oa.MaxItems),
 		Items: &WrapSchema{
 			Type:        string(genai.TypeObject),
 			Description: oa.ItemDescription,
@@ -305,6 +426,8 @@ type (
 	IntEnum struct {
 		Description string
 		Values      []string
+		Default     int
+		Example     any
 		Required    bool
 		Nullable    NullableType
 	}
@@ -318,6 +441,8 @@ type (
 	StringEnum struct {
 		Description string
 		Values      []string
+		Default     string
+		Example     any
 		Required    bool
 		Nullable    NullableType
 	}
@@ -329,6 +454,8 @@ func (ie IntEnum) Schema() *WrapSchema {
 		Description: ie.Description,
 		Enum:        ie.Values,
 		Format:      "enum",
+		Default:     defaultValue(ie.Default),
+		Example:     ie.Example,
 		Nullable:    ie.Nullable.Nullable(),
 	}
 }
@@ -343,6 +470,8 @@ func (se StringEnum) Schema() *WrapSchema {
 		Description: se.Description,
 		Enum:        se.Values,
 		Format:      "enum",
+		Default:     defaultValue(se.Default),
+		Example:     se.Example,
 		Nullable:    se.Nullable.Nullable(),
 	}
 }
@@ -356,8 +485,13 @@ func (se StringEnum) IsRequired() bool {
 //	  Description: "...",
 //	}
 type Int struct {
+	Title       string
 	Description string
 	Default     int
+	Example     any
+	Minimum     *int
+	Maximum     *int
+	Format      string
 	Required    bool
 	Nullable    NullableType
 }
@@ -365,9 +499,14 @@ type Int struct {
 func (i Int) Schema() *WrapSchema {
 	return &WrapSchema{
 		Type:        string(genai.TypeInteger),
+		Title:       i.Title,
 		Description: i.Description,
-		//Default:     i.Default,
-		Nullable: i.Nullable.Nullable(),
+		Default:     defaultValue(i.Default),
+		Example:     i.Example,
+		Format:      i.Format,
+		Minimum:     intPtrToFloat64(i.Minimum),
+		Maximum:     intPtrToFloat64(i.Maximum),
+		Nullable:    i.Nullable.Nullable(),
 	}
 }
 
@@ -380,8 +519,13 @@ func (i Int) IsRequired() bool {
 //	  Description: "...",
 //	}
 type Float struct {
+	Title       string
 	Description string
 	Default     float64
+	Example     any
+	Minimum     *float64
+	Maximum     *float64
+	Format      string
 	Required    bool
 	Nullable    NullableType
 }
@@ -389,9 +533,14 @@ type Float struct {
 func (f Float) Schema() *WrapSchema {
 	return &WrapSchema{
 		Type:        string(genai.TypeNumber),
+		Title:       f.Title,
 		Description: f.Description,
-		//Default:     f.Default,
-		Nullable: f.Nullable.Nullable(),
+		Default:     defaultValue(f.Default),
+		Example:     f.Example,
+		Format:      f.Format,
+		Minimum:     f.Minimum,
+		Maximum:     f.Maximum,
+		Nullable:    f.Nullable.Nullable(),
 	}
 }
 
@@ -403,9 +552,17 @@ func (f Float) IsRequired() bool {
 //	  Type:       genai.TypeString,
 //	  Description "...",
 //	}
+//
+// Format "date-time", "date", "time", "email" and "uuid" are checked in Validate
 type String struct {
+	Title       string
 	Description string
 	Default     string
+	Example     any
+	MinLength   *int
+	MaxLength   *int
+	Pattern     string
+	Format      string
 	Required    bool
 	Nullable    NullableType
 }
@@ -413,9 +570,15 @@ type String struct {
 func (s String) Schema() *WrapSchema {
 	return &WrapSchema{
 		Type:        string(genai.TypeString),
+		Title:       s.Title,
 		Description: s.Description,
-		//Default:     s.Default,
-		Nullable: s.Nullable.Nullable(),
+		Default:     defaultValue(s.Default),
+		Example:     s.Example,
+		Format:      s.Format,
+		MinLength:   intPtrToInt64(s.MinLength),
+		MaxLength:   intPtrToInt64(s.MaxLength),
+		Pattern:     s.Pattern,
+		Nullable:    s.Nullable.Nullable(),
 	}
 }
 
@@ -428,8 +591,10 @@ func (s String) IsRequired() bool {
 //	  Description: "...",
 //	}
 type Bool struct {
+	Title       string
 	Description string
 	Default     bool
+	Example     any
 	Required    bool
 	Nullable    NullableType
 }
@@ -437,12 +602,125 @@ type Bool struct {
 func (b Bool) Schema() *WrapSchema {
 	return &WrapSchema{
 		Type:        string(genai.TypeBoolean),
+		Title:       b.Title,
 		Description: b.Description,
-		//Default:     b.Default,
-		Nullable: b.Nullable.Nullable(),
+		Default:     defaultValue(b.Default),
+		Example:     b.Example,
+		Nullable:    b.Nullable.Nullable(),
 	}
 }
 
 func (b Bool) IsRequired() bool {
 	return b.Required
 }
+
+type (
+	//	*genai.Schema{
+	//	  Description: "...",
+	//	  AnyOf:       []*genai.Schema{...},
+	//	}
+	AnyOf struct {
+		Description string
+		Variants    []TypeDef
+		Required    bool
+		Nullable    NullableType
+	}
+
+	// OneOf is sent as AnyOf to genai, exactly one variant must match in Validate
+	//
+	//	*genai.Schema{
+	//	  Description: "...",
+	//	  AnyOf:       []*genai.Schema{...},
+	//	}
+	OneOf struct {
+		Description string
+		Variants    []TypeDef
+		Required    bool
+		Nullable    NullableType
+	}
+)
+
+func variantSchemas(variants []TypeDef) []*WrapSchema {
+	schemas := make([]*WrapSchema, len(variants))
+	for i, v := range variants {
+		schemas[i] = v.Schema()
+	}
+	return schemas
+}
+
+func (a AnyOf) Schema() *WrapSchema {
+	return &WrapSchema{
+		Description: a.Description,
+		AnyOf:       variantSchemas(a.Variants),
+		Nullable:    a.Nullable.Nullable(),
+	}
+}
+
+func (a AnyOf) IsRequired() bool {
+	return a.Required
+}
+
+func (o OneOf) Schema() *WrapSchema {
+	return &WrapSchema{
+		Description: o.Description,
+		OneOf:       variantSchemas(o.Variants),
+		Nullable:    o.Nullable.Nullable(),
+	}
+}
+
+func (o OneOf) IsRequired() bool {
+	return o.Required
+}
+
+// Ref refers to a schema in Definitions by name, recursive(tree-shaped) schema can be described
+//
+//	{"$ref": "#/$defs/Name"}
+//
+// genai does not support $ref, it is expanded up to DefaultRefExpandDepth in ToGenAI
+type Ref struct {
+	Name        string
+	Description string
+	Required    bool
+	Nullable    NullableType
+}
+
+func (r Ref) Schema() *WrapSchema {
+	nullable := (*bool)(nil)
+	if r.Nullable != "" {
+		// otherwise nullable of the referred schema is used
+		nullable = r.Nullable.Nullable()
+	}
+	return &WrapSchema{
+		Ref:         refPrefix + r.Name,
+		Description: r.Description,
+		Nullable:    nullable,
+	}
+}
+
+func (r Ref) IsRequired() bool {
+	return r.Required
+}
+
+// Any accepts a value of any type, e.g. JSON Schema that has no type: {}
+//
+//	*genai.Schema{
+//	  Description: "...",
+//	}
+type Any struct {
+	Title       string
+	Description string
+	Example     any
+	Required    bool
+}
+
+func (a Any) Schema() *WrapSchema {
+	return &WrapSchema{
+		Title:       a.Title,
+		Description: a.Description,
+		Example:     a.Example,
+	}
+}
+
+func (a Any) IsRequired() bool {
+	return a.Required
+}
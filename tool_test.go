@@ -1,6 +1,7 @@
 package polaris
 
 import (
+	"encoding/json"
 	"reflect"
 	"slices"
 	"testing"
@@ -660,3 +661,212 @@ func TestIsRequired(t *testing.T) {
 		})
 	}
 }
+
+func TestSchemaConstraints(t *testing.T) {
+	t.Run("Int", func(tt *testing.T) {
+		schema := Int{Title: "port", Minimum: genai.Ptr(1), Maximum: genai.Ptr(65535)}.Schema().ToGenAI()
+		if schema.Title != "port" || *schema.Minimum != 1 || *schema.Maximum != 65535 {
+			tt.Errorf("Int.Schema() = %+v", schema)
+		}
+	})
+	t.Run("Float", func(tt *testing.T) {
+		schema := Float{Minimum: genai.Ptr(0.5), Maximum: genai.Ptr(1.5), Format: "double"}.Schema().ToGenAI()
+		if *schema.Minimum != 0.5 || *schema.Maximum != 1.5 || schema.Format != "double" {
+			tt.Errorf("Float.Schema() = %+v", schema)
+		}
+	})
+	t.Run("String", func(tt *testing.T) {
+		schema := String{MinLength: genai.Ptr(1), MaxLength: genai.Ptr(8), Pattern: "^[a-z]+$", Format: "date-time"}.Schema().ToGenAI()
+		if *schema.MinLength != 1 || *schema.MaxLength != 8 || schema.Pattern != "^[a-z]+$" || schema.Format != "date-time" {
+			tt.Errorf("String.Schema() = %+v", schema)
+		}
+	})
+	t.Run("Arrays", func(tt *testing.T) {
+		defs := []TypeDef{
+			Array{Items: String{}, MinItems: genai.Ptr(1), MaxItems: genai.Ptr(3)},
+			IntArray{MinItems: genai.Ptr(1), MaxItems: genai.Ptr(3)},
+			FloatArray{MinItems: genai.Ptr(1), MaxItems: genai.Ptr(3)},
+			StringArray{MinItems: genai.Ptr(1), MaxItems: genai.Ptr(3)},
+			BoolArray{MinItems: genai.Ptr(1), MaxItems: genai.Ptr(3)},
+			ObjectArray{MinItems: genai.Ptr(1), MaxItems: genai.Ptr(3)},
+		}
+		for _, d := range defs {
+			schema := d.Schema().ToGenAI()
+			if *schema.MinItems != 1 || *schema.MaxItems != 3 {
+				tt.Errorf("%T.Schema() = %+v", d, schema)
+			}
+		}
+	})
+}
+
+func TestSchemaDefault(t *testing.T) {
+	t.Run("zero value is not advertised", func(tt *testing.T) {
+		defs := []TypeDef{Int{}, Float{}, String{}, Bool{}, IntEnum{}, StringEnum{}}
+		for _, d := range defs {
+			if v := d.Schema().Default; v != nil {
+				tt.Errorf("%T.Schema().Default = %v, want nil", d, v)
+			}
+		}
+	})
+	t.Run("default and example", func(tt *testing.T) {
+		tests := []struct {
+			def         TypeDef
+			wantDefault any
+			wantExample any
+		}{
+			{Int{Default: 10, Example: 20}, 10, 20},
+			{Float{Default: 0.5, Example: 1.5}, 0.5, 1.5},
+			{String{Default: "foo", Example: "bar"}, "foo", "bar"},
+			{Bool{Default: true, Example: false}, true, false},
+			{IntEnum{Values: []string{"1", "2"}, Default: 2}, 2, nil},
+			{StringEnum{Values: []string{"a", "b"}, Default: "b"}, "b", nil},
+		}
+		for _, v := range tests {
+			schema := v.def.Schema().ToGenAI()
+			if schema.Default != v.wantDefault {
+				tt.Errorf("%T.Default = %v, want %v", v.def, schema.Default, v.wantDefault)
+			}
+			if schema.Example != v.wantExample {
+				tt.Errorf("%T.Example = %v, want %v", v.def, schema.Example, v.wantExample)
+			}
+		}
+	})
+	t.Run("integer type is preserved over JSON", func(tt *testing.T) {
+		data, err := json.Marshal(Int{Default: 10, Example: 3}.Schema())
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		w := &WrapSchema{}
+		if err := json.Unmarshal(data, w); err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		schema := w.ToGenAI()
+		if v, ok := schema.Default.(int64); ok != true || v != 10 {
+			tt.Errorf("Default = %T(%v), want int64(10)", schema.Default, schema.Default)
+		}
+		if v, ok := schema.Example.(int64); ok != true || v != 3 {
+			tt.Errorf("Example = %T(%v), want int64(3)", schema.Example, schema.Example)
+		}
+	})
+}
+
+func TestUnion(t *testing.T) {
+	t.Run("AnyOf", func(tt *testing.T) {
+		hosts := AnyOf{
+			Description: "host name or list of hosts",
+			Variants: []TypeDef{
+				String{Description: "host name"},
+				StringArray{Description: "list of hosts"},
+			},
+			Required: true,
+		}
+		schema := hosts.Schema().ToGenAI()
+		if schema.Description != "host name or list of hosts" {
+			tt.Errorf("Description = %s", schema.Description)
+		}
+		if len(schema.AnyOf) != 2 {
+			tt.Fatalf("len(AnyOf) = %d, want 2", len(schema.AnyOf))
+		}
+		if schema.AnyOf[0].Type != genai.TypeString || schema.AnyOf[1].Type != genai.TypeArray {
+			tt.Errorf("AnyOf = %v, %v", schema.AnyOf[0].Type, schema.AnyOf[1].Type)
+		}
+		if hosts.IsRequired() != true {
+			tt.Errorf("IsRequired() = false")
+		}
+	})
+	t.Run("OneOf", func(tt *testing.T) {
+		oneOf := OneOf{
+			Variants: []TypeDef{Int{}, String{}},
+			Nullable: NullableNo,
+		}
+		w := oneOf.Schema()
+		if len(w.OneOf) != 2 || len(w.AnyOf) != 0 {
+			tt.Errorf("WrapSchema = %+v", w)
+		}
+		schema := w.ToGenAI()
+		if len(schema.AnyOf) != 2 || *schema.Nullable != false {
+			tt.Errorf("genai.Schema = %+v", schema)
+		}
+	})
+}
+
+func testFilterSchema() Object {
+	return Object{
+		Properties: Properties{
+			"filter": Ref{Name: "Filter", Description: "search filter", Required: true},
+		},
+		Defs: Definitions{
+			"Filter": Object{
+				Description: "filter expression",
+				Properties: Properties{
+					"field": String{},
+					"value": String{},
+					"and": Array{
+						Items: Ref{Name: "Filter"},
+					},
+					"or": Array{
+						Items: Ref{Name: "Filter"},
+					},
+				},
+			},
+		},
+		Required: true,
+	}
+}
+
+func TestRef(t *testing.T) {
+	t.Run("$ref/$defs", func(tt *testing.T) {
+		data, err := json.Marshal(testFilterSchema().Schema())
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		w := &WrapSchema{}
+		if err := json.Unmarshal(data, w); err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		if w.Properties["filter"].Ref != "#/$defs/Filter" {
+			tt.Errorf("$ref = %s", w.Properties["filter"].Ref)
+		}
+		if w.Defs["Filter"].Properties["and"].Items.Ref != "#/$defs/Filter" {
+			tt.Errorf("$defs = %+v", w.Defs["Filter"])
+		}
+	})
+	t.Run("expand", func(tt *testing.T) {
+		schema := testFilterSchema().Schema().ToGenAI()
+		depth := 0
+		filter := schema.Properties["filter"]
+		if filter.Description != "search filter" {
+			tt.Errorf("Description = %s", filter.Description)
+		}
+		for filter.Properties["and"] != nil {
+			depth += 1
+			filter = filter.Properties["and"].Items
+		}
+		if depth != DefaultRefExpandDepth {
+			tt.Errorf("depth = %d, want %d", depth, DefaultRefExpandDepth)
+		}
+		if filter.Type != genai.TypeObject || filter.Description != "filter expression" || *filter.Nullable != true {
+			tt.Errorf("truncated = %+v", filter)
+		}
+	})
+	t.Run("ExpandRefs", func(tt *testing.T) {
+		w := testFilterSchema().Schema().ExpandRefs(1)
+		if w.Defs != nil {
+			tt.Errorf("$defs must be removed")
+		}
+		filter := w.Properties["filter"]
+		if filter.Ref != "" || filter.Properties["or"].Items.Properties != nil {
+			tt.Errorf("expanded = %+v", filter)
+		}
+	})
+	t.Run("unresolvable", func(tt *testing.T) {
+		schema := Object{
+			Properties: Properties{
+				"x": Ref{Name: "Missing", Description: "missing"},
+			},
+		}.Schema().ToGenAI()
+		if schema.Properties["x"].Description != "missing" {
+			tt.Errorf("x = %+v", schema.Properties["x"])
+		}
+	})
+}
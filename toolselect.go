@@ -0,0 +1,379 @@
+package polaris
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"google.golang.org/genai"
+)
+
+const (
+	ToolSearchFunctionName string = "polaris_search_tools"
+	defaultToolSelectTopK  int    = 20
+)
+
+type ToolScorer interface {
+	Score(ctx context.Context, query string, declarations []*genai.FunctionDeclaration) ([]float64, error)
+}
+
+var (
+	_ ToolScorer = (*BM25Scorer)(nil)
+	_ ToolScorer = (*EmbeddingScorer)(nil)
+)
+
+// BM25Scorer ranks declarations by keyword relevance of name, description and parameters
+type BM25Scorer struct {
+	K1 float64
+	B  float64
+}
+
+func NewBM25Scorer() *BM25Scorer {
+	return &BM25Scorer{K1: 1.2, B: 0.75}
+}
+
+func (s *BM25Scorer) Score(ctx context.Context, query string, declarations []*genai.FunctionDeclaration) ([]float64, error) {
+	docs := make([][]string, len(declarations))
+	docFreq := make(map[string]int)
+	totalLen := 0
+	for i, d := range declarations {
+		docs[i] = tokenize(declarationText(d))
+		totalLen += len(docs[i])
+
+		seen := make(map[string]struct{}, len(docs[i]))
+		for _, term := range docs[i] {
+			if _, ok := seen[term]; ok {
+				continue
+			}
+			seen[term] = struct{}{}
+			docFreq[term] += 1
+		}
+	}
+
+	scores := make([]float64, len(declarations))
+	if len(declarations) < 1 {
+		return scores, nil
+	}
+	avgLen := float64(totalLen) / float64(len(declarations))
+	n := float64(len(declarations))
+	terms := tokenize(query)
+	for i, doc := range docs {
+		termFreq := make(map[string]int, len(doc))
+		for _, term := range doc {
+			termFreq[term] += 1
+		}
+		docLen := float64(len(doc))
+
+		score := 0.0
+		for _, term := range terms {
+			tf := float64(termFreq[term])
+			if tf == 0 {
+				continue
+			}
+			df := float64(docFreq[term])
+			idf := math.Log(1.0 + (n-df+0.5)/(df+0.5))
+			score += idf * (tf * (s.K1 + 1.0)) / (tf + s.K1*(1.0-s.B+s.B*docLen/avgLen))
+		}
+		scores[i] = score
+	}
+	return scores, nil
+}
+
+type EmbeddingFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
+// EmbeddingScorer ranks declarations by cosine similarity of embeddings.
+// declaration embeddings are cached by name, and embedded again when the description or parameters change
+type EmbeddingScorer struct {
+	mutex *sync.Mutex
+	embed EmbeddingFunc
+	cache map[string]embeddingEntry
+}
+
+type embeddingEntry struct {
+	text   string
+	vector []float32
+}
+
+func NewEmbeddingScorer(fn EmbeddingFunc) *EmbeddingScorer {
+	return &EmbeddingScorer{
+		mutex: new(sync.Mutex),
+		embed: fn,
+		cache: make(map[string]embeddingEntry),
+	}
+}
+
+func (s *EmbeddingScorer) Score(ctx context.Context, query string, declarations []*genai.FunctionDeclaration) ([]float64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	texts := []string{query}
+	missing := make([]string, 0, len(declarations))
+	for _, d := range declarations {
+		text := declarationText(d)
+		if e, ok := s.cache[d.Name]; ok && e.text == text {
+			continue
+		}
+		texts = append(texts, text)
+		missing = append(missing, d.Name)
+	}
+
+	vectors, err := s.embed(ctx, texts)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(vectors) != len(texts) {
+		return nil, errors.Errorf("embedding size mismatch: want %d got %d", len(texts), len(vectors))
+	}
+	for i, name := range missing {
+		s.cache[name] = embeddingEntry{texts[i+1], vectors[i+1]}
+	}
+
+	queryVec := vectors[0]
+	scores := make([]float64, len(declarations))
+	for i, d := range declarations {
+		scores[i] = cosineSimilarity(queryVec, s.cache[d.Name].vector)
+	}
+	return scores, nil
+}
+
+func GeminiEmbedding(model string) EmbeddingFunc {
+	return func(ctx context.Context, texts []string) ([][]float32, error) {
+		client, err := geminiClient(ctx)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		contents := make([]*genai.Content, len(texts))
+		for i, t := range texts {
+			contents[i] = genai.NewContentFromText(t, genai.RoleUser)
+		}
+		resp, err := client.Models.EmbedContent(ctx, model, contents, nil)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		vectors := make([][]float32, len(resp.Embeddings))
+		for i, e := range resp.Embeddings {
+			vectors[i] = e.Values
+		}
+		return vectors, nil
+	}
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) < 1 {
+		return 0.0
+	}
+	dot, normA, normB := 0.0, 0.0, 0.0
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0.0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func declarationText(d *genai.FunctionDeclaration) string {
+	buf := []string{d.Name, d.Description}
+	if d.Parameters != nil {
+		// stable order, the text is compared to the cached embedding
+		keys := make([]string, 0, len(d.Parameters.Properties))
+		for k := range d.Parameters.Properties {
+			if k == "_error" {
+				continue
+			}
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			buf = append(buf, k, d.Parameters.Properties[k].Description)
+		}
+	}
+	return strings.Join(buf, " ")
+}
+
+func tokenize(text string) []string {
+	tokens := make([]string, 0, 16)
+	current := make([]rune, 0, 16)
+	flush := func() {
+		if 0 < len(current) {
+			tokens = append(tokens, strings.ToLower(string(current)))
+			current = current[:0]
+		}
+	}
+	prevLower := false
+	for _, r := range text {
+		if unicode.IsLetter(r) != true && unicode.IsDigit(r) != true {
+			flush()
+			prevLower = false
+			continue
+		}
+		// split camelCase
+		if unicode.IsUpper(r) && prevLower {
+			flush()
+		}
+		current = append(current, r)
+		prevLower = unicode.IsLower(r)
+	}
+	flush()
+	return tokens
+}
+
+type toolSelector struct {
+	mutex   *sync.Mutex
+	topK    int
+	scorer  ToolScorer
+	catalog []*genai.FunctionDeclaration
+	active  []*genai.FunctionDeclaration
+}
+
+func (ts *toolSelector) rank(ctx context.Context, query string, limit int) ([]*genai.FunctionDeclaration, error) {
+	scores, err := ts.scorer.Score(ctx, query, ts.catalog)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(scores) != len(ts.catalog) {
+		return nil, errors.Errorf("score size mismatch: want %d got %d", len(ts.catalog), len(scores))
+	}
+
+	indexes := make([]int, len(ts.catalog))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	slices.SortStableFunc(indexes, func(a, b int) int {
+		switch {
+		case scores[a] < scores[b]:
+			return 1
+		case scores[b] < scores[a]:
+			return -1
+		default:
+			return 0
+		}
+	})
+
+	if len(indexes) < limit {
+		limit = len(indexes)
+	}
+	ranked := make([]*genai.FunctionDeclaration, limit)
+	for i := 0; i < limit; i += 1 {
+		ranked[i] = ts.catalog[indexes[i]]
+	}
+	return ranked, nil
+}
+
+// selectTools resets the active tools to the topK tools matching query
+func (ts *toolSelector) selectTools(ctx context.Context, query string) error {
+	ranked, err := ts.rank(ctx, query, ts.topK)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	ts.active = ranked
+	return nil
+}
+
+func (ts *toolSelector) activeDeclarations() []*genai.FunctionDeclaration {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	decls := make([]*genai.FunctionDeclaration, 0, len(ts.active)+1)
+	decls = append(decls, ts.active...)
+	decls = append(decls, toolSearchDeclaration())
+	return decls
+}
+
+func (ts *toolSelector) handleSearch(ctx context.Context, args map[string]any) (map[string]any, error) {
+	req := Req(args)
+	query := req.String("query", "")
+	limit := req.Int("limit", ts.topK)
+	if limit < 1 {
+		limit = ts.topK
+	}
+
+	ranked, err := ts.rank(ctx, query, limit)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	tools := make([]map[string]any, len(ranked))
+	for i, d := range ranked {
+		tools[i] = map[string]any{
+			"name":        d.Name,
+			"description": d.Description,
+		}
+		if slices.ContainsFunc(ts.active, func(a *genai.FunctionDeclaration) bool { return a.Name == d.Name }) != true {
+			ts.active = append(ts.active, d)
+		}
+	}
+	resp := Resp{}
+	resp.Set("tools", tools)
+	return resp.ToMap(), nil
+}
+
+func toolSearchDeclaration() *genai.FunctionDeclaration {
+	return &genai.FunctionDeclaration{
+		Name:        ToolSearchFunctionName,
+		Description: "Search the tool catalog by keywords. Matching tools become available for calling after this function returns.",
+		Parameters: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"query": {
+					Type:        genai.TypeString,
+					Description: "keywords describing the capability you need",
+				},
+				"limit": {
+					Type:        genai.TypeInteger,
+					Description: "maximum number of tools to return",
+					Nullable:    genai.Ptr(true),
+				},
+			},
+			Required: []string{"query"},
+		},
+		Response: &genai.Schema{
+			Type: genai.TypeObject,
+			Properties: map[string]*genai.Schema{
+				"tools": {
+					Type:        genai.TypeArray,
+					Description: "matched tools",
+					Items: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"name":        {Type: genai.TypeString},
+							"description": {Type: genai.TypeString},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newToolSelector(topK int, scorer ToolScorer, catalog []*genai.FunctionDeclaration) *toolSelector {
+	if scorer == nil {
+		scorer = NewBM25Scorer()
+	}
+	active := make([]*genai.FunctionDeclaration, 0, topK)
+	if len(catalog) < topK {
+		active = append(active, catalog...)
+	} else {
+		active = append(active, catalog[:topK]...)
+	}
+	return &toolSelector{
+		mutex:   new(sync.Mutex),
+		topK:    topK,
+		scorer:  scorer,
+		catalog: catalog,
+		active:  active,
+	}
+}
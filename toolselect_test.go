@@ -0,0 +1,167 @@
+package polaris
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func testCatalog() []*genai.FunctionDeclaration {
+	return []*genai.FunctionDeclaration{
+		{
+			Name:        "read_log_file",
+			Description: "Reads the last N lines from the application log",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"lines": {Type: genai.TypeInteger, Description: "number of lines"},
+				},
+			},
+		},
+		{
+			Name:        "getWeather",
+			Description: "Returns the current weather for a city",
+			Parameters: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"city": {Type: genai.TypeString, Description: "city name"},
+				},
+			},
+		},
+		{
+			Name:        "restart_service",
+			Description: "Restarts a systemd service on the host",
+		},
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"read_log_file", []string{"read", "log", "file"}},
+		{"getWeather", []string{"get", "weather"}},
+		{"HTTP status 200!", []string{"http", "status", "200"}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); slices.Equal(got, tt.want) != true {
+			t.Errorf("tokenize(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestBM25Scorer(t *testing.T) {
+	catalog := testCatalog()
+	scores, err := NewBM25Scorer().Score(context.TODO(), "what is the weather in tokyo city", catalog)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if len(scores) != len(catalog) {
+		t.Fatalf("len(scores) = %d, want %d", len(scores), len(catalog))
+	}
+	if (scores[0] < scores[1] && scores[2] < scores[1]) != true {
+		t.Errorf("getWeather must be best match: %v", scores)
+	}
+}
+
+func TestEmbeddingScorer(t *testing.T) {
+	calls := 0
+	embed := func(ctx context.Context, texts []string) ([][]float32, error) {
+		calls += 1
+		vectors := make([][]float32, len(texts))
+		for i, text := range texts {
+			vec := []float32{0, 0}
+			for _, term := range tokenize(text) {
+				switch term {
+				case "log":
+					vec[0] += 1
+				case "weather":
+					vec[1] += 1
+				}
+			}
+			vectors[i] = vec
+		}
+		return vectors, nil
+	}
+	scorer := NewEmbeddingScorer(embed)
+	catalog := testCatalog()[:2]
+
+	scores, err := scorer.Score(context.TODO(), "show log", catalog)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if scores[0] <= scores[1] {
+		t.Errorf("read_log_file must be best match: %v", scores)
+	}
+	if _, err := scorer.Score(context.TODO(), "weather", catalog); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if len(scorer.cache) != 2 {
+		t.Errorf("declarations must be cached: %d", len(scorer.cache))
+	}
+	if calls != 2 {
+		t.Errorf("embed calls = %d, want 2", calls)
+	}
+
+	// same name with other description must not use the cached embedding
+	changed := []*genai.FunctionDeclaration{
+		{Name: catalog[0].Name, Description: "Reports the weather forecast"},
+		catalog[1],
+	}
+	scores, err = scorer.Score(context.TODO(), "weather", changed)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if scores[0] <= 0 {
+		t.Errorf("changed description must be embedded again: %v", scores)
+	}
+	if calls != 3 || len(scorer.cache) != 2 {
+		t.Errorf("embed calls = %d, cache = %d", calls, len(scorer.cache))
+	}
+}
+
+func TestToolSelector(t *testing.T) {
+	names := func(decls []*genai.FunctionDeclaration) []string {
+		ret := make([]string, len(decls))
+		for i, d := range decls {
+			ret[i] = d.Name
+		}
+		return ret
+	}
+
+	t.Run("selectTools", func(tt *testing.T) {
+		ts := newToolSelector(1, nil, testCatalog())
+		if err := ts.selectTools(context.TODO(), "restart the nginx service"); err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		want := []string{"restart_service", ToolSearchFunctionName}
+		if got := names(ts.activeDeclarations()); slices.Equal(got, want) != true {
+			tt.Errorf("activeDeclarations() = %v, want %v", got, want)
+		}
+	})
+	t.Run("handleSearch", func(tt *testing.T) {
+		ts := newToolSelector(1, nil, testCatalog())
+		if err := ts.selectTools(context.TODO(), "restart the nginx service"); err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		resp, err := ts.handleSearch(context.TODO(), map[string]any{"query": "log lines", "limit": float64(1)})
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		tools, ok := resp["tools"].([]any)
+		if ok != true || len(tools) != 1 {
+			tt.Fatalf("tools = %v", resp["tools"])
+		}
+		if name := tools[0].(map[string]any)["name"]; name != "read_log_file" {
+			tt.Errorf("search result = %v, want read_log_file", name)
+		}
+		want := []string{"restart_service", "read_log_file", ToolSearchFunctionName}
+		if got := names(ts.activeDeclarations()); slices.Equal(got, want) != true {
+			tt.Errorf("activeDeclarations() = %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,496 @@
+package polaris
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/genai"
+)
+
+//	type Weather struct {
+//	  City    string   `json:"city"    polaris:"desc=city name,required,maxLength=64"`
+//	  Unit    string   `json:"unit"    polaris:"desc=temperature unit,enum=celsius|fahrenheit"`
+//	  Temp    *float64 `json:"temp"    polaris:"desc=temperature,min=-100,max=100"`
+//	  Days    []string `json:"days"    polaris:"minItems=1,maxItems=7"`
+//	}
+//
+// keys: title, desc, required, enum, min, max, minLength, maxLength, minItems, maxItems, pattern, format, default, example
+const structTagName string = "polaris"
+
+var timeType = reflect.TypeFor[time.Time]()
+
+type fieldTag struct {
+	Name        string
+	Title       string
+	Description string
+	Required    bool
+	Enum        []string
+	Minimum     *float64
+	Maximum     *float64
+	MinLength   *int
+	MaxLength   *int
+	MinItems    *int
+	MaxItems    *int
+	Pattern     string
+	Format      string
+	Default     string
+	Example     string
+}
+
+func parseFieldTag(field reflect.StructField) (fieldTag, bool, error) {
+	tag := fieldTag{Name: field.Name}
+
+	if jsonTag, ok := field.Tag.Lookup("json"); ok {
+		name, _, _ := strings.Cut(jsonTag, ",")
+		if name == "-" {
+			return tag, false, nil
+		}
+		if name != "" {
+			tag.Name = name
+		}
+	}
+
+	polarisTag, ok := field.Tag.Lookup(structTagName)
+	if ok != true {
+		return tag, true, nil
+	}
+	if polarisTag == "-" {
+		return tag, false, nil
+	}
+
+	parseFloat := func(key, value string) (*float64, error) {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tag %s=%s", key, value)
+		}
+		return genai.Ptr(f), nil
+	}
+	parseInt := func(key, value string) (*int, error) {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tag %s=%s", key, value)
+		}
+		return genai.Ptr(i), nil
+	}
+
+	lastKey := ""
+	for _, part := range strings.Split(polarisTag, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		err := error(nil)
+		switch strings.TrimSpace(key) {
+		case "title":
+			tag.Title = value
+		case "desc":
+			tag.Description = value
+		case "required":
+			tag.Required = true
+		case "enum":
+			tag.Enum = strings.Split(value, "|")
+		case "min":
+			tag.Minimum, err = parseFloat(key, value)
+		case "max":
+			tag.Maximum, err = parseFloat(key, value)
+		case "minLength":
+			tag.MinLength, err = parseInt(key, value)
+		case "maxLength":
+			tag.MaxLength, err = parseInt(key, value)
+		case "minItems":
+			tag.MinItems, err = parseInt(key, value)
+		case "maxItems":
+			tag.MaxItems, err = parseInt(key, value)
+		case "pattern":
+			tag.Pattern = value
+		case "format":
+			tag.Format = value
+		case "default":
+			tag.Default = value
+		case "example":
+			tag.Example = value
+		default:
+			// desc and pattern may contain ','
+			switch lastKey {
+			case "desc":
+				tag.Description += "," + part
+			case "pattern":
+				tag.Pattern += "," + part
+			}
+			continue
+		}
+		if err != nil {
+			return tag, false, errors.WithStack(err)
+		}
+		lastKey = strings.TrimSpace(key)
+	}
+	return tag, true, nil
+}
+
+func parseTagValue[T any](raw string, parse func(string) (T, error)) (T, error) {
+	if raw == "" {
+		var zero T
+		return zero, nil
+	}
+	return parse(raw)
+}
+
+func exampleValue[T any](raw string, value T) any {
+	if raw == "" {
+		return nil
+	}
+	return value
+}
+
+func float64PtrToInt(v *float64) *int {
+	if v == nil {
+		return nil
+	}
+	return genai.Ptr(int(*v))
+}
+
+func schemaOf(t reflect.Type) (Object, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return Object{}, errors.Errorf("struct required: %s", t)
+	}
+	b := &schemaBuilder{visiting: make(map[reflect.Type]struct{})}
+	props, err := b.structProperties(t)
+	if err != nil {
+		return Object{}, errors.WithStack(err)
+	}
+	return Object{
+		Properties: props,
+		Required:   true,
+	}, nil
+}
+
+type schemaBuilder struct {
+	visiting map[reflect.Type]struct{}
+}
+
+func (b *schemaBuilder) structProperties(t reflect.Type) (Properties, error) {
+	if _, ok := b.visiting[t]; ok {
+		return nil, errors.Errorf("recursive type: %s", t)
+	}
+	b.visiting[t] = struct{}{}
+	defer delete(b.visiting, t)
+
+	props := Properties{}
+	for i := 0; i < t.NumField(); i += 1 {
+		field := t.Field(i)
+		tag, ok, err := parseFieldTag(field)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s.%s", t.Name(), field.Name)
+		}
+		if ok != true {
+			continue
+		}
+
+		// fields of embedded struct are promoted like encoding/json
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if _, hasJSONName := field.Tag.Lookup("json"); hasJSONName != true {
+				embedded, err := b.structProperties(field.Type)
+				if err != nil {
+					return nil, errors.WithStack(err)
+				}
+				for k, v := range embedded {
+					props[k] = v
+				}
+				continue
+			}
+		}
+		if field.IsExported() != true {
+			continue
+		}
+
+		def, err := b.typeDefOf(field.Type, tag)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s.%s", t.Name(), field.Name)
+		}
+		props[tag.Name] = def
+	}
+	return props, nil
+}
+
+func (b *schemaBuilder) typeDefOf(t reflect.Type, tag fieldTag) (TypeDef, error) {
+	nullable := NullableType("")
+	if tag.Required {
+		nullable = NullableNo
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = NullableYes
+	}
+
+	stringDef := func(format string) String {
+		if tag.Format != "" {
+			format = tag.Format
+		}
+		return String{
+			Title:       tag.Title,
+			Description: tag.Description,
+			Default:     tag.Default,
+			Example:     exampleValue(tag.Example, tag.Example),
+			MinLength:   tag.MinLength,
+			MaxLength:   tag.MaxLength,
+			Pattern:     tag.Pattern,
+			Format:      format,
+			Required:    tag.Required,
+			Nullable:    nullable,
+		}
+	}
+
+	switch {
+	case t == timeType:
+		return stringDef("date-time"), nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		// []byte is base64 string in JSON
+		return stringDef(""), nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if 0 < len(tag.Enum) {
+			return StringEnum{
+				Description: tag.Description,
+				Values:      tag.Enum,
+				Default:     tag.Default,
+				Example:     exampleValue(tag.Example, tag.Example),
+				Required:    tag.Required,
+				Nullable:    nullable,
+			}, nil
+		}
+		return stringDef(""), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		defaultInt, err := parseTagValue(tag.Default, strconv.Atoi)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tag default=%s", tag.Default)
+		}
+		exampleInt, err := parseTagValue(tag.Example, strconv.Atoi)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tag example=%s", tag.Example)
+		}
+		if 0 < len(tag.Enum) {
+			return IntEnum{
+				Description: tag.Description,
+				Values:      tag.Enum,
+				Default:     defaultInt,
+				Example:     exampleValue(tag.Example, exampleInt),
+				Required:    tag.Required,
+				Nullable:    nullable,
+			}, nil
+		}
+		return Int{
+			Title:       tag.Title,
+			Description: tag.Description,
+			Default:     defaultInt,
+			Example:     exampleValue(tag.Example, exampleInt),
+			Minimum:     float64PtrToInt(tag.Minimum),
+			Maximum:     float64PtrToInt(tag.Maximum),
+			Format:      tag.Format,
+			Required:    tag.Required,
+			Nullable:    nullable,
+		}, nil
+	case reflect.Float32, reflect.Float64:
+		parseFloat := func(v string) (float64, error) {
+			return strconv.ParseFloat(v, 64)
+		}
+		defaultFloat, err := parseTagValue(tag.Default, parseFloat)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tag default=%s", tag.Default)
+		}
+		exampleFloat, err := parseTagValue(tag.Example, parseFloat)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tag example=%s", tag.Example)
+		}
+		return Float{
+			Title:       tag.Title,
+			Description: tag.Description,
+			Default:     defaultFloat,
+			Example:     exampleValue(tag.Example, exampleFloat),
+			Minimum:     tag.Minimum,
+			Maximum:     tag.Maximum,
+			Format:      tag.Format,
+			Required:    tag.Required,
+			Nullable:    nullable,
+		}, nil
+	case reflect.Bool:
+		defaultBool, err := parseTagValue(tag.Default, strconv.ParseBool)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tag default=%s", tag.Default)
+		}
+		exampleBool, err := parseTagValue(tag.Example, strconv.ParseBool)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tag example=%s", tag.Example)
+		}
+		return Bool{
+			Title:       tag.Title,
+			Description: tag.Description,
+			Default:     defaultBool,
+			Example:     exampleValue(tag.Example, exampleBool),
+			Required:    tag.Required,
+			Nullable:    nullable,
+		}, nil
+	case reflect.Struct:
+		props, err := b.structProperties(t)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return Object{Title: tag.Title, Description: tag.Description, Properties: props, Required: tag.Required, Nullable: nullable}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, errors.Errorf("map key must be string: %s", t)
+		}
+		return Object{Title: tag.Title, Description: tag.Description, Properties: Properties{}, Required: tag.Required, Nullable: nullable}, nil
+	case reflect.Slice, reflect.Array:
+		return b.arrayTypeDefOf(t.Elem(), tag, nullable)
+	}
+	return nil, errors.Errorf("unsupported type: %s", t)
+}
+
+func (b *schemaBuilder) arrayTypeDefOf(elem reflect.Type, tag fieldTag, nullable NullableType) (TypeDef, error) {
+	// constraints of items are applied to each item
+	hasItemConstraint := 0 < len(tag.Enum) || tag.Minimum != nil || tag.Maximum != nil ||
+		tag.MinLength != nil || tag.MaxLength != nil || tag.Pattern != "" || tag.Format != ""
+	if elem != timeType && hasItemConstraint != true {
+		switch elem.Kind() {
+		case reflect.String:
+			return StringArray{Title: tag.Title, Description: tag.Description, MinItems: tag.MinItems, MaxItems: tag.MaxItems, Required: tag.Required, Nullable: nullable}, nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return IntArray{Title: tag.Title, Description: tag.Description, MinItems: tag.MinItems, MaxItems: tag.MaxItems, Required: tag.Required, Nullable: nullable}, nil
+		case reflect.Float32, reflect.Float64:
+			return FloatArray{Title: tag.Title, Description: tag.Description, MinItems: tag.MinItems, MaxItems: tag.MaxItems, Required: tag.Required, Nullable: nullable}, nil
+		case reflect.Bool:
+			return BoolArray{Title: tag.Title, Description: tag.Description, MinItems: tag.MinItems, MaxItems: tag.MaxItems, Required: tag.Required, Nullable: nullable}, nil
+		case reflect.Struct:
+			props, err := b.structProperties(elem)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return ObjectArray{Title: tag.Title, Description: tag.Description, Items: props, MinItems: tag.MinItems, MaxItems: tag.MaxItems, Required: tag.Required, Nullable: nullable}, nil
+		}
+	}
+
+	// constrained item or nested array
+	items, err := b.typeDefOf(elem, fieldTag{
+		Enum:      tag.Enum,
+		Minimum:   tag.Minimum,
+		Maximum:   tag.Maximum,
+		MinLength: tag.MinLength,
+		MaxLength: tag.MaxLength,
+		Pattern:   tag.Pattern,
+		Format:    tag.Format,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return Array{Title: tag.Title, Description: tag.Description, Items: items, MinItems: tag.MinItems, MaxItems: tag.MaxItems, Required: tag.Required, Nullable: nullable}, nil
+}
+
+// SchemaOf derives Object from struct fields of T
+func SchemaOf[T any]() (Object, error) {
+	return schemaOf(reflect.TypeFor[T]())
+}
+
+type TypedToolHandler[P any, R any] func(context.Context, P) (R, error)
+
+// NewTypedTool derives Parameters from P and Response from R,
+// arguments are decoded into P before calling handler
+func NewTypedTool[P any, R any](name, description string, handler TypedToolHandler[P, R]) (Tool, error) {
+	params, err := SchemaOf[P]()
+	if err != nil {
+		return Tool{}, errors.Wrapf(err, "parameters of %s", name)
+	}
+	response, err := SchemaOf[R]()
+	if err != nil {
+		return Tool{}, errors.Wrapf(err, "response of %s", name)
+	}
+	return Tool{
+		Name:        name,
+		Description: description,
+		Parameters:  params,
+		Response:    response,
+		Handler:     typedToolHandler(handler),
+	}, nil
+}
+
+func typedToolHandler[P any, R any](handler TypedToolHandler[P, R]) ToolHandler {
+	return func(r *ReqCtx) (Resp, error) {
+		var params P
+		if err := decodeInto(r.Req(), &params); err != nil {
+			return nil, errors.Wrapf(err, "invalid arguments")
+		}
+		ret, err := handler(r.Context(), params)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		resp, err := encodeFrom(ret)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return resp, nil
+	}
+}
+
+type GenerateTypedFunc[T any] func(...string) (T, error)
+
+// GenerateTyped derives OutputSchema from T, decodes the JSON output into T
+func GenerateTyped[T any](ctx context.Context, options ...UseOptionFunc) (GenerateTypedFunc[T], error) {
+	schema, err := schemaOf(reflect.TypeFor[T]())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	gen, err := GenerateJSON(ctx, append(options, UseJSONOutput(schema))...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return typedFunc[T](gen, schema), nil
+}
+
+func typedFunc[T any](gen GenerateJSONFunc, schema Object) GenerateTypedFunc[T] {
+	return func(text ...string) (T, error) {
+		var ret T
+		resp, err := gen(text...)
+		if err != nil {
+			return ret, errors.WithStack(err)
+		}
+		if err := Validate(schema, map[string]any(resp)); err != nil {
+			return ret, errors.WithStack(err)
+		}
+		if err := decodeInto(resp, &ret); err != nil {
+			return ret, errors.WithStack(err)
+		}
+		return ret, nil
+	}
+}
+
+func decodeInto(m map[string]any, v any) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func encodeFrom(v any) (Resp, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp := Resp{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,246 @@
+package polaris
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+type testTypedAddress struct {
+	City string `json:"city" polaris:"desc=city name, or town name,required"`
+}
+
+type testTypedBase struct {
+	ID int `json:"id" polaris:"required"`
+}
+
+type testTypedUser struct {
+	testTypedBase
+	Name      string             `json:"name" polaris:"desc=user name,required"`
+	Role      string             `json:"role" polaris:"enum=admin|member"`
+	Score     *float64           `json:"score,omitempty"`
+	Tags      []string           `json:"tags"`
+	Levels    []int              `json:"levels" polaris:"enum=1|2|3"`
+	Address   testTypedAddress   `json:"address"`
+	Addresses []testTypedAddress `json:"addresses"`
+	CreatedAt time.Time          `json:"created_at"`
+	Ignored   string             `json:"-"`
+	internal  string
+}
+
+func TestSchemaOf(t *testing.T) {
+	obj, err := schemaOf(reflect.TypeFor[testTypedUser]())
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	keys := make([]string, 0, len(obj.Properties))
+	for k := range obj.Properties {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	wantKeys := []string{"address", "addresses", "created_at", "id", "levels", "name", "role", "score", "tags"}
+	if slices.Equal(keys, wantKeys) != true {
+		t.Fatalf("keys = %v, want %v", keys, wantKeys)
+	}
+
+	if name, ok := obj.Properties["name"].(String); ok != true || name.Description != "user name" || name.Required != true {
+		t.Errorf("name = %#v", obj.Properties["name"])
+	}
+	if role, ok := obj.Properties["role"].(StringEnum); ok != true || slices.Equal(role.Values, []string{"admin", "member"}) != true {
+		t.Errorf("role = %#v", obj.Properties["role"])
+	}
+	if score, ok := obj.Properties["score"].(Float); ok != true || score.Nullable != NullableYes {
+		t.Errorf("score = %#v", obj.Properties["score"])
+	}
+	if _, ok := obj.Properties["tags"].(StringArray); ok != true {
+		t.Errorf("tags = %#v", obj.Properties["tags"])
+	}
+	if levels, ok := obj.Properties["levels"].(Array); ok != true {
+		t.Errorf("levels = %#v", obj.Properties["levels"])
+	} else if _, ok := levels.Items.(IntEnum); ok != true {
+		t.Errorf("levels.Items = %#v", levels.Items)
+	}
+	if addr, ok := obj.Properties["address"].(Object); ok != true {
+		t.Errorf("address = %#v", obj.Properties["address"])
+	} else if city := addr.Properties["city"].(String); city.Description != "city name, or town name" {
+		t.Errorf("address.city.Description = %s", city.Description)
+	}
+	if _, ok := obj.Properties["addresses"].(ObjectArray); ok != true {
+		t.Errorf("addresses = %#v", obj.Properties["addresses"])
+	}
+	if _, ok := obj.Properties["created_at"].(String); ok != true {
+		t.Errorf("created_at = %#v", obj.Properties["created_at"])
+	}
+	if id, ok := obj.Properties["id"].(Int); ok != true || id.Required != true {
+		t.Errorf("id = %#v", obj.Properties["id"])
+	}
+}
+
+type testTypedNode struct {
+	Children []testTypedNode `json:"children"`
+}
+
+func TestSchemaOfError(t *testing.T) {
+	if _, err := schemaOf(reflect.TypeFor[string]()); err == nil {
+		t.Errorf("non struct must be error")
+	}
+	if _, err := schemaOf(reflect.TypeFor[testTypedNode]()); err == nil {
+		t.Errorf("recursive type must be error")
+	}
+}
+
+func TestTypedFunc(t *testing.T) {
+	schema, err := schemaOf(reflect.TypeFor[testTypedAddress]())
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Run("decode", func(tt *testing.T) {
+		gen := func(...string) (Resp, error) {
+			return Resp{"city": "Tokyo"}, nil
+		}
+		ret, err := typedFunc[testTypedAddress](gen, schema)("prompt")
+		if err != nil {
+			tt.Fatalf("no error: %+v", err)
+		}
+		if ret.City != "Tokyo" {
+			tt.Errorf("City = %s, want Tokyo", ret.City)
+		}
+	})
+	t.Run("invalid", func(tt *testing.T) {
+		gen := func(...string) (Resp, error) {
+			return Resp{"town": "Tokyo"}, nil
+		}
+		if _, err := typedFunc[testTypedAddress](gen, schema)("prompt"); err == nil {
+			tt.Errorf("missing required must be error")
+		}
+	})
+}
+
+type testTypedWeatherReq struct {
+	City string `json:"city" polaris:"desc=city name,required"`
+	Unit string `json:"unit" polaris:"enum=celsius|fahrenheit"`
+}
+
+type testTypedWeatherResp struct {
+	Temperature float64 `json:"temperature" polaris:"required"`
+	Unit        string  `json:"unit"`
+}
+
+func TestNewTypedTool(t *testing.T) {
+	tool, err := NewTypedTool(
+		"get_weather",
+		"returns weather",
+		func(ctx context.Context, req testTypedWeatherReq) (testTypedWeatherResp, error) {
+			if req.City == "" {
+				return testTypedWeatherResp{}, errors.New("city required")
+			}
+			return testTypedWeatherResp{Temperature: 21.5, Unit: req.Unit}, nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if _, ok := tool.Parameters.Properties["unit"].(StringEnum); ok != true {
+		t.Errorf("Parameters.unit = %#v", tool.Parameters.Properties["unit"])
+	}
+	if _, ok := tool.Response.Properties["temperature"].(Float); ok != true {
+		t.Errorf("Response.temperature = %#v", tool.Response.Properties["temperature"])
+	}
+
+	t.Run("call", func(tt *testing.T) {
+		resp := handleToolCall(context.TODO(), tool)(map[string]any{"city": "Tokyo", "unit": "celsius"})
+		if resp["temperature"] != 21.5 || resp["unit"] != "celsius" {
+			tt.Errorf("resp = %v", resp)
+		}
+	})
+	t.Run("handler error", func(tt *testing.T) {
+		resp := handleToolCall(context.TODO(), tool)(map[string]any{})
+		if resp["_error"] == nil {
+			tt.Errorf("_error required: %v", resp)
+		}
+	})
+	t.Run("decode error", func(tt *testing.T) {
+		resp := handleToolCall(context.TODO(), tool)(map[string]any{"city": 123})
+		if resp["_error"] == nil {
+			tt.Errorf("_error required: %v", resp)
+		}
+	})
+}
+
+type testTypedConstraint struct {
+	Port  int       `json:"port" polaris:"min=1,max=65535"`
+	Code  string    `json:"code" polaris:"pattern=^[A-Z]{1,3}$,minLength=1,maxLength=3,title=code"`
+	Hosts []string  `json:"hosts" polaris:"minItems=1,maxItems=3"`
+	Codes []string  `json:"codes" polaris:"pattern=^[a-z]+$"`
+	At    time.Time `json:"at"`
+}
+
+func TestSchemaOfConstraint(t *testing.T) {
+	obj, err := SchemaOf[testTypedConstraint]()
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	port := obj.Properties["port"].(Int)
+	if *port.Minimum != 1 || *port.Maximum != 65535 {
+		t.Errorf("port = %#v", port)
+	}
+	code := obj.Properties["code"].(String)
+	if code.Pattern != "^[A-Z]{1,3}$" || *code.MinLength != 1 || *code.MaxLength != 3 || code.Title != "code" {
+		t.Errorf("code = %#v", code)
+	}
+	hosts := obj.Properties["hosts"].(StringArray)
+	if *hosts.MinItems != 1 || *hosts.MaxItems != 3 {
+		t.Errorf("hosts = %#v", hosts)
+	}
+	codes := obj.Properties["codes"].(Array)
+	if codes.Items.(String).Pattern != "^[a-z]+$" {
+		t.Errorf("codes = %#v", codes)
+	}
+	if at := obj.Properties["at"].(String); at.Format != "date-time" {
+		t.Errorf("at = %#v", at)
+	}
+
+	type invalid struct {
+		Port int `polaris:"min=abc"`
+	}
+	if _, err := SchemaOf[invalid](); err == nil {
+		t.Errorf("invalid tag must be error")
+	}
+}
+
+type testTypedDefault struct {
+	Lines int     `json:"lines" polaris:"default=10,example=100"`
+	Ratio float64 `json:"ratio" polaris:"default=0.5"`
+	Mode  string  `json:"mode" polaris:"enum=fast|slow,default=fast"`
+	Debug bool    `json:"debug" polaris:"default=true"`
+}
+
+func TestSchemaOfDefault(t *testing.T) {
+	obj, err := SchemaOf[testTypedDefault]()
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if lines := obj.Properties["lines"].(Int); lines.Default != 10 || lines.Example != 100 {
+		t.Errorf("lines = %#v", lines)
+	}
+	if ratio := obj.Properties["ratio"].(Float); ratio.Default != 0.5 || ratio.Example != nil {
+		t.Errorf("ratio = %#v", ratio)
+	}
+	if mode := obj.Properties["mode"].(StringEnum); mode.Default != "fast" {
+		t.Errorf("mode = %#v", mode)
+	}
+	if debug := obj.Properties["debug"].(Bool); debug.Default != true {
+		t.Errorf("debug = %#v", debug)
+	}
+
+	type invalid struct {
+		Lines int `polaris:"default=ten"`
+	}
+	if _, err := SchemaOf[invalid](); err == nil {
+		t.Errorf("invalid default must be error")
+	}
+}
@@ -0,0 +1,339 @@
+package polaris
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+type Violation struct {
+	Path string `json:"path"`
+	Msg  string `json:"msg"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Path, v.Msg)
+}
+
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return fmt.Sprintf("schema validation failed: %s", strings.Join(msgs, "; "))
+}
+
+// Validate checks value(decoded JSON) against TypeDef
+func Validate(t TypeDef, value any) error {
+	return ValidateSchema(t.Schema(), value)
+}
+
+func ValidateSchema(schema *WrapSchema, value any) error {
+	v := &validator{defs: map[string]*WrapSchema{}}
+	v.validate(schema, "$", value)
+	if 0 < len(v.violations) {
+		return &ValidationError{v.violations}
+	}
+	return nil
+}
+
+// maxRefHops limits $ref that refers to $ref, e.g. {"a": {"$ref": "#/$defs/a"}}
+const maxRefHops = 32
+
+type validator struct {
+	defs       map[string]*WrapSchema
+	violations []Violation
+}
+
+func (v *validator) resolve(schema *WrapSchema, path string) *WrapSchema {
+	for i := 0; schema != nil && schema.Ref != ""; i += 1 {
+		name, ok := refName(schema.Ref)
+		if ok != true {
+			// remote reference is not supported, accepts anything
+			return nil
+		}
+		def, exists := v.defs[name]
+		if exists != true || maxRefHops <= i {
+			v.violations = append(v.violations, Violation{path, fmt.Sprintf("unresolvable $ref %q", schema.Ref)})
+			return nil
+		}
+		if schema.Nullable != nil && def != nil {
+			// nullable of the reference takes precedence
+			copied := *def
+			copied.Nullable = schema.Nullable
+			def = &copied
+		}
+		schema = def
+	}
+	return schema
+}
+
+func (v *validator) validate(schema *WrapSchema, path string, value any) {
+	if schema == nil {
+		return
+	}
+	if 0 < len(schema.Defs) {
+		parent := v.defs
+		v.defs = maps.Clone(parent)
+		maps.Copy(v.defs, schema.Defs)
+		defer func() { v.defs = parent }()
+	}
+	schema = v.resolve(schema, path)
+	if schema == nil {
+		return
+	}
+
+	addViolation := func(format string, args ...any) {
+		v.violations = append(v.violations, Violation{path, fmt.Sprintf(format, args...)})
+	}
+
+	if value == nil {
+		if schema.Nullable != nil && *schema.Nullable != true {
+			addViolation("must not be null")
+		}
+		return
+	}
+
+	if 0 < len(schema.AnyOf) {
+		if v.matchVariant(schema.AnyOf, value) < 0 {
+			addViolation("must match any of %d variants", len(schema.AnyOf))
+		}
+	}
+	if 0 < len(schema.OneOf) {
+		if n := v.countVariants(schema.OneOf, value); n != 1 {
+			addViolation("must match exactly one of %d variants, matched %d", len(schema.OneOf), n)
+		}
+	}
+
+	switch genai.Type(schema.Type) {
+	case genai.TypeString:
+		s, ok := value.(string)
+		if ok != true {
+			addViolation("must be string, got %s", jsonTypeName(value))
+			return
+		}
+		if 0 < len(schema.Enum) && slices.Contains(schema.Enum, s) != true {
+			addViolation("must be one of %v, got %q", schema.Enum, s)
+		}
+		length := int64(utf8.RuneCountInString(s))
+		if schema.MinLength != nil && length < *schema.MinLength {
+			addViolation("length must be >= %d, got %d", *schema.MinLength, length)
+		}
+		if schema.MaxLength != nil && *schema.MaxLength < length {
+			addViolation("length must be <= %d, got %d", *schema.MaxLength, length)
+		}
+		if schema.Pattern != "" {
+			re, err := compilePattern(schema.Pattern)
+			if err != nil {
+				addViolation("invalid pattern %q: %s", schema.Pattern, err.Error())
+			} else if re.MatchString(s) != true {
+				addViolation("must match pattern %q", schema.Pattern)
+			}
+		}
+		if validFormat(schema.Format, s) != true {
+			addViolation("must be %s format, got %q", schema.Format, s)
+		}
+	case genai.TypeInteger:
+		n, ok := toFloat64(value)
+		if ok != true || n != math.Trunc(n) {
+			addViolation("must be integer, got %s", jsonTypeName(value))
+			return
+		}
+		if 0 < len(schema.Enum) && slices.Contains(schema.Enum, strconv.FormatInt(int64(n), 10)) != true {
+			addViolation("must be one of %v, got %d", schema.Enum, int64(n))
+		}
+		validateRange(schema, n, addViolation)
+	case genai.TypeNumber:
+		n, ok := toFloat64(value)
+		if ok != true {
+			addViolation("must be number, got %s", jsonTypeName(value))
+			return
+		}
+		validateRange(schema, n, addViolation)
+	case genai.TypeBoolean:
+		if _, ok := value.(bool); ok != true {
+			addViolation("must be boolean, got %s", jsonTypeName(value))
+		}
+	case genai.TypeArray:
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			addViolation("must be array, got %s", jsonTypeName(value))
+			return
+		}
+		size := int64(rv.Len())
+		if schema.MinItems != nil && size < *schema.MinItems {
+			addViolation("must have >= %d items, got %d", *schema.MinItems, size)
+		}
+		if schema.MaxItems != nil && *schema.MaxItems < size {
+			addViolation("must have <= %d items, got %d", *schema.MaxItems, size)
+		}
+		for i := 0; i < rv.Len(); i += 1 {
+			v.validate(schema.Items, fmt.Sprintf("%s[%d]", path, i), rv.Index(i).Interface())
+		}
+	case genai.TypeObject:
+		m, ok := toStringMap(value)
+		if ok != true {
+			addViolation("must be object, got %s", jsonTypeName(value))
+			return
+		}
+		for _, key := range schema.Required {
+			if _, exists := m[key]; exists != true {
+				v.violations = append(v.violations, Violation{path + "." + key, "required"})
+			}
+		}
+		keys := make([]string, 0, len(schema.Properties))
+		for key := range schema.Properties {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			if value, exists := m[key]; exists {
+				v.validate(schema.Properties[key], path+"."+key, value)
+			}
+		}
+	}
+}
+
+// matches reports value matches schema without recording violations
+func (v *validator) matches(schema *WrapSchema, value any) bool {
+	sub := &validator{defs: v.defs}
+	sub.validate(schema, "$", value)
+	return len(sub.violations) < 1
+}
+
+// matchVariant returns index of the first matched variant, or -1
+func (v *validator) matchVariant(variants []*WrapSchema, value any) int {
+	for i, variant := range variants {
+		if v.matches(variant, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (v *validator) countVariants(variants []*WrapSchema, value any) int {
+	n := 0
+	for _, variant := range variants {
+		if v.matches(variant, value) {
+			n += 1
+		}
+	}
+	return n
+}
+
+func validateRange(schema *WrapSchema, n float64, addViolation func(string, ...any)) {
+	if schema.Minimum != nil && n < *schema.Minimum {
+		addViolation("must be >= %v, got %v", *schema.Minimum, n)
+	}
+	if schema.Maximum != nil && *schema.Maximum < n {
+		addViolation("must be <= %v, got %v", *schema.Maximum, n)
+	}
+}
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// patternCache holds compiled Pattern of schemas, patterns are fixed by tool definitions
+var patternCache sync.Map
+
+type compiledPattern struct {
+	re  *regexp.Regexp
+	err error
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if v, ok := patternCache.Load(pattern); ok {
+		p := v.(compiledPattern)
+		return p.re, p.err
+	}
+	re, err := regexp.Compile(pattern)
+	patternCache.Store(pattern, compiledPattern{re, err})
+	return re, err
+}
+
+// fullTimeLayout is RFC 3339 full-time, fractional seconds are accepted by time.Parse
+const fullTimeLayout = "15:04:05Z07:00"
+
+// validFormat returns true for unknown format
+func validFormat(format string, s string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	case "time":
+		// full-time such as "10:00:00Z", partial-time without offset is also accepted
+		if _, err := time.Parse(fullTimeLayout, s); err == nil {
+			return true
+		}
+		_, err := time.Parse(time.TimeOnly, s)
+		return err == nil
+	case "email":
+		return emailPattern.MatchString(s)
+	case "uuid":
+		return uuidPattern.MatchString(s)
+	}
+	return true
+}
+
+func toFloat64(value any) (float64, bool) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	}
+	return 0, false
+}
+
+func toStringMap(value any) (map[string]any, bool) {
+	switch v := value.(type) {
+	case map[string]any:
+		return v, true
+	case jsonMap:
+		return map[string]any(v), true
+	}
+	return nil, false
+}
+
+func jsonTypeName(value any) string {
+	if value == nil {
+		return "null"
+	}
+	if _, ok := toFloat64(value); ok {
+		return "number"
+	}
+	if _, ok := toStringMap(value); ok {
+		return "object"
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
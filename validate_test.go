@@ -0,0 +1,505 @@
+package polaris
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+func TestValidate(t *testing.T) {
+	schema := Object{
+		Properties: Properties{
+			"name": String{Description: "name", Required: true},
+			"age":  Int{Description: "age"},
+			"direction": StringEnum{
+				Values: []string{"north", "south"},
+			},
+			"level": IntEnum{
+				Values: []string{"1", "2"},
+			},
+			"tags": StringArray{},
+			"users": ObjectArray{
+				Items: Properties{
+					"id": Int{Required: true},
+				},
+			},
+			"point": Object{
+				Properties: Properties{
+					"x": Float{Required: true, Nullable: NullableNo},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		value     map[string]any
+		wantPaths []string
+	}{
+		{
+			name: "valid",
+			value: map[string]any{
+				"name":      "foo",
+				"age":       float64(20),
+				"direction": "north",
+				"level":     float64(2),
+				"tags":      []any{"a", "b"},
+				"users":     []any{map[string]any{"id": float64(1)}},
+				"point":     map[string]any{"x": 1.5},
+			},
+			wantPaths: nil,
+		},
+		{
+			name:      "missing required",
+			value:     map[string]any{"age": 1},
+			wantPaths: []string{"$.name"},
+		},
+		{
+			name: "type mismatch",
+			value: map[string]any{
+				"name": 123,
+				"age":  1.5,
+				"tags": []any{"a", true},
+			},
+			wantPaths: []string{"$.age", "$.name", "$.tags[1]"},
+		},
+		{
+			name: "enum",
+			value: map[string]any{
+				"name":      "foo",
+				"direction": "east",
+				"level":     float64(3),
+			},
+			wantPaths: []string{"$.direction", "$.level"},
+		},
+		{
+			name: "nested",
+			value: map[string]any{
+				"name":  "foo",
+				"users": []any{map[string]any{}, map[string]any{"id": "x"}},
+				"point": map[string]any{"x": nil},
+			},
+			wantPaths: []string{"$.point.x", "$.users[0].id", "$.users[1].id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(schema, tt.value)
+			if tt.wantPaths == nil {
+				if err != nil {
+					t.Fatalf("no error: %+v", err)
+				}
+				return
+			}
+			verr := (*ValidationError)(nil)
+			if errors.As(err, &verr) != true {
+				t.Fatalf("ValidationError required: %v", err)
+			}
+			if len(verr.Violations) != len(tt.wantPaths) {
+				t.Fatalf("violations = %v, want paths %v", verr.Violations, tt.wantPaths)
+			}
+			for i, v := range verr.Violations {
+				if v.Path != tt.wantPaths[i] {
+					t.Errorf("violations[%d].Path = %s, want %s", i, v.Path, tt.wantPaths[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHandleToolCallValidation(t *testing.T) {
+	called := false
+	tool := Tool{
+		Name: "test_tool",
+		Parameters: Object{
+			Properties: Properties{
+				"name": String{Required: true},
+				"mode": StringEnum{Values: []string{"fast", "slow"}},
+			},
+		},
+		Handler: func(r *ReqCtx) (Resp, error) {
+			called = true
+			return Resp{"name": r.String("name")}, nil
+		},
+	}
+
+	t.Run("invalid", func(tt *testing.T) {
+		called = false
+		resp := handleToolCall(context.TODO(), tool)(map[string]any{"mode": "normal"})
+		if called {
+			tt.Errorf("handler must not be called")
+		}
+		if _, ok := resp["_error"].(string); ok != true {
+			tt.Errorf("_error required: %v", resp)
+		}
+		violations, ok := resp["_violations"].([]any)
+		if ok != true || len(violations) != 2 {
+			tt.Errorf("_violations = %v", resp["_violations"])
+		}
+	})
+	t.Run("valid", func(tt *testing.T) {
+		called = false
+		resp := handleToolCall(context.TODO(), tool)(map[string]any{"name": "foo", "mode": "fast"})
+		if called != true {
+			tt.Errorf("handler must be called")
+		}
+		if resp["name"] != "foo" {
+			tt.Errorf("resp = %v", resp)
+		}
+	})
+	t.Run("skip", func(tt *testing.T) {
+		called = false
+		skipTool := tool
+		skipTool.SkipArgsValidation = true
+		handleToolCall(context.TODO(), skipTool)(map[string]any{"mode": "normal"})
+		if called != true {
+			tt.Errorf("handler must be called")
+		}
+	})
+}
+
+func TestHandleToolCallResponseValidation(t *testing.T) {
+	reported := 0
+	tool := Tool{
+		Name:       "test_tool",
+		Parameters: Object{},
+		Response: Object{
+			Properties: Properties{
+				"count": Int{Required: true},
+			},
+		},
+		Handler: func(r *ReqCtx) (Resp, error) {
+			return Resp{"count": "many"}, nil
+		},
+		ErrorHandler: func(err error) {
+			reported += 1
+		},
+	}
+
+	t.Run("none", func(tt *testing.T) {
+		reported = 0
+		resp := handleToolCall(context.TODO(), tool)(map[string]any{})
+		if resp["count"] != "many" || reported != 0 {
+			tt.Errorf("resp = %v reported = %d", resp, reported)
+		}
+	})
+	t.Run("warn", func(tt *testing.T) {
+		reported = 0
+		warnTool := tool
+		warnTool.ResponseValidation = ValidationWarn
+		resp := handleToolCall(context.TODO(), warnTool)(map[string]any{})
+		if resp["count"] != "many" || reported != 1 {
+			tt.Errorf("resp = %v reported = %d", resp, reported)
+		}
+	})
+	t.Run("strict", func(tt *testing.T) {
+		reported = 0
+		strictTool := tool
+		strictTool.ResponseValidation = ValidationStrict
+		resp := handleToolCall(context.TODO(), strictTool)(map[string]any{})
+		if _, ok := resp["_error"]; ok != true || reported != 1 {
+			tt.Errorf("resp = %v reported = %d", resp, reported)
+		}
+		if _, ok := resp["count"]; ok {
+			tt.Errorf("invalid response must not be replied: %v", resp)
+		}
+	})
+}
+
+func TestHandleToolCallResponseValidationJSON(t *testing.T) {
+	type item struct {
+		Key string `json:"key"`
+	}
+	tool := Tool{
+		Name:       "test_tool",
+		Parameters: Object{},
+		Response: Object{
+			Properties: Properties{
+				"labels": Object{Properties: Properties{"env": String{}}},
+				"rows":   ObjectArray{Items: Properties{"key": String{}}},
+				"item":   Object{Properties: Properties{"key": String{Required: true}}},
+				"at":     String{Format: "date-time"},
+			},
+		},
+		Handler: func(r *ReqCtx) (Resp, error) {
+			return Resp{
+				"labels": map[string]string{"env": "prod"},
+				"rows":   []map[string]string{{"key": "a"}},
+				"item":   item{"b"},
+				"at":     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			}, nil
+		},
+		ResponseValidation: ValidationStrict,
+	}
+	resp := handleToolCall(context.TODO(), tool)(map[string]any{})
+	if _, ok := resp["_error"]; ok {
+		t.Fatalf("valid JSON response must be replied: %v", resp)
+	}
+	if _, ok := resp["item"].(item); ok != true {
+		t.Errorf("resp must be replied as is: %v", resp)
+	}
+}
+
+func TestRegisterToolResponseValidation(t *testing.T) {
+	r, caller := testRegistry(t)
+	agent, err := Connect(NatsURL(r.ns.ClientURL()), Name("agent"), ConnectResponseValidation(ValidationStrict))
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	t.Cleanup(agent.Close)
+
+	invalid := func(name string, mode ValidationMode) Tool {
+		return Tool{
+			Name:       name,
+			Parameters: Object{},
+			Response:   Object{Properties: Properties{"count": Int{}}},
+			Handler: func(r *ReqCtx) (Resp, error) {
+				return Resp{"count": "many"}, nil
+			},
+			ResponseValidation: mode,
+		}
+	}
+	if err := agent.RegisterTool(invalid("test_inherit", ValidationInherit)); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if err := agent.RegisterTool(invalid("test_none", ValidationNone)); err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+
+	resp, err := caller.Call(context.Background(), "test_inherit", Req{})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if _, ok := resp["_error"]; ok != true {
+		t.Errorf("inherited strict validation must reply error: %v", resp)
+	}
+	resp, err = caller.Call(context.Background(), "test_none", Req{})
+	if err != nil {
+		t.Fatalf("no error: %+v", err)
+	}
+	if resp["count"] != "many" {
+		t.Errorf("ValidationNone must opt out: %v", resp)
+	}
+}
+
+func TestValidateConstraints(t *testing.T) {
+	schema := Object{
+		Properties: Properties{
+			"port":  Int{Minimum: genai.Ptr(1), Maximum: genai.Ptr(65535)},
+			"ratio": Float{Minimum: genai.Ptr(0.0), Maximum: genai.Ptr(1.0)},
+			"name":  String{MinLength: genai.Ptr(2), MaxLength: genai.Ptr(4)},
+			"code":  String{Pattern: `^[A-Z]{3}$`},
+			"at":    String{Format: "date-time"},
+			"day":   String{Format: "date"},
+			"clock": String{Format: "time"},
+			"id":    String{Format: "uuid"},
+			"hosts": StringArray{MinItems: genai.Ptr(1), MaxItems: genai.Ptr(2)},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		value     map[string]any
+		wantPaths []string
+	}{
+		{
+			name: "valid",
+			value: map[string]any{
+				"port":  float64(8080),
+				"ratio": 0.5,
+				"name":  "日本語",
+				"code":  "ABC",
+				"at":    "2026-01-02T03:04:05Z",
+				"day":   "2026-01-02",
+				"clock": "10:00:00Z",
+				"id":    "123e4567-e89b-12d3-a456-426614174000",
+				"hosts": []any{"a"},
+			},
+		},
+		{
+			name: "out of range",
+			value: map[string]any{
+				"port":  float64(0),
+				"ratio": 1.5,
+				"name":  "a",
+				"code":  "abc",
+				"at":    "2026/01/02",
+				"day":   "tomorrow",
+				"clock": "10:00",
+				"id":    "x",
+				"hosts": []any{},
+			},
+			wantPaths: []string{"$.at", "$.clock", "$.code", "$.day", "$.hosts", "$.id", "$.name", "$.port", "$.ratio"},
+		},
+		{
+			name: "too many",
+			value: map[string]any{
+				"port":  float64(70000),
+				"name":  "abcde",
+				"hosts": []any{"a", "b", "c"},
+			},
+			wantPaths: []string{"$.hosts", "$.name", "$.port"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(schema, tt.value)
+			if tt.wantPaths == nil {
+				if err != nil {
+					t.Fatalf("no error: %+v", err)
+				}
+				return
+			}
+			verr := (*ValidationError)(nil)
+			if errors.As(err, &verr) != true {
+				t.Fatalf("ValidationError required: %v", err)
+			}
+			paths := make([]string, len(verr.Violations))
+			for i, v := range verr.Violations {
+				paths[i] = v.Path
+			}
+			if slices.Equal(paths, tt.wantPaths) != true {
+				t.Errorf("paths = %v, want %v (%v)", paths, tt.wantPaths, verr.Violations)
+			}
+		})
+	}
+}
+
+func TestValidateTimeFormat(t *testing.T) {
+	schema := String{Format: "time"}
+	for _, v := range []string{"10:00:00Z", "10:00:00.123+09:00", "23:59:59-05:30", "10:00:00"} {
+		if err := Validate(schema, v); err != nil {
+			t.Errorf("%s must be valid: %+v", v, err)
+		}
+	}
+	for _, v := range []string{"10:00", "25:00:00Z", "10:00:00+9", "2026-01-02T10:00:00Z"} {
+		if err := Validate(schema, v); err == nil {
+			t.Errorf("%s must be invalid", v)
+		}
+	}
+}
+
+func TestValidatePatternCache(t *testing.T) {
+	schema := String{Pattern: `^[a-z]+$`}
+	for i := 0; i < 2; i += 1 {
+		if err := Validate(schema, "abc"); err != nil {
+			t.Errorf("no error: %+v", err)
+		}
+		if err := Validate(schema, "ABC"); err == nil {
+			t.Errorf("must be error")
+		}
+	}
+	if _, ok := patternCache.Load(`^[a-z]+$`); ok != true {
+		t.Errorf("pattern must be cached")
+	}
+
+	invalid := String{Pattern: `([a-z`}
+	for i := 0; i < 2; i += 1 {
+		if err := Validate(invalid, "abc"); err == nil {
+			t.Errorf("invalid pattern must be error")
+		}
+	}
+}
+
+func TestValidateUnion(t *testing.T) {
+	schema := Object{
+		Properties: Properties{
+			"hosts": AnyOf{
+				Variants: []TypeDef{String{}, StringArray{}},
+			},
+			"id": OneOf{
+				Variants: []TypeDef{Int{}, Float{}, String{}},
+			},
+		},
+	}
+	tests := []struct {
+		name    string
+		value   map[string]any
+		wantErr bool
+	}{
+		{"anyOf string", map[string]any{"hosts": "host1"}, false},
+		{"anyOf array", map[string]any{"hosts": []any{"host1", "host2"}}, false},
+		{"anyOf mismatch", map[string]any{"hosts": float64(1)}, true},
+		{"oneOf string", map[string]any{"id": "abc"}, false},
+		{"oneOf ambiguous", map[string]any{"id": float64(1)}, true},
+		{"oneOf float", map[string]any{"id": 1.5}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(schema, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRef(t *testing.T) {
+	schema := testFilterSchema()
+	tests := []struct {
+		name      string
+		value     map[string]any
+		wantPaths []string
+	}{
+		{
+			name: "nested",
+			value: map[string]any{"filter": map[string]any{
+				"and": []any{
+					map[string]any{"field": "name", "value": "foo"},
+					map[string]any{"or": []any{
+						map[string]any{"field": "age", "value": "20"},
+					}},
+				},
+			}},
+		},
+		{
+			name: "deep violation",
+			value: map[string]any{"filter": map[string]any{
+				"and": []any{
+					map[string]any{"or": []any{
+						map[string]any{"field": float64(1)},
+					}},
+				},
+			}},
+			wantPaths: []string{"$.filter.and[0].or[0].field"},
+		},
+		{
+			name:      "missing",
+			value:     map[string]any{},
+			wantPaths: []string{"$.filter"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(schema, tt.value)
+			if len(tt.wantPaths) < 1 {
+				if err != nil {
+					t.Errorf("no error: %+v", err)
+				}
+				return
+			}
+			verr, ok := err.(*ValidationError)
+			if ok != true {
+				t.Fatalf("ValidationError required: %v", err)
+			}
+			paths := make([]string, len(verr.Violations))
+			for i, v := range verr.Violations {
+				paths[i] = v.Path
+			}
+			if slices.Equal(paths, tt.wantPaths) != true {
+				t.Errorf("paths = %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+
+	t.Run("unresolvable", func(t *testing.T) {
+		err := Validate(Object{Properties: Properties{"x": Ref{Name: "Missing"}}}, map[string]any{"x": 1})
+		if err == nil {
+			t.Errorf("unresolvable $ref must be error")
+		}
+	})
+}